@@ -3,6 +3,7 @@ package main
 import (
 	log "github.com/sirupsen/logrus"
 	"myproject/internal/config"
+	"myproject/internal/models"
 	"myproject/internal/repositories/queue"
 	"myproject/internal/services/agent"
 	"os"
@@ -26,7 +27,7 @@ func init() {
 // Start инициализирует и запускате агента
 func Start() {
 	cfg := config.MustLoad()
-	expressionsQueueRepo, err := queue.NewRabbitMQRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithTasks)
+	expressionsQueueRepo, err := queue.NewRabbitMQPriorityRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithTasks, models.PriorityMax)
 	if err != nil {
 		log.Fatalf("Failed to start queue: %v", err)
 		return
@@ -42,12 +43,17 @@ func Start() {
 		log.Fatalf("Failed to start queue: %v", err)
 		return
 	}
-	rpcQueueRepo, err := queue.NewRabbitMQRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithRPC)
+	leaseQueueRepo, err := queue.NewRabbitMQRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithLeases)
 	if err != nil {
 		log.Fatalf("Failed to start queue: %v", err)
 		return
 	}
-	a := agent.NewAgent(expressionsQueueRepo, calculationQueueRepo, heartbeatQueueRepo, rpcQueueRepo, cfg.CalculationTimeouts)
+	cancellationQueueRepo, err := queue.NewRabbitMQFanoutRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithCancellations)
+	if err != nil {
+		log.Fatalf("Failed to start queue: %v", err)
+		return
+	}
+	a := agent.NewAgent(expressionsQueueRepo, calculationQueueRepo, heartbeatQueueRepo, leaseQueueRepo, cancellationQueueRepo, cfg.CalculationTimeouts, cfg.Leases.TTL)
 	a.Start()
 }
 
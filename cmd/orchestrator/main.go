@@ -7,10 +7,14 @@ import (
 	"log/slog"
 	"myproject/internal/app"
 	"myproject/internal/config"
+	"myproject/internal/models"
 	"myproject/internal/repositories/agent"
 	appRepo "myproject/internal/repositories/app"
 	"myproject/internal/repositories/expression"
+	"myproject/internal/repositories/formula"
 	"myproject/internal/repositories/queue"
+	"myproject/internal/repositories/quota"
+	"myproject/internal/repositories/resultCache"
 	"myproject/internal/repositories/subExpression"
 	"myproject/internal/repositories/user"
 	"myproject/internal/services/auth"
@@ -50,13 +54,23 @@ func Start() {
 		log.Fatalf("Failed to connect postgres: %v", err)
 		return
 	}
+	formulaRepo, err := formula.NewPostgresRepository(dataSourceName)
+	if err != nil {
+		log.Fatalf("Failed to connect postgres: %v", err)
+		return
+	}
+	quotaRepo, err := quota.NewPostgresRepository(dataSourceName)
+	if err != nil {
+		log.Fatalf("Failed to connect postgres: %v", err)
+		return
+	}
 	agentRepo, err := agent.NewPostgresRepository(dataSourceName)
 	if err != nil {
 		log.Fatalf("Failed to connect agent postgres: %v", err)
 		return
 	}
 
-	expressionsQueueRepo, err := queue.NewRabbitMQRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithTasks)
+	expressionsQueueRepo, err := queue.NewRabbitMQPriorityRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithTasks, models.PriorityMax)
 	if err != nil {
 		log.Fatalf("Failed to start queue: %v", err)
 	}
@@ -68,7 +82,11 @@ func Start() {
 	if err != nil {
 		log.Fatalf("Failed to start queue: %v", err)
 	}
-	rpcQueueRepository, err := queue.NewRabbitMQRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithRPC)
+	leaseQueueRepository, err := queue.NewRabbitMQRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithLeases)
+	if err != nil {
+		log.Fatalf("Failed to start queue: %v", err)
+	}
+	cancellationsQueueRepository, err := queue.NewRabbitMQFanoutRepository(cfg.UrlRabbit, cfg.Queue.NameQueueWithCancellations)
 	if err != nil {
 		log.Fatalf("Failed to start queue: %v", err)
 	}
@@ -81,13 +99,25 @@ func Start() {
 		log.Fatalf("Failed to start queue: %v", err)
 	}
 
+	var resultCacheRepo resultCache.Repository
+	switch cfg.ResultCache.Backend {
+	case "memory":
+		resultCacheRepo = resultCache.NewMemoryRepository(cfg.ResultCache.Size, cfg.ResultCache.TTL)
+	case "postgres":
+		resultCacheRepo, err = resultCache.NewPostgresRepository(dataSourceName, cfg.ResultCache.Size, cfg.ResultCache.TTL)
+		if err != nil {
+			log.Fatalf("Failed to connect result cache postgres: %v", err)
+		}
+	}
+
 	ctx := context.Background()
 	logSlog := slog.New(
 		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
 	)
 
-	newOrchestrator := orchestrator.NewOrchestrator(ctx, expressionRepo, subExpressionRepo, expressionsQueueRepo,
-		calculationsQueueRepository, heartbeatsQueueRepository, rpcQueueRepository, agentRepo, cfg.RetrySubExpressionTimout)
+	newOrchestrator := orchestrator.NewOrchestrator(ctx, expressionRepo, subExpressionRepo, formulaRepo, quotaRepo, expressionsQueueRepo,
+		calculationsQueueRepository, heartbeatsQueueRepository, leaseQueueRepository, cancellationsQueueRepository, agentRepo, resultCacheRepo, cfg.Leases, cfg.Retries, cfg.PriorityAging,
+		cfg.Quotas)
 	newAuth := auth.New(logSlog, userRepository, userRepository, appRepository, cfg.TokenTTL)
 
 	// Регистрация хендлеров
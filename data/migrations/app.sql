@@ -5,4 +5,4 @@ CREATE TABLE IF NOT EXISTS apps
     secret Varchar
 );
 
-INSERT INTO apps(id, name, secret) VALUES (1, 'orchestrator', 'une-3r0yj*1+le22$x2y8=q%nag2q1(8brlbmmr(6ixh_$qa-#')
+INSERT INTO apps(id, name, secret) VALUES (1, 'orchestrator', 'une-3r0yj*1+le22$x2y8=q%nag2q1(8brlbmmr(6ixh_$qa-#') ON CONFLICT (id) DO NOTHING;
@@ -6,8 +6,64 @@ CREATE TABLE IF NOT EXISTS expressions
     user_id VARCHAR(255) NOT NULL,
     idempotency_key VARCHAR(255) NOT NULL,
     value TEXT NOT NULL,
+    bindings JSONB,
+    formula_versions JSONB,
     state VARCHAR(50) NOT NULL,
+    mode VARCHAR(20) NOT NULL DEFAULT 'float',
     result DOUBLE PRECISION,
+    result_exact NUMERIC,
+    result_unit VARCHAR(50) NOT NULL DEFAULT '',
+    result_im DOUBLE PRECISION NOT NULL DEFAULT 0,
+    result_matrix JSONB,
+    sub_expressions_done INTEGER NOT NULL DEFAULT 0,
+    sub_expressions_total INTEGER NOT NULL DEFAULT 0,
+    error_reason TEXT,
+    deadline TIMESTAMPTZ,
+    priority SMALLINT NOT NULL DEFAULT 5,
     created_at timestamp NOT NULL DEFAULT NOW(),
     UNIQUE (user_id, idempotency_key)
 );
+
+-- Колонки, добавленные после первой версии таблицы: CREATE TABLE IF NOT EXISTS не меняет уже созданную таблицу
+ALTER TABLE expressions
+    ADD COLUMN IF NOT EXISTS bindings JSONB,
+    ADD COLUMN IF NOT EXISTS formula_versions JSONB,
+    ADD COLUMN IF NOT EXISTS mode VARCHAR(20) NOT NULL DEFAULT 'float',
+    ADD COLUMN IF NOT EXISTS result_exact NUMERIC,
+    ADD COLUMN IF NOT EXISTS result_unit VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS result_im DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS result_matrix JSONB,
+    ADD COLUMN IF NOT EXISTS sub_expressions_done INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS sub_expressions_total INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS error_reason TEXT,
+    ADD COLUMN IF NOT EXISTS deadline TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 5;
+
+-- Подсчет выражений пользователя за сутки для квоты
+CREATE INDEX IF NOT EXISTS expressions_user_created_at ON expressions (user_id, created_at);
+
+-- Поиск выражений с истекшим сроком
+CREATE INDEX IF NOT EXISTS expressions_deadline ON expressions (deadline) WHERE state = 'in_progress';
+
+-- Уведомление подписчиков об изменении состояния, прогресса или результата выражения
+CREATE OR REPLACE FUNCTION notify_expression_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('expressions_channel', json_build_object(
+        'id', NEW.id::text,
+        'user_id', NEW.user_id
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS expression_trigger_update ON expressions;
+
+CREATE TRIGGER expression_trigger_update
+AFTER UPDATE ON expressions
+FOR EACH ROW
+WHEN (OLD.state IS DISTINCT FROM NEW.state OR
+      OLD.result IS DISTINCT FROM NEW.result OR
+      OLD.sub_expressions_done IS DISTINCT FROM NEW.sub_expressions_done OR
+      OLD.sub_expressions_total IS DISTINCT FROM NEW.sub_expressions_total)
+EXECUTE PROCEDURE notify_expression_changes();
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS formulas
+(
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    params TEXT[] NOT NULL,
+    body TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    created_at timestamp NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name, version)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS result_cache
+(
+    key        VARCHAR(64) PRIMARY KEY,
+    result     JSONB NOT NULL,
+    expires_at timestamp NOT NULL,
+    used_at    timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS result_cache_used_at ON result_cache (used_at);
@@ -0,0 +1,17 @@
+-- История отправок subexpressions агентам: попытка создается при выдаче аренды и завершается результатом
+-- агента или истечением аренды. Хранится после удаления subexpressions, чтобы можно было разобрать,
+-- почему выражение завершилось ошибкой после исчерпания попыток
+CREATE TABLE IF NOT EXISTS sub_expression_attempts
+(
+    sub_expression_id UUID NOT NULL,
+    expression_id     UUID NOT NULL,
+    attempt           INTEGER NOT NULL,
+    agent_id          UUID,
+    started_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at       TIMESTAMPTZ,
+    -- completed - агент вернул результат, failed - агент вернул ошибку подсчета, expired - аренда истекла
+    outcome           VARCHAR(16),
+    PRIMARY KEY (sub_expression_id, attempt)
+);
+
+CREATE INDEX IF NOT EXISTS sub_expression_attempts_expression_id ON sub_expression_attempts (expression_id);
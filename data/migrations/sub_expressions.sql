@@ -11,14 +11,64 @@ CREATE TABLE IF NOT EXISTS sub_expressions
     is_last            BOOL,
     error              BOOL,
     agent_id           UUID,
+    args               DOUBLE PRECISION[],
+    arg_ids            UUID[],
+    mode               VARCHAR(20) NOT NULL DEFAULT 'float',
+    val1_exact         NUMERIC,
+    val2_exact         NUMERIC,
+    args_exact         NUMERIC[],
+    result_exact       NUMERIC,
+    guard_id           UUID,
+    guard_branch       BOOL,
+    val1_im            DOUBLE PRECISION NOT NULL DEFAULT 0,
+    val2_im            DOUBLE PRECISION NOT NULL DEFAULT 0,
+    args_im            DOUBLE PRECISION[],
+    result_im          DOUBLE PRECISION,
+    val1_matrix        JSONB,
+    val2_matrix        JSONB,
+    args_matrix        JSONB,
+    priority           SMALLINT NOT NULL DEFAULT 5,
+    attempts           INTEGER NOT NULL DEFAULT 0,
+    lease_expires_at   TIMESTAMPTZ,
+    -- retry_at время повторной отправки subexpression, аренда которого истекла
+    retry_at           TIMESTAMPTZ,
     created_at timestamp NOT NULL DEFAULT NOW()
 );
 
+-- Колонки, добавленные после первой версии таблицы: CREATE TABLE IF NOT EXISTS не меняет уже созданную таблицу
+ALTER TABLE sub_expressions
+    ADD COLUMN IF NOT EXISTS args DOUBLE PRECISION[],
+    ADD COLUMN IF NOT EXISTS arg_ids UUID[],
+    ADD COLUMN IF NOT EXISTS mode VARCHAR(20) NOT NULL DEFAULT 'float',
+    ADD COLUMN IF NOT EXISTS val1_exact NUMERIC,
+    ADD COLUMN IF NOT EXISTS val2_exact NUMERIC,
+    ADD COLUMN IF NOT EXISTS args_exact NUMERIC[],
+    ADD COLUMN IF NOT EXISTS result_exact NUMERIC,
+    ADD COLUMN IF NOT EXISTS guard_id UUID,
+    ADD COLUMN IF NOT EXISTS guard_branch BOOL,
+    ADD COLUMN IF NOT EXISTS val1_im DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS val2_im DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS args_im DOUBLE PRECISION[],
+    ADD COLUMN IF NOT EXISTS result_im DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS val1_matrix JSONB,
+    ADD COLUMN IF NOT EXISTS val2_matrix JSONB,
+    ADD COLUMN IF NOT EXISTS args_matrix JSONB,
+    ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 5,
+    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS retry_at TIMESTAMPTZ;
+
+-- Поиск истекших аренд subexpressions агентами
+CREATE INDEX IF NOT EXISTS sub_expressions_lease_expires_at ON sub_expressions (lease_expires_at) WHERE result IS NULL;
+CREATE INDEX IF NOT EXISTS sub_expressions_retry_at ON sub_expressions (retry_at) WHERE result IS NULL;
+
 -- Функция для отправки уведомлений
 CREATE OR REPLACE FUNCTION notify_sub_expression_fields()
 RETURNS TRIGGER AS $$
 BEGIN
-    IF NEW.sub_expression_id1 IS NULL AND NEW.sub_expression_id2 IS NULL AND NEW.result IS NULL THEN
+    IF NEW.sub_expression_id1 IS NULL AND NEW.sub_expression_id2 IS NULL AND NEW.result IS NULL
+       AND cardinality(array_remove(coalesce(NEW.arg_ids, '{}'), NULL)) = 0
+       AND NEW.guard_id IS NULL THEN
        PERFORM pg_notify('sub_expressions_channel', json_build_object(
             'id', NEW.id::text,
             'expressions_id', NEW.expressions_id::text,
@@ -36,15 +86,62 @@ END;
 $$ LANGUAGE plpgsql;
 
 
+DROP TRIGGER IF EXISTS sub_expression_trigger_update ON sub_expressions;
+
 CREATE TRIGGER sub_expression_trigger_update
 AFTER UPDATE ON sub_expressions
 FOR EACH ROW
 WHEN (OLD.val1 IS DISTINCT FROM NEW.val1 OR
       OLD.val2 IS DISTINCT FROM NEW.val2 OR
       OLD.sub_expression_id1 IS DISTINCT FROM NEW.sub_expression_id1 OR
-      OLD.sub_expression_id2 IS DISTINCT FROM NEW.sub_expression_id2)
+      OLD.sub_expression_id2 IS DISTINCT FROM NEW.sub_expression_id2 OR
+      OLD.args IS DISTINCT FROM NEW.args OR
+      OLD.arg_ids IS DISTINCT FROM NEW.arg_ids OR
+      OLD.guard_id IS DISTINCT FROM NEW.guard_id)
 EXECUTE PROCEDURE notify_sub_expression_fields();
 
+DROP TRIGGER IF EXISTS sub_expression_trigger_insert ON sub_expressions;
+
 CREATE TRIGGER sub_expression_trigger_insert
 AFTER INSERT ON sub_expressions
-FOR EACH ROW EXECUTE PROCEDURE notify_sub_expression_fields()
\ No newline at end of file
+FOR EACH ROW EXECUTE PROCEDURE notify_sub_expression_fields();
+
+-- Прогресс выражения: посчитанные subexpressions. Количество созданных subexpressions оркестратор записывает
+-- одним обновлением после разбиения выражения, неподсчитанный subexpression удаляется вместе с невыбранной
+-- веткой if, посчитанные удаляются только после подсчета выражения
+DROP TRIGGER IF EXISTS sub_expression_progress_insert ON sub_expressions;
+
+CREATE OR REPLACE FUNCTION count_sub_expression_progress()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE expressions SET sub_expressions_done = sub_expressions_done + 1 WHERE id = NEW.expressions_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Неподсчитанные subexpressions, удаленные одним запросом, вычитаются одним обновлением каждого выражения
+CREATE OR REPLACE FUNCTION count_deleted_sub_expressions()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE expressions SET sub_expressions_total = sub_expressions_total - deleted.count
+    FROM (SELECT expressions_id, count(*) AS count FROM deleted_sub_expressions
+          WHERE result IS NULL GROUP BY expressions_id) deleted
+    WHERE expressions.id = deleted.expressions_id AND expressions.state = 'in_progress';
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS sub_expression_progress_result ON sub_expressions;
+
+CREATE TRIGGER sub_expression_progress_result
+AFTER UPDATE ON sub_expressions
+FOR EACH ROW
+WHEN (OLD.result IS NULL AND NEW.result IS NOT NULL)
+EXECUTE PROCEDURE count_sub_expression_progress();
+
+DROP TRIGGER IF EXISTS sub_expression_progress_delete ON sub_expressions;
+
+CREATE TRIGGER sub_expression_progress_delete
+AFTER DELETE ON sub_expressions
+REFERENCING OLD TABLE AS deleted_sub_expressions
+FOR EACH STATEMENT EXECUTE PROCEDURE count_deleted_sub_expressions();
@@ -0,0 +1,9 @@
+-- Ограничения пользователей, для пользователей без записи действуют значения quotas из конфига.
+-- 0 в daily_expressions и max_in_flight - без ограничения
+CREATE TABLE IF NOT EXISTS user_quotas
+(
+    user_id VARCHAR(255) PRIMARY KEY,
+    daily_expressions INTEGER NOT NULL DEFAULT 0,
+    max_in_flight INTEGER NOT NULL DEFAULT 0,
+    weight INTEGER NOT NULL DEFAULT 1 CHECK (weight > 0)
+);
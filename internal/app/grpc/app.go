@@ -33,6 +33,15 @@ var (
 		"/orchestrator.Orchestrator/GetExpressions",
 		"/orchestrator.Orchestrator/GetAgents",
 		"/orchestrator.Orchestrator/GetOperators",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/CreateFormula",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/GetFormulas",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/ExplainExpression",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/GetResultCacheStats",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/CreateExpressions",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/CancelExpression",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/GetExpressionAttempts",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/WatchExpression",
+		"/" + orchestratorgrpc.ExtensionsServiceName + "/WatchMyExpressions",
 	}
 )
 
@@ -71,6 +80,10 @@ func New(
 		recovery.UnaryServerInterceptor(recoveryOpts...),
 		logging.UnaryServerInterceptor(InterceptorLogger(log), loggingOpts...),
 		selector.UnaryServerInterceptor(authgrpc.JWTMiddleware(appRepo), selector.MatchFunc(checkGrpcNameForJWT)),
+	), grpc.ChainStreamInterceptor(
+		recovery.StreamServerInterceptor(recoveryOpts...),
+		logging.StreamServerInterceptor(InterceptorLogger(log), loggingOpts...),
+		selector.StreamServerInterceptor(authgrpc.JWTStreamMiddleware(appRepo), selector.MatchFunc(checkGrpcNameForJWT)),
 	))
 
 	authgrpc.Register(gRPCServer, authService)
@@ -9,15 +9,60 @@ import (
 )
 
 type Config struct {
-	Env                      string                    `yaml:"env" env-default:"local"`
-	UrlRabbit                string                    `yaml:"url_rabbit" env-required:"true"`
-	Queue                    QueueConfig               `yaml:"queue"`
-	CalculationTimeouts      CalculationTimeoutsConfig `yaml:"calculation_timeouts"`
-	GRPC                     GRPCConfig                `yaml:"grpc"`
-	HTTP                     HTTPConfig                `yaml:"http"`
-	Postgres                 PostgresConfig            `yaml:"postgres"`
-	TokenTTL                 time.Duration             `yaml:"token_ttl" env-default:"1h"`
-	RetrySubExpressionTimout time.Duration             `yaml:"retry_sub_expression_timout" env-default:"40s"`
+	Env                 string                    `yaml:"env" env-default:"local"`
+	UrlRabbit           string                    `yaml:"url_rabbit" env-required:"true"`
+	Queue               QueueConfig               `yaml:"queue"`
+	CalculationTimeouts CalculationTimeoutsConfig `yaml:"calculation_timeouts"`
+	GRPC                GRPCConfig                `yaml:"grpc"`
+	HTTP                HTTPConfig                `yaml:"http"`
+	Postgres            PostgresConfig            `yaml:"postgres"`
+	TokenTTL            time.Duration             `yaml:"token_ttl" env-default:"1h"`
+	ResultCache         ResultCacheConfig         `yaml:"result_cache"`
+	// PriorityAging время ожидания, за которое приоритет subexpression повышается на 1, чтобы выражения
+	// с низким приоритетом не ждали бесконечно. 0 - приоритет не повышается
+	PriorityAging time.Duration `yaml:"priority_aging" env-default:"30s"`
+	Quotas        QuotasConfig  `yaml:"quotas"`
+	Leases        LeasesConfig  `yaml:"leases"`
+	Retries       RetriesConfig `yaml:"retries"`
+}
+
+// RetriesConfig повторные отправки subexpressions с истекшей арендой. Перед попыткой n+1 subexpression ждет
+// Backoff*2^(n-1), но не больше MaxBackoff (0 - без ограничения), время ожидания случайно отклоняется
+// на долю Jitter, чтобы subexpressions, аренды которых истекли одновременно (например, умер агент),
+// не отправлялись разом
+type RetriesConfig struct {
+	// MaxAttempts сколько раз subexpression отправляется агентам, после чего выражение завершается с ошибкой
+	MaxAttempts int           `yaml:"max_attempts" env-default:"5"`
+	Backoff     time.Duration `yaml:"backoff" env-default:"1s"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" env-default:"1m"`
+	Jitter      float64       `yaml:"jitter" env-default:"0.2"`
+}
+
+// LeasesConfig аренды subexpressions: оркестратор отправляет subexpression агентам повторно (с тем же id),
+// если аренда истекла и результат не получен
+type LeasesConfig struct {
+	// DispatchTTL срок аренды с отправки subexpression в очередь до того, как агент возьмет его на обработку
+	DispatchTTL time.Duration `yaml:"dispatch_ttl" env-default:"10m"`
+	// TTL срок аренды, на который агент продлевает ее, пока считает subexpression
+	TTL time.Duration `yaml:"ttl" env-default:"30s"`
+}
+
+// QuotasConfig ограничения пользователей, для которых нет записи в таблице user_quotas. 0 - без ограничения
+type QuotasConfig struct {
+	DailyExpressions   int `yaml:"daily_expressions" env-default:"0"`
+	MaxInFlightPerUser int `yaml:"max_in_flight_per_user" env-default:"16"`
+	Weight             int `yaml:"weight" env-default:"1"`
+	// MaxInFlight наибольшее количество отправленных агентам и еще не посчитанных subexpressions всех пользователей:
+	// остальные ждут в оркестраторе, где очередность определяется справедливым распределением между пользователями
+	MaxInFlight int `yaml:"max_in_flight" env-default:"32"`
+}
+
+// ResultCacheConfig кэш результатов subexpressions, общий для всех выражений
+type ResultCacheConfig struct {
+	// Backend хранилище кэша: "memory" (в памяти оркестратора), "postgres" (таблица result_cache) или "off"
+	Backend string        `yaml:"backend" env-default:"memory"`
+	Size    int           `yaml:"size" env-default:"10000"`
+	TTL     time.Duration `yaml:"ttl" env-default:"1h"`
 }
 
 type GRPCConfig struct {
@@ -29,17 +74,41 @@ type HTTPConfig struct {
 	Port int `yaml:"port"`
 }
 type QueueConfig struct {
+	// NameQueueWithTasks очередь с приоритетами, из которой агенты берут subexpressions. Очередь без приоритетов
+	// с тем же именем RabbitMQ не переобъявляет, поэтому имя отличается от очереди tasks прежних версий
 	NameQueueWithTasks         string `yaml:"name_queue_with_tasks"`
 	NameQueueWithFinishedTasks string `yaml:"name_queue_with_finished_tasks"`
 	NameQueueWithHeartbeats    string `yaml:"name_queue_with_heartbeats"`
-	NameQueueWithRPC           string `yaml:"name_queue_with_rpc"`
+	// NameQueueWithLeases очередь, через которую агенты продлевают аренды считаемых subexpressions
+	NameQueueWithLeases string `yaml:"name_queue_with_leases" env-default:"task_leases"`
+	// NameQueueWithCancellations fanout exchange, через который оркестратор сообщает всем агентам об отмене выражений
+	NameQueueWithCancellations string `yaml:"name_queue_with_cancellations" env-default:"cancellations"`
 }
 
 type CalculationTimeoutsConfig struct {
-	TimeCalculatePlus   time.Duration `yaml:"time_calculate_plus"`
-	TimeCalculateMinus  time.Duration `yaml:"time_calculate_minus"`
-	TimeCalculateMult   time.Duration `yaml:"time_calculate_mult"`
-	TimeCalculateDivide time.Duration `yaml:"time_calculate_divide"`
+	TimeCalculatePlus      time.Duration `yaml:"time_calculate_plus"`
+	TimeCalculateMinus     time.Duration `yaml:"time_calculate_minus"`
+	TimeCalculateMult      time.Duration `yaml:"time_calculate_mult"`
+	TimeCalculateDivide    time.Duration `yaml:"time_calculate_divide"`
+	TimeCalculatePow       time.Duration `yaml:"time_calculate_pow"`
+	TimeCalculateMod       time.Duration `yaml:"time_calculate_mod"`
+	TimeCalculateIntDivide time.Duration `yaml:"time_calculate_int_divide"`
+	TimeCalculateSqrt      time.Duration `yaml:"time_calculate_sqrt"`
+	TimeCalculateAbs       time.Duration `yaml:"time_calculate_abs"`
+	TimeCalculateMin       time.Duration `yaml:"time_calculate_min"`
+	TimeCalculateMax       time.Duration `yaml:"time_calculate_max"`
+	TimeCalculateRound     time.Duration `yaml:"time_calculate_round"`
+	TimeCalculateLog       time.Duration `yaml:"time_calculate_log"`
+	TimeCalculateSin       time.Duration `yaml:"time_calculate_sin"`
+	TimeCalculateCos       time.Duration `yaml:"time_calculate_cos"`
+	// TimeCalculateComparison время подсчета сравнений (<, <=, >, >=, ==, !=)
+	TimeCalculateComparison time.Duration `yaml:"time_calculate_comparison"`
+	// TimeCalculateLogical время подсчета логического отрицания (!)
+	TimeCalculateLogical time.Duration `yaml:"time_calculate_logical"`
+	// TimeCalculateIf время выбора значения ветки if после подсчета условия
+	TimeCalculateIf time.Duration `yaml:"time_calculate_if"`
+	// TimeCalculateMatrix время подсчета dot, matmul (целиком или одного блока), transpose, slice и blocks
+	TimeCalculateMatrix time.Duration `yaml:"time_calculate_matrix"`
 }
 
 type PostgresConfig struct {
@@ -3,6 +3,7 @@ package authgrpc
 import (
 	"context"
 	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -13,34 +14,56 @@ import (
 
 func JWTMiddleware(appRepo app.Repository) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
+		ctx, err := authenticate(ctx, appRepo)
+		if err != nil {
+			return nil, err
 		}
+		// Если токен действителен, продолжайте обработку запроса
+		return handler(ctx, req)
+	}
+}
 
-		values := md["authorization"]
-		if len(values) == 0 {
-			return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+// JWTStreamMiddleware то же, что JWTMiddleware, для server-streaming методов
+func JWTStreamMiddleware(appRepo app.Repository) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), appRepo)
+		if err != nil {
+			return err
 		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
 
-		token := values[0]
+// authenticate проверяет JWT-токен из заголовка authorization и добавляет в контекст id пользователя
+func authenticate(ctx context.Context, appRepo app.Repository) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	}
 
-		// Проверка токена
-		// Здесь должен быть ваш код для проверки токена
-		// Например, вы можете использовать библиотеку для работы с JWT
-		// Если токен недействителен, верните ошибку
-		err, jwtToken := jwt.ProcessJWT(ctx, token, appRepo)
-		if err != nil {
-			return nil, status.Errorf(codes.Unauthenticated, "invalid token")
-		}
-		if claims, ok := jwtToken.Claims.(gojwt.MapClaims); ok {
-			userId, ok := claims["uid"].(float64)
-			if ok {
-				// Извлечение данных из токена
-				ctx = context.WithValue(ctx, "userID", userId)
-			}
+	values := md["authorization"]
+	if len(values) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+	}
+
+	token := values[0]
+
+	// Проверка токена
+	// Здесь должен быть ваш код для проверки токена
+	// Например, вы можете использовать библиотеку для работы с JWT
+	// Если токен недействителен, верните ошибку
+	err, jwtToken := jwt.ProcessJWT(ctx, token, appRepo)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+	}
+	if claims, ok := jwtToken.Claims.(gojwt.MapClaims); ok {
+		userId, ok := claims["uid"].(float64)
+		if ok {
+			// Извлечение данных из токена
+			ctx = context.WithValue(ctx, "userID", userId)
 		}
-		// Если токен действителен, продолжайте обработку запроса
-		return handler(ctx, req)
 	}
+	return ctx, nil
 }
@@ -0,0 +1,338 @@
+package orchestratorgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"myproject/internal/models"
+	"myproject/internal/repositories"
+	"myproject/internal/services/orchestrator"
+	"myproject/internal/services/orchestrator/utils"
+	"strconv"
+)
+
+// ExtensionsServiceName сервис с методами оркестратора, которых пока нет в s0vunia/protos (описан в
+// proto/orchestrator_extensions.proto). Для него нет сгенерированного кода, поэтому запросы и ответы -
+// google.protobuf.Struct, которые декодируются в структуры запросов через JSON
+const ExtensionsServiceName = "orchestrator.OrchestratorExtensions"
+
+// extensionsServer методы сервиса ExtensionsServiceName
+type extensionsServer interface {
+	CreateFormula(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	GetFormulas(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	ExplainExpression(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	GetResultCacheStats(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	CreateExpressions(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	CancelExpression(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	GetExpressionAttempts(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	WatchExpression(in *structpb.Struct, stream grpc.ServerStream) error
+	WatchMyExpressions(in *structpb.Struct, stream grpc.ServerStream) error
+}
+
+type extensionsAPI struct {
+	orchestrator orchestrator.IOrchestrator
+}
+
+// extensionsServiceDesc описание сервиса, которое protoc-gen-go-grpc сгенерировал бы из proto-файла
+var extensionsServiceDesc = grpc.ServiceDesc{
+	ServiceName: ExtensionsServiceName,
+	HandlerType: (*extensionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("CreateFormula", extensionsServer.CreateFormula),
+		unaryMethod("GetFormulas", extensionsServer.GetFormulas),
+		unaryMethod("ExplainExpression", extensionsServer.ExplainExpression),
+		unaryMethod("GetResultCacheStats", extensionsServer.GetResultCacheStats),
+		unaryMethod("CreateExpressions", extensionsServer.CreateExpressions),
+		unaryMethod("CancelExpression", extensionsServer.CancelExpression),
+		unaryMethod("GetExpressionAttempts", extensionsServer.GetExpressionAttempts),
+	},
+	Streams: []grpc.StreamDesc{
+		serverStream("WatchExpression", extensionsServer.WatchExpression),
+		serverStream("WatchMyExpressions", extensionsServer.WatchMyExpressions),
+	},
+	Metadata: "proto/orchestrator_extensions.proto",
+}
+
+// unaryMethod описание метода name сервиса ExtensionsServiceName с обработчиком method
+func unaryMethod(name string, method func(extensionsServer, context.Context, *structpb.Struct) (*structpb.Struct, error)) grpc.MethodDesc {
+	fullMethod := "/" + ExtensionsServiceName + "/" + name
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &structpb.Struct{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return method(srv.(extensionsServer), ctx, req.(*structpb.Struct))
+			}
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, handler)
+		},
+	}
+}
+
+// serverStream описание server-streaming метода name сервиса ExtensionsServiceName с обработчиком method
+func serverStream(name string, method func(extensionsServer, *structpb.Struct, grpc.ServerStream) error) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName: name,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := &structpb.Struct{}
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return method(srv.(extensionsServer), in, stream)
+		},
+		ServerStreams: true,
+	}
+}
+
+// decodeRequest декодирует запрос в структуру request по ее json-тегам
+func decodeRequest(in *structpb.Struct, request interface{}) error {
+	data, err := in.MarshalJSON()
+	if err == nil {
+		err = json.Unmarshal(data, request)
+	}
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid request: "+err.Error())
+	}
+	return nil
+}
+
+// encodeResponse кодирует ответ по json-тегам его полей
+func encodeResponse(response interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+	out := &structpb.Struct{}
+	if err := out.UnmarshalJSON(data); err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+	return out, nil
+}
+
+// requestUserId возвращает id пользователя, добавленный в контекст JWTMiddleware
+func requestUserId(ctx context.Context) string {
+	userID := ctx.Value("userID").(float64)
+	return strconv.Itoa(int(userID))
+}
+
+type createFormulaRequest struct {
+	Definition string `json:"definition"`
+}
+
+func (s *extensionsAPI) CreateFormula(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var request createFormulaRequest
+	if err := decodeRequest(in, &request); err != nil {
+		return nil, err
+	}
+	if request.Definition == "" {
+		return nil, status.Error(codes.InvalidArgument, "definition is required")
+	}
+	formula, err := s.orchestrator.CreateFormula(ctx, request.Definition, requestUserId(ctx))
+	var parseErr *orchestratorutils.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, invalidExpressionError(err)
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to create formula")
+	}
+	return encodeResponse(formula)
+}
+
+func (s *extensionsAPI) GetFormulas(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	formulas, err := s.orchestrator.GetFormulas(ctx, requestUserId(ctx))
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to get formulas")
+	}
+	return encodeResponse(struct {
+		Formulas []*models.Formula `json:"formulas"`
+	}{Formulas: formulas})
+}
+
+type explainExpressionRequest struct {
+	Expression string             `json:"expression"`
+	Bindings   map[string]float64 `json:"bindings"`
+	Mode       string             `json:"mode"`
+}
+
+func (s *extensionsAPI) ExplainExpression(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var request explainExpressionRequest
+	if err := decodeRequest(in, &request); err != nil {
+		return nil, err
+	}
+	if request.Expression == "" {
+		return nil, status.Error(codes.InvalidArgument, "expression is required")
+	}
+	mode, err := parseMode(request.Mode)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	plan, err := s.orchestrator.ExplainExpression(ctx, request.Expression, requestUserId(ctx), request.Bindings, mode)
+	var parseErr *orchestratorutils.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, invalidExpressionError(err)
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to explain expression")
+	}
+	return encodeResponse(plan)
+}
+
+func (s *extensionsAPI) GetResultCacheStats(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	stats, err := s.orchestrator.GetResultCacheStats(ctx)
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to get result cache stats")
+	}
+	return encodeResponse(stats)
+}
+
+type createExpressionsRequest struct {
+	Expressions []*models.ExpressionBatchItem `json:"expressions"`
+}
+
+// createExpressionsResult результат создания выражения из пакета: id выражения либо текст ошибки
+type createExpressionsResult struct {
+	ExpressionId string `json:"expressionId,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (s *extensionsAPI) CreateExpressions(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var request createExpressionsRequest
+	if err := decodeRequest(in, &request); err != nil {
+		return nil, err
+	}
+	if len(request.Expressions) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "expressions are required")
+	}
+	if len(request.Expressions) > orchestrator.MaxExpressionsBatch {
+		return nil, status.Errorf(codes.InvalidArgument, "too many expressions: %d, max %d", len(request.Expressions), orchestrator.MaxExpressionsBatch)
+	}
+	for i, item := range request.Expressions {
+		if item == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "expressions[%d] is null", i)
+		}
+	}
+	results, err := s.orchestrator.CreateExpressions(ctx, request.Expressions, requestUserId(ctx))
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to create expressions")
+	}
+	response := make([]createExpressionsResult, len(results))
+	for i, result := range results {
+		response[i].ExpressionId = result.ExpressionId
+		if result.Error != nil {
+			response[i].Error = result.Error.Error()
+		}
+	}
+	return encodeResponse(struct {
+		Results []createExpressionsResult `json:"results"`
+	}{Results: response})
+}
+
+type cancelExpressionRequest struct {
+	ExpressionId string `json:"expressionId"`
+}
+
+func (s *extensionsAPI) CancelExpression(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var request cancelExpressionRequest
+	if err := decodeRequest(in, &request); err != nil {
+		return nil, err
+	}
+	if request.ExpressionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "expressionId is required")
+	}
+	err := s.orchestrator.CancelExpression(ctx, request.ExpressionId, requestUserId(ctx))
+	switch {
+	case errors.Is(err, repositories.ErrExpressionNotFound):
+		return nil, status.Error(codes.NotFound, "expression not found")
+	case errors.Is(err, repositories.ErrExpressionFinished):
+		return nil, status.Error(codes.FailedPrecondition, "expression is already finished")
+	case err != nil:
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to cancel expression")
+	}
+	return &structpb.Struct{}, nil
+}
+
+type getExpressionAttemptsRequest struct {
+	ExpressionId string `json:"expressionId"`
+}
+
+func (s *extensionsAPI) GetExpressionAttempts(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var request getExpressionAttemptsRequest
+	if err := decodeRequest(in, &request); err != nil {
+		return nil, err
+	}
+	if request.ExpressionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "expressionId is required")
+	}
+	attempts, err := s.orchestrator.GetExpressionAttempts(ctx, request.ExpressionId, requestUserId(ctx))
+	if err != nil {
+		if errors.Is(err, repositories.ErrExpressionNotFound) {
+			return nil, status.Error(codes.NotFound, "expression not found")
+		}
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "failed to get expression attempts")
+	}
+	return encodeResponse(struct {
+		Attempts []*models.SubExpressionAttempt `json:"attempts"`
+	}{Attempts: attempts})
+}
+
+type watchExpressionRequest struct {
+	ExpressionId string `json:"expressionId"`
+}
+
+func (s *extensionsAPI) WatchExpression(in *structpb.Struct, stream grpc.ServerStream) error {
+	var request watchExpressionRequest
+	if err := decodeRequest(in, &request); err != nil {
+		return err
+	}
+	if request.ExpressionId == "" {
+		return status.Error(codes.InvalidArgument, "expressionId is required")
+	}
+	ctx := stream.Context()
+	updates, err := s.orchestrator.WatchExpression(ctx, request.ExpressionId, requestUserId(ctx))
+	if err != nil {
+		if errors.Is(err, repositories.ErrExpressionNotFound) {
+			return status.Error(codes.NotFound, "expression not found")
+		}
+		log.Error(err)
+		return status.Error(codes.Internal, "failed to watch expression")
+	}
+	return sendExpressions(stream, updates)
+}
+
+func (s *extensionsAPI) WatchMyExpressions(_ *structpb.Struct, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	return sendExpressions(stream, s.orchestrator.WatchMyExpressions(ctx, requestUserId(ctx)))
+}
+
+// sendExpressions отправляет в stream изменения выражений из updates, пока канал не закроется
+func sendExpressions(stream grpc.ServerStream, updates <-chan *models.Expression) error {
+	for expr := range updates {
+		out, err := encodeResponse(expr)
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(out); err != nil {
+			return err
+		}
+	}
+	return stream.Context().Err()
+}
@@ -0,0 +1,329 @@
+package orchestratorgrpc
+
+import (
+	"context"
+	"errors"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+	"io"
+	"myproject/internal/models"
+	"myproject/internal/repositories"
+	"myproject/internal/services/orchestrator"
+	"myproject/internal/services/orchestrator/utils"
+	"net"
+	"testing"
+)
+
+// stubFormulas хранит формулы пользователя в памяти
+type stubFormulas struct {
+	orchestrator.IOrchestrator
+	formulas []*models.Formula
+}
+
+func (o *stubFormulas) CreateFormula(_ context.Context, definition string, userId string) (*models.Formula, error) {
+	if definition == "vat(x) =" {
+		return nil, &orchestratorutils.ParseError{Message: "empty formula body"}
+	}
+	formula := &models.Formula{Id: int64(len(o.formulas) + 1), UserId: userId, Name: "vat", Params: []string{"x"}, Body: "x * 1.2", Version: 1}
+	o.formulas = append(o.formulas, formula)
+	return formula, nil
+}
+
+func (o *stubFormulas) GetFormulas(context.Context, string) ([]*models.Formula, error) {
+	return o.formulas, nil
+}
+
+// dialExtensions поднимает сервис расширений поверх orchestrator в памяти и возвращает подключение к нему
+func dialExtensions(t *testing.T, orchestrator orchestrator.IOrchestrator) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	authenticate := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(context.WithValue(ctx, "userID", float64(1)), req)
+	}
+	authenticateStream := func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = context.WithValue(stream.Context(), "userID", float64(1))
+		return handler(srv, wrapped)
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(authenticate), grpc.ChainStreamInterceptor(authenticateStream))
+	server.RegisterService(&extensionsServiceDesc, &extensionsAPI{orchestrator: orchestrator})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// invokeExtension вызывает метод сервиса расширений с запросом request
+func invokeExtension(conn *grpc.ClientConn, method string, request map[string]interface{}) (map[string]interface{}, error) {
+	in, err := structpb.NewStruct(request)
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := conn.Invoke(context.Background(), "/"+ExtensionsServiceName+"/"+method, in, out); err != nil {
+		return nil, err
+	}
+	return out.AsMap(), nil
+}
+
+func TestExtensionsFormulas(t *testing.T) {
+	conn := dialExtensions(t, &stubFormulas{})
+
+	formula, err := invokeExtension(conn, "CreateFormula", map[string]interface{}{"definition": "vat(x) = x * 1.2"})
+	require.NoError(t, err)
+	assert.Equal(t, "vat", formula["name"])
+	assert.Equal(t, "1", formula["userId"])
+	assert.Equal(t, []interface{}{"x"}, formula["params"])
+
+	_, err = invokeExtension(conn, "CreateFormula", map[string]interface{}{"definition": "vat(x) ="})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = invokeExtension(conn, "CreateFormula", map[string]interface{}{"definition": 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	formulas, err := invokeExtension(conn, "GetFormulas", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Len(t, formulas["formulas"], 1)
+}
+
+// stubPlans запоминает параметры объясняемого выражения
+type stubPlans struct {
+	orchestrator.IOrchestrator
+	bindings map[string]float64
+	mode     models.ExpressionMode
+}
+
+func (o *stubPlans) ExplainExpression(_ context.Context, _, _ string, bindings map[string]float64, mode models.ExpressionMode) (*models.ExpressionPlan, error) {
+	o.bindings, o.mode = bindings, mode
+	return &models.ExpressionPlan{
+		Before: models.PlanStats{Postfix: "x 1 * 0 +", Operations: 2, Depth: 2},
+		After:  models.PlanStats{Postfix: "x", Operations: 0, Depth: 0},
+	}, nil
+}
+
+func TestExtensionsExplainExpression(t *testing.T) {
+	stub := &stubPlans{}
+	conn := dialExtensions(t, stub)
+
+	plan, err := invokeExtension(conn, "ExplainExpression", map[string]interface{}{
+		"expression": "x*1 + 0",
+		"bindings":   map[string]interface{}{"x": 2},
+		"mode":       "exact",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"x": 2}, stub.bindings)
+	assert.Equal(t, models.ModeExact, stub.mode)
+	assert.Equal(t, float64(2), plan["before"].(map[string]interface{})["operations"])
+	assert.Equal(t, "x", plan["after"].(map[string]interface{})["postfix"])
+
+	_, err = invokeExtension(conn, "ExplainExpression", map[string]interface{}{"expression": "x*1 + 0"})
+	require.NoError(t, err)
+	assert.Equal(t, models.ModeFloat, stub.mode)
+
+	_, err = invokeExtension(conn, "ExplainExpression", map[string]interface{}{"expression": "1", "mode": "fast"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = invokeExtension(conn, "ExplainExpression", map[string]interface{}{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// stubCache возвращает фиксированную статистику кэша результатов
+type stubCache struct {
+	orchestrator.IOrchestrator
+}
+
+func (stubCache) GetResultCacheStats(context.Context) (*models.ResultCacheStats, error) {
+	return &models.ResultCacheStats{Hits: 3, Misses: 5, Size: 5}, nil
+}
+
+func TestExtensionsResultCacheStats(t *testing.T) {
+	conn := dialExtensions(t, stubCache{})
+
+	stats, err := invokeExtension(conn, "GetResultCacheStats", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"hits": float64(3), "misses": float64(5), "size": float64(5)}, stats)
+}
+
+// stubBatch запоминает пакет выражений, выражения без ключа идемпотентности возвращаются с ошибкой
+type stubBatch struct {
+	orchestrator.IOrchestrator
+	items []*models.ExpressionBatchItem
+}
+
+func (o *stubBatch) CreateExpressions(_ context.Context, items []*models.ExpressionBatchItem, _ string) ([]*models.ExpressionBatchResult, error) {
+	o.items = items
+	results := make([]*models.ExpressionBatchResult, len(items))
+	for i, item := range items {
+		results[i] = &models.ExpressionBatchResult{ExpressionId: "id-" + item.IdempotencyKey}
+		if item.IdempotencyKey == "" {
+			results[i] = &models.ExpressionBatchResult{Error: errors.New("idempotencyKey is required")}
+		}
+	}
+	return results, nil
+}
+
+func TestExtensionsCreateExpressions(t *testing.T) {
+	stub := &stubBatch{}
+	conn := dialExtensions(t, stub)
+
+	response, err := invokeExtension(conn, "CreateExpressions", map[string]interface{}{
+		"expressions": []interface{}{
+			map[string]interface{}{"expression": "2+2", "idempotencyKey": "a", "mode": "exact", "priority": 7, "deadline": "2100-01-02T03:04:05Z"},
+			map[string]interface{}{"expression": "2*2"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, stub.items, 2)
+	assert.Equal(t, models.ModeExact, stub.items[0].Mode)
+	require.NotNil(t, stub.items[0].Priority)
+	assert.Equal(t, 7, *stub.items[0].Priority)
+	require.NotNil(t, stub.items[0].Deadline)
+	assert.Equal(t, 2100, stub.items[0].Deadline.Year())
+	assert.Nil(t, stub.items[1].Priority)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"expressionId": "id-a"},
+		map[string]interface{}{"error": "idempotencyKey is required"},
+	}, response["results"])
+
+	_, err = invokeExtension(conn, "CreateExpressions", map[string]interface{}{"expressions": []interface{}{}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = invokeExtension(conn, "CreateExpressions", map[string]interface{}{"expressions": []interface{}{nil}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// stubWatch отправляет подписчику выражения "expression-id" два изменения и закрывает канал
+type stubWatch struct {
+	orchestrator.IOrchestrator
+	userId string
+}
+
+func (o *stubWatch) WatchExpression(_ context.Context, id, userId string) (<-chan *models.Expression, error) {
+	if id != "expression-id" {
+		return nil, repositories.ErrExpressionNotFound
+	}
+	o.userId = userId
+	updates := make(chan *models.Expression, 2)
+	updates <- &models.Expression{Id: id, State: models.ExpressionInProgress, SubExpressionsDone: 1, SubExpressionsTotal: 2}
+	updates <- &models.Expression{Id: id, State: models.ExpressionOk, Result: 6, SubExpressionsDone: 2, SubExpressionsTotal: 2}
+	close(updates)
+	return updates, nil
+}
+
+// watchExtension вызывает server-streaming метод сервиса расширений и возвращает все полученные сообщения
+func watchExtension(conn *grpc.ClientConn, method string, request map[string]interface{}) ([]map[string]interface{}, error) {
+	desc := &grpc.StreamDesc{StreamName: method, ServerStreams: true}
+	stream, err := conn.NewStream(context.Background(), desc, "/"+ExtensionsServiceName+"/"+method)
+	if err != nil {
+		return nil, err
+	}
+	in, err := structpb.NewStruct(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var messages []map[string]interface{}
+	for {
+		out := &structpb.Struct{}
+		err := stream.RecvMsg(out)
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, out.AsMap())
+	}
+}
+
+func TestExtensionsWatchExpression(t *testing.T) {
+	stub := &stubWatch{}
+	conn := dialExtensions(t, stub)
+
+	updates, err := watchExtension(conn, "WatchExpression", map[string]interface{}{"expressionId": "expression-id"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", stub.userId)
+	require.Len(t, updates, 2)
+	assert.Equal(t, float64(1), updates[0]["subExpressionsDone"])
+	assert.Equal(t, string(models.ExpressionOk), updates[1]["state"])
+	assert.Equal(t, float64(6), updates[1]["result"])
+
+	_, err = watchExtension(conn, "WatchExpression", map[string]interface{}{"expressionId": "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = watchExtension(conn, "WatchExpression", map[string]interface{}{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// stubCancel отменяет только выражение "expression-id", выражение "finished-id" уже завершено
+type stubCancel struct {
+	orchestrator.IOrchestrator
+}
+
+func (stubCancel) CancelExpression(_ context.Context, id, _ string) error {
+	switch id {
+	case "expression-id":
+		return nil
+	case "finished-id":
+		return repositories.ErrExpressionFinished
+	}
+	return repositories.ErrExpressionNotFound
+}
+
+func TestExtensionsCancelExpression(t *testing.T) {
+	conn := dialExtensions(t, stubCancel{})
+
+	_, err := invokeExtension(conn, "CancelExpression", map[string]interface{}{"expressionId": "expression-id"})
+	assert.NoError(t, err)
+
+	_, err = invokeExtension(conn, "CancelExpression", map[string]interface{}{"expressionId": "finished-id"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = invokeExtension(conn, "CancelExpression", map[string]interface{}{"expressionId": "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = invokeExtension(conn, "CancelExpression", map[string]interface{}{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// stubAttempts возвращает одну завершенную попытку выражения "expression-id"
+type stubAttempts struct {
+	orchestrator.IOrchestrator
+}
+
+func (stubAttempts) GetExpressionAttempts(_ context.Context, id, _ string) ([]*models.SubExpressionAttempt, error) {
+	if id != "expression-id" {
+		return nil, repositories.ErrExpressionNotFound
+	}
+	return []*models.SubExpressionAttempt{{Attempt: 1, Outcome: models.AttemptExpired}}, nil
+}
+
+func TestExtensionsExpressionAttempts(t *testing.T) {
+	conn := dialExtensions(t, stubAttempts{})
+
+	response, err := invokeExtension(conn, "GetExpressionAttempts", map[string]interface{}{"expressionId": "expression-id"})
+	require.NoError(t, err)
+	attempts := response["attempts"].([]interface{})
+	require.Len(t, attempts, 1)
+	assert.Equal(t, string(models.AttemptExpired), attempts[0].(map[string]interface{})["outcome"])
+	assert.Nil(t, attempts[0].(map[string]interface{})["agentId"])
+
+	_, err = invokeExtension(conn, "GetExpressionAttempts", map[string]interface{}{"expressionId": "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,104 @@
+package orchestratorgrpc
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"myproject/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// bindingsHeader значения переменных выражения вида "<имя>=<число>", несколько переменных передаются
+	// несколькими значениями заголовка или через запятую. CreateExpressionRequest в s0vunia/protos не содержит
+	// полей для параметров выражения, поэтому они передаются в заголовках запроса
+	bindingsHeader = "x-expression-bindings"
+	// modeHeader режим вычисления выражения: float (по умолчанию), exact или complex
+	modeHeader = "x-expression-mode"
+	// deadlineHeader срок выражения: время в RFC 3339 (2024-05-01T12:00:00Z) или длительность от создания (30s, 5m)
+	deadlineHeader = "x-expression-deadline"
+)
+
+// requestPriority возвращает приоритет выражения из заголовка запроса expressionPriorityHeader,
+// по умолчанию models.PriorityDefault
+func requestPriority(ctx context.Context) (int, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(expressionPriorityHeader)
+	if len(values) == 0 {
+		return models.PriorityDefault, nil
+	}
+	priority, err := strconv.Atoi(strings.TrimSpace(values[0]))
+	if err != nil || priority < 0 || priority > models.PriorityMax {
+		return 0, fmt.Errorf("priority must be from 0 to %d", models.PriorityMax)
+	}
+	return priority, nil
+}
+
+// requestDeadline возвращает срок выражения из заголовка запроса, nil - без срока
+func requestDeadline(ctx context.Context) (*time.Time, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(deadlineHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	value := strings.TrimSpace(values[0])
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		timeout, durationErr := time.ParseDuration(value)
+		if durationErr != nil {
+			return nil, fmt.Errorf("deadline %q must be RFC 3339 time or duration", value)
+		}
+		deadline = time.Now().Add(timeout)
+	}
+	if !deadline.After(time.Now()) {
+		return nil, fmt.Errorf("deadline %q has already passed", value)
+	}
+	return &deadline, nil
+}
+
+// requestMode возвращает режим вычисления из заголовка запроса, по умолчанию ModeFloat
+func requestMode(ctx context.Context) (models.ExpressionMode, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(modeHeader)
+	if len(values) == 0 {
+		return models.ModeFloat, nil
+	}
+	return parseMode(values[0])
+}
+
+// parseMode возвращает режим вычисления по его названию, пустое название - ModeFloat
+func parseMode(value string) (models.ExpressionMode, error) {
+	switch mode := models.ExpressionMode(strings.TrimSpace(value)); mode {
+	case "":
+		return models.ModeFloat, nil
+	case models.ModeFloat, models.ModeExact, models.ModeComplex:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown mode %q", value)
+}
+
+// requestBindings возвращает значения переменных из заголовков запроса, nil - переменные не переданы
+func requestBindings(ctx context.Context) (map[string]float64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var bindings map[string]float64
+	for _, value := range md.Get(bindingsHeader) {
+		for _, binding := range strings.Split(value, ",") {
+			name, number, ok := strings.Cut(binding, "=")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("binding %q must be <name>=<number>", binding)
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of variable %q: %q", name, number)
+			}
+			if bindings == nil {
+				bindings = make(map[string]float64)
+			}
+			bindings[name] = parsed
+		}
+	}
+	return bindings, nil
+}
@@ -0,0 +1,157 @@
+package orchestratorgrpc
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"math"
+	"myproject/internal/models"
+	"strconv"
+	"strings"
+)
+
+const (
+	// apiVersionHeader заголовок, в котором клиент передает поддерживаемую версию API, а сервер - согласованную
+	apiVersionHeader = "x-api-version"
+	// apiVersionFullPrecision версия API, начиная с которой результат передается без потери точности.
+	// GetExpressionResponse.Result в s0vunia/protos имеет тип float, поэтому полный результат передается
+	// в заголовках ответа, а клиенты версии 1 (без заголовка) получают прежний ответ
+	apiVersionFullPrecision = 2
+
+	// resultDecimalHeader значения вида "<expression_id>=<результат десятичной строкой>"
+	resultDecimalHeader = "x-result-decimal"
+	// resultIsIntegerHeader значения вида "<expression_id>=true|false"
+	resultIsIntegerHeader = "x-result-is-integer"
+	// resultUnitHeader значения вида "<expression_id>=<единица измерения>", только для результатов с единицами
+	resultUnitHeader = "x-result-unit"
+	// resultComplexHeader значения вида "<expression_id>=3+4i", только для выражений в режиме ModeComplex
+	resultComplexHeader = "x-result-complex"
+	// resultPolarHeader значения вида "<expression_id>=<модуль>,<аргумент в радианах>", только для ModeComplex
+	resultPolarHeader = "x-result-polar"
+	// resultMatrixHeader значения вида "<expression_id>=[[1,2],[3,4]]" (вектор - "[1,2]"), только для результатов-матриц
+	resultMatrixHeader = "x-result-matrix"
+	// expressionPriorityHeader значение вида "<expression_id>=<приоритет>", в том числе для непосчитанного выражения.
+	// В запросе CreateExpression в этом заголовке передается приоритет создаваемого выражения (см. requestPriority)
+	expressionPriorityHeader = "x-expression-priority"
+)
+
+// apiVersion возвращает версию API, запрошенную клиентом, по умолчанию 1
+func apiVersion(ctx context.Context) int {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 1
+	}
+	values := md.Get(apiVersionHeader)
+	if len(values) == 0 {
+		return 1
+	}
+	version, err := strconv.Atoi(values[0])
+	if err != nil || version < 1 {
+		return 1
+	}
+	return version
+}
+
+// setAPIVersionHeader сообщает клиентам версии 2 и выше согласованную версию API
+func setAPIVersionHeader(ctx context.Context) error {
+	if apiVersion(ctx) < apiVersionFullPrecision {
+		return nil
+	}
+	return grpc.SetHeader(ctx, metadata.Pairs(apiVersionHeader, strconv.Itoa(apiVersionFullPrecision)))
+}
+
+// setResultHeaders отправляет клиентам версии 2 и выше результат выражения без потери точности и его приоритет.
+// Заголовки отправляются только для одного выражения: заголовки для всех выражений пользователя в GetExpressions
+// превысили бы ограничение HTTP/2 на размер заголовков, и ответ бы не дошел
+func setResultHeaders(ctx context.Context, expression *models.Expression) error {
+	if apiVersion(ctx) < apiVersionFullPrecision {
+		return nil
+	}
+	md := metadata.Pairs(apiVersionHeader, strconv.Itoa(apiVersionFullPrecision))
+	md.Append(expressionPriorityHeader, expression.Id+"="+strconv.Itoa(expression.Priority))
+	if expression.State != models.ExpressionOk {
+		return grpc.SetHeader(ctx, md)
+	}
+	if expression.ResultMatrix != nil {
+		md.Append(resultMatrixHeader, expression.Id+"="+resultMatrix(expression.ResultMatrix))
+		return grpc.SetHeader(ctx, md)
+	}
+	md.Append(resultDecimalHeader, expression.Id+"="+resultDecimal(expression))
+	md.Append(resultIsIntegerHeader, expression.Id+"="+strconv.FormatBool(resultIsInteger(expression)))
+	if expression.ResultUnit != "" {
+		md.Append(resultUnitHeader, expression.Id+"="+expression.ResultUnit)
+	}
+	if expression.Mode == models.ModeComplex {
+		md.Append(resultComplexHeader, expression.Id+"="+resultComplex(expression))
+		md.Append(resultPolarHeader, expression.Id+"="+resultPolar(expression))
+	}
+	return grpc.SetHeader(ctx, md)
+}
+
+// resultDecimal возвращает результат выражения в каноническом десятичном виде: точный результат для режима
+// ModeExact, иначе кратчайшую запись float64, из которой восстанавливается то же значение
+func resultDecimal(expression *models.Expression) string {
+	if expression.ResultExact == "" {
+		return strconv.FormatFloat(expression.Result, 'f', -1, 64)
+	}
+	// NUMERIC сохраняет масштаб исходного значения ("1.50"), незначащие нули убираются
+	decimal := expression.ResultExact
+	if strings.Contains(decimal, ".") {
+		decimal = strings.TrimRight(strings.TrimRight(decimal, "0"), ".")
+	}
+	if decimal == "-0" {
+		return "0"
+	}
+	return decimal
+}
+
+// resultIsInteger проверяет, что результат выражения - целое действительное число. NaN и бесконечности
+// не целые, хотя их десятичная запись ("NaN", "+Inf") не содержит точки
+func resultIsInteger(expression *models.Expression) bool {
+	if expression.ResultIm != 0 {
+		return false
+	}
+	if expression.ResultExact != "" {
+		return !strings.Contains(resultDecimal(expression), ".")
+	}
+	result := expression.Result
+	return !math.IsNaN(result) && !math.IsInf(result, 0) && math.Trunc(result) == result
+}
+
+// resultComplex возвращает результат в алгебраической форме: "3+4i", "-2.5i", "1" для мнимой части, равной нулю
+func resultComplex(expression *models.Expression) string {
+	re := strconv.FormatFloat(expression.Result, 'f', -1, 64)
+	im := strconv.FormatFloat(expression.ResultIm, 'f', -1, 64)
+	switch {
+	case expression.ResultIm == 0:
+		return re
+	case expression.Result == 0:
+		return im + "i"
+	case expression.ResultIm > 0:
+		return re + "+" + im + "i"
+	}
+	return re + im + "i"
+}
+
+// resultPolar возвращает результат в тригонометрической форме: модуль и аргумент в радианах из (-π, π]
+func resultPolar(expression *models.Expression) string {
+	r := math.Hypot(expression.Result, expression.ResultIm)
+	phi := math.Atan2(expression.ResultIm, expression.Result)
+	return strconv.FormatFloat(r, 'f', -1, 64) + "," + strconv.FormatFloat(phi, 'f', -1, 64)
+}
+
+// resultMatrix записывает матрицу построчно: "[[1,2],[3,4]]", матрицу из одной строки - как вектор "[1,2]"
+func resultMatrix(matrix *models.Matrix) string {
+	rows := make([]string, matrix.Rows)
+	for i := range rows {
+		values := make([]string, matrix.Cols)
+		for j := range values {
+			values[j] = strconv.FormatFloat(matrix.At(i, j), 'f', -1, 64)
+		}
+		rows[i] = "[" + strings.Join(values, ",") + "]"
+	}
+	if len(rows) == 1 {
+		return rows[0]
+	}
+	return "[" + strings.Join(rows, ",") + "]"
+}
@@ -0,0 +1,32 @@
+package orchestratorgrpc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestResultIsInteger(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression *models.Expression
+		want       bool
+	}{
+		{name: "целое", expression: &models.Expression{Result: 4}, want: true},
+		{name: "дробное", expression: &models.Expression{Result: 2.5}},
+		{name: "большое целое", expression: &models.Expression{Result: 1e21}, want: true},
+		{name: "NaN", expression: &models.Expression{Result: math.NaN()}},
+		{name: "+Inf", expression: &models.Expression{Result: math.Inf(1)}},
+		{name: "-Inf", expression: &models.Expression{Result: math.Inf(-1)}},
+		{name: "комплексное", expression: &models.Expression{Result: 3, ResultIm: 4}},
+		{name: "точное целое с нулями", expression: &models.Expression{Result: 2, ResultExact: "2.000"}, want: true},
+		{name: "точное дробное", expression: &models.Expression{Result: 0.3, ResultExact: "0.30"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resultIsInteger(tt.expression))
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	orchv1 "github.com/s0vunia/protos/gen/go/orchestrator"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -24,6 +25,7 @@ type serverAPI struct {
 
 func Register(gRPCServer *grpc.Server, orchestrator orchestrator.IOrchestrator, timeoutsConfig config.CalculationTimeoutsConfig) {
 	orchv1.RegisterOrchestratorServer(gRPCServer, &serverAPI{orchestrator: orchestrator, calculationTimeouts: timeoutsConfig})
+	gRPCServer.RegisterService(&extensionsServiceDesc, &extensionsAPI{orchestrator: orchestrator})
 }
 
 func (s *serverAPI) CreateExpression(
@@ -39,8 +41,26 @@ func (s *serverAPI) CreateExpression(
 		return nil, status.Error(codes.InvalidArgument, "idempotencyKey is required")
 	}
 
-	if !orchestratorutils.ValidateExpression(in.Expression) {
-		return nil, status.Error(codes.InvalidArgument, "invalid expression")
+	// CreateExpressionRequest в s0vunia/protos содержит только выражение и ключ идемпотентности,
+	// остальные параметры выражения передаются в заголовках запроса
+	bindings, err := requestBindings(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mode, err := requestMode(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	deadline, err := requestDeadline(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	priority, err := requestPriority(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := orchestratorutils.ValidateExpression(in.Expression, bindings); err != nil {
+		return nil, invalidExpressionError(err)
 	}
 	userID := ctx.Value("userID").(float64)
 	userIdStr := strconv.Itoa(int(userID))
@@ -53,7 +73,15 @@ func (s *serverAPI) CreateExpression(
 		}
 		expressionId = expressionByKey.Id
 	} else {
-		err, expressionId = s.orchestrator.CreateExpression(ctx, in.Expression, in.IdempotencyKey, userIdStr)
+		err, expressionId = s.orchestrator.CreateExpression(ctx, in.Expression, in.IdempotencyKey, userIdStr, bindings, mode, deadline, priority)
+		// неизвестная функция или неверный вызов формулы пользователя обнаруживаются только при раскрытии формул
+		var parseErr *orchestratorutils.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, invalidExpressionError(err)
+		}
+		if errors.Is(err, orchestrator.ErrQuotaExceeded) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
 		if err != nil {
 			log.Error(err)
 			return nil, status.Error(codes.Internal, "failed to create expression")
@@ -62,6 +90,38 @@ func (s *serverAPI) CreateExpression(
 	return &orchv1.CreateExpressionResponse{ExpressionId: expressionId}, nil
 }
 
+// invalidExpressionError возвращает InvalidArgument с деталями ошибки разбора:
+// BadRequest с описанием и ErrorInfo с лексемой и позицией, чтобы клиент мог подсветить ошибку
+func invalidExpressionError(err error) error {
+	st := status.New(codes.InvalidArgument, "invalid expression: "+err.Error())
+	var parseErr *orchestratorutils.ParseError
+	if !errors.As(err, &parseErr) {
+		return st.Err()
+	}
+	detailedSt, detailsErr := st.WithDetails(
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "expression", Description: parseErr.Error()},
+			},
+		},
+		&errdetails.ErrorInfo{
+			Reason: "INVALID_EXPRESSION",
+			Domain: "orchestrator",
+			Metadata: map[string]string{
+				"token":   parseErr.Token,
+				"column":  strconv.Itoa(parseErr.Column),
+				"message": parseErr.Message,
+				"formula": parseErr.Formula,
+			},
+		},
+	)
+	if detailsErr != nil {
+		log.Error(detailsErr)
+		return st.Err()
+	}
+	return detailedSt.Err()
+}
+
 func (s *serverAPI) GetExpression(
 	ctx context.Context,
 	in *orchv1.GetExpressionRequest,
@@ -82,9 +142,14 @@ func (s *serverAPI) GetExpression(
 		return nil, status.Error(codes.Internal, "failed to get expression")
 	}
 
+	if err := setResultHeaders(ctx, expression); err != nil {
+		log.Error(err)
+	}
 	return s.ExpressionModelToGetExpressionResponse(expression), nil
 }
 
+// ExpressionModelToGetExpressionResponse конвертирует expression в ответ API версии 1.
+// Result в ответе имеет тип float, результат без потери точности передается в заголовках (см. setResultHeaders)
 func (s *serverAPI) ExpressionModelToGetExpressionResponse(expression *models.Expression) *orchv1.GetExpressionResponse {
 	return &orchv1.GetExpressionResponse{
 		Result:         float32(expression.Result),
@@ -107,6 +172,10 @@ func (s *serverAPI) GetExpressions(
 		log.Error(err)
 		return nil, status.Error(codes.Internal, "failed to get expressions")
 	}
+	// результаты и приоритеты выражений списка клиент получает через GetExpression
+	if err := setAPIVersionHeader(ctx); err != nil {
+		log.Error(err)
+	}
 	var listOfExpression []*orchv1.GetExpressionResponse
 	for _, expression := range expressions {
 		listOfExpression = append(listOfExpression, s.ExpressionModelToGetExpressionResponse(expression))
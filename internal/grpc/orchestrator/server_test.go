@@ -0,0 +1,138 @@
+package orchestratorgrpc
+
+import (
+	"context"
+	orchv1 "github.com/s0vunia/protos/gen/go/orchestrator"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"myproject/internal/models"
+	"myproject/internal/services/orchestrator"
+	"testing"
+	"time"
+)
+
+// stubOrchestrator запоминает параметры созданного выражения, остальные методы не вызываются
+type stubOrchestrator struct {
+	orchestrator.IOrchestrator
+	bindings map[string]float64
+	mode     models.ExpressionMode
+	deadline *time.Time
+	priority int
+}
+
+func (o *stubOrchestrator) GetExpressionByKey(context.Context, string, string) (*models.Expression, error) {
+	return nil, nil
+}
+
+func (o *stubOrchestrator) CreateExpression(_ context.Context, _, _, _ string, bindings map[string]float64, mode models.ExpressionMode, deadline *time.Time, priority int) (error, string) {
+	o.bindings, o.mode, o.deadline, o.priority = bindings, mode, deadline, priority
+	return nil, "expression-id"
+}
+
+// requestContext контекст запроса авторизованного пользователя с заголовками kv
+func requestContext(kv ...string) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+	return context.WithValue(ctx, "userID", float64(1))
+}
+
+func TestCreateExpressionBindings(t *testing.T) {
+	stub := &stubOrchestrator{}
+	s := &serverAPI{orchestrator: stub}
+	request := &orchv1.CreateExpressionRequest{Expression: "price * (1 + tax)", IdempotencyKey: "key"}
+
+	_, err := s.CreateExpression(requestContext(bindingsHeader, "price=100", bindingsHeader, "tax=0.2"), request)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"price": 100, "tax": 0.2}, stub.bindings)
+
+	_, err = s.CreateExpression(requestContext(bindingsHeader, "price=100, tax=0.2"), request)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"price": 100, "tax": 0.2}, stub.bindings)
+
+	_, err = s.CreateExpression(requestContext(bindingsHeader, "price=100"), request)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CreateExpression(requestContext(bindingsHeader, "price=abc, tax=0.2"), request)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateExpressionMode(t *testing.T) {
+	stub := &stubOrchestrator{}
+	s := &serverAPI{orchestrator: stub}
+	request := &orchv1.CreateExpressionRequest{Expression: "0.1 + 0.2", IdempotencyKey: "key"}
+
+	_, err := s.CreateExpression(requestContext(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ModeFloat, stub.mode)
+
+	_, err = s.CreateExpression(requestContext(modeHeader, "exact"), request)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ModeExact, stub.mode)
+
+	_, err = s.CreateExpression(requestContext(modeHeader, "complex"), request)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ModeComplex, stub.mode)
+
+	_, err = s.CreateExpression(requestContext(modeHeader, "decimal"), request)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateExpressionDeadline(t *testing.T) {
+	stub := &stubOrchestrator{}
+	s := &serverAPI{orchestrator: stub}
+	request := &orchv1.CreateExpressionRequest{Expression: "2 + 2", IdempotencyKey: "key"}
+
+	_, err := s.CreateExpression(requestContext(), request)
+	assert.NoError(t, err)
+	assert.Nil(t, stub.deadline)
+
+	deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, err = s.CreateExpression(requestContext(deadlineHeader, deadline.Format(time.RFC3339)), request)
+	assert.NoError(t, err)
+	assert.True(t, deadline.Equal(*stub.deadline))
+
+	_, err = s.CreateExpression(requestContext(deadlineHeader, "30s"), request)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), *stub.deadline, time.Second)
+
+	_, err = s.CreateExpression(requestContext(deadlineHeader, "-1m"), request)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CreateExpression(requestContext(deadlineHeader, "tomorrow"), request)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateExpressionPriority(t *testing.T) {
+	stub := &stubOrchestrator{}
+	s := &serverAPI{orchestrator: stub}
+	request := &orchv1.CreateExpressionRequest{Expression: "2 + 2", IdempotencyKey: "key"}
+
+	_, err := s.CreateExpression(requestContext(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriorityDefault, stub.priority)
+
+	_, err = s.CreateExpression(requestContext(expressionPriorityHeader, "9"), request)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, stub.priority)
+
+	for _, priority := range []string{"10", "-1", "high"} {
+		_, err = s.CreateExpression(requestContext(expressionPriorityHeader, priority), request)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), priority)
+	}
+}
+
+// quotaExceededOrchestrator отклоняет создание выражений: пользователь исчерпал суточную квоту
+type quotaExceededOrchestrator struct {
+	stubOrchestrator
+}
+
+func (o *quotaExceededOrchestrator) CreateExpression(context.Context, string, string, string, map[string]float64, models.ExpressionMode, *time.Time, int) (error, string) {
+	return orchestrator.ErrQuotaExceeded, ""
+}
+
+func TestCreateExpressionQuotaExceeded(t *testing.T) {
+	s := &serverAPI{orchestrator: &quotaExceededOrchestrator{}}
+	_, err := s.CreateExpression(requestContext(), &orchv1.CreateExpressionRequest{Expression: "1 + 2", IdempotencyKey: "key"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
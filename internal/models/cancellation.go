@@ -0,0 +1,9 @@
+package models
+
+import "github.com/google/uuid"
+
+// Cancellation сообщение агентам об отмене выражения: агент прерывает подсчет его subexpression
+// и не берет в работу остальные его subexpressions
+type Cancellation struct {
+	ExpressionId uuid.UUID `json:"expressionId"`
+}
@@ -1,13 +1,51 @@
 package models
 
+import "time"
+
 type ExpressionState string
 
 const (
 	ExpressionError      ExpressionState = "error"
 	ExpressionInProgress                 = "in_progress"
 	ExpressionOk                         = "ok"
+	// ExpressionCancelled выражение отменено пользователем, его subexpressions больше не считаются
+	ExpressionCancelled = "cancelled"
+	// ExpressionTimedOut срок выражения истек до окончания подсчета, его subexpressions больше не считаются
+	ExpressionTimedOut = "timed_out"
+)
+
+const (
+	// PriorityDefault приоритет выражения по умолчанию. Приоритеты от 0 до PriorityMax:
+	// subexpressions выражений с большим приоритетом отправляются агентам раньше
+	PriorityDefault = 5
+	PriorityMax     = 9
 )
 
+// ExpressionMode режим вычисления выражения
+type ExpressionMode string
+
+const (
+	// ModeFloat вычисление в float64 (по умолчанию)
+	ModeFloat ExpressionMode = "float"
+	// ModeExact точное десятичное вычисление: значения передаются строками и хранятся в NUMERIC
+	ModeExact ExpressionMode = "exact"
+	// ModeComplex вычисление в комплексных числах: мнимые части передаются в полях *Im
+	ModeComplex ExpressionMode = "complex"
+)
+
+// ExpressionStatus состояние выражения, которое оркестратор проверяет перед отправкой его subexpressions агентам
+type ExpressionStatus struct {
+	UserId   string
+	State    ExpressionState
+	Deadline *time.Time
+}
+
+// ExpressionChange уведомление бд об изменении состояния, прогресса или результата выражения
+type ExpressionChange struct {
+	Id     string `json:"id"`
+	UserId string `json:"user_id"`
+}
+
 type Expression struct {
 	Result         float64         `json:"result"`
 	Id             string          `json:"id"`
@@ -15,4 +53,29 @@ type Expression struct {
 	IdempotencyKey string          `json:"idempotencyKey"`
 	Value          string          `json:"value"`
 	State          ExpressionState `json:"state"`
+
+	// ErrorReason причина ошибки, если State == ExpressionError
+	ErrorReason string `json:"errorReason"`
+	// Bindings значения переменных, с которыми считалось выражение
+	Bindings map[string]float64 `json:"bindings"`
+	// FormulaVersions версии формул пользователя, раскрытых в выражении при создании
+	FormulaVersions map[string]int `json:"formulaVersions"`
+	// Mode режим вычисления, в режиме ModeExact точный результат хранится в ResultExact, а Result - его приближение
+	Mode        ExpressionMode `json:"mode"`
+	ResultExact string         `json:"resultExact"`
+	// ResultUnit единица измерения результата в СИ ("m", "m/s"), пустая строка - безразмерный результат
+	ResultUnit string `json:"resultUnit"`
+	// ResultIm мнимая часть результата в режиме ModeComplex, действительная часть - Result
+	ResultIm float64 `json:"resultIm"`
+	// ResultMatrix результат-матрица (например [1, 2] * 2), в этом случае Result не используется
+	ResultMatrix *Matrix `json:"resultMatrix"`
+	// SubExpressionsDone и SubExpressionsTotal прогресс подсчета: сколько subexpressions посчитано из созданных
+	// (невыбранные ветки if не учитываются)
+	SubExpressionsDone  int `json:"subExpressionsDone"`
+	SubExpressionsTotal int `json:"subExpressionsTotal"`
+	// Deadline срок, до которого выражение должно посчитаться, иначе оно переходит в статус ExpressionTimedOut.
+	// nil - без срока
+	Deadline *time.Time `json:"deadline"`
+	// Priority приоритет выражения от 0 до PriorityMax
+	Priority int `json:"priority"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// ExpressionBatchItem выражение, создаваемое в пакете выражений
+type ExpressionBatchItem struct {
+	Expression     string             `json:"expression"`
+	IdempotencyKey string             `json:"idempotencyKey"`
+	Bindings       map[string]float64 `json:"bindings"`
+	Mode           ExpressionMode     `json:"mode"`
+	// Deadline срок выражения, nil - без срока
+	Deadline *time.Time `json:"deadline"`
+	// Priority приоритет выражения, nil - PriorityDefault
+	Priority *int `json:"priority"`
+}
+
+// ExpressionBatchResult результат создания выражения из пакета: id созданного выражения
+// (или уже существующего с тем же ключом идемпотентности) либо ошибка проверки выражения
+type ExpressionBatchResult struct {
+	ExpressionId string `json:"expressionId"`
+	Error        error  `json:"-"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// Formula сохраненная формула пользователя вида vat(x) = x * 1.2.
+// Каждое изменение формулы сохраняется новой версией, прежние версии не изменяются
+type Formula struct {
+	Id      int64    `json:"id"`
+	UserId  string   `json:"userId"`
+	Name    string   `json:"name"`
+	Params  []string `json:"params"`
+	Body    string   `json:"body"`
+	Version int      `json:"version"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Lease аренда subexpression агентом: агент продлевает ее, пока считает subexpression, а оркестратор
+// повторно отправляет subexpression, аренда которого истекла. Attempt - номер отправки subexpression агентам
+type Lease struct {
+	SubExpressionId uuid.UUID `json:"subExpressionId"`
+	AgentId         uuid.UUID `json:"agentId"`
+	Attempt         int       `json:"attempt"`
+}
+
+// AttemptOutcome чем завершилась отправка subexpression агентам
+type AttemptOutcome string
+
+const (
+	// AttemptCompleted агент вернул результат
+	AttemptCompleted AttemptOutcome = "completed"
+	// AttemptFailed агент вернул ошибку подсчета
+	AttemptFailed AttemptOutcome = "failed"
+	// AttemptExpired аренда истекла раньше, чем пришел результат
+	AttemptExpired AttemptOutcome = "expired"
+)
+
+// SubExpressionAttempt отправка subexpression агентам. AgentId не задан, пока ни один агент не взял
+// subexpression, FinishedAt и Outcome - пока попытка не завершилась
+type SubExpressionAttempt struct {
+	SubExpressionId uuid.UUID      `json:"subExpressionId"`
+	ExpressionId    uuid.UUID      `json:"expressionId"`
+	Attempt         int            `json:"attempt"`
+	AgentId         uuid.NullUUID  `json:"agentId"`
+	StartedAt       time.Time      `json:"startedAt"`
+	FinishedAt      *time.Time     `json:"finishedAt"`
+	Outcome         AttemptOutcome `json:"outcome"`
+}
@@ -0,0 +1,23 @@
+package models
+
+// Matrix матрица, элементы которой хранятся построчно в Data.
+// Вектор [1, 2, 3] - матрица из одной строки
+type Matrix struct {
+	Rows int       `json:"rows"`
+	Cols int       `json:"cols"`
+	Data []float64 `json:"data"`
+}
+
+// At возвращает элемент в строке i и столбце j (нумерация с 0)
+func (m *Matrix) At(i, j int) float64 {
+	return m.Data[i*m.Cols+j]
+}
+
+// Slice возвращает копию строк [rowFrom, rowTo) и столбцов [colFrom, colTo) матрицы
+func (m *Matrix) Slice(rowFrom, rowTo, colFrom, colTo int) *Matrix {
+	slice := &Matrix{Rows: rowTo - rowFrom, Cols: colTo - colFrom, Data: make([]float64, 0, (rowTo-rowFrom)*(colTo-colFrom))}
+	for i := rowFrom; i < rowTo; i++ {
+		slice.Data = append(slice.Data, m.Data[i*m.Cols+colFrom:i*m.Cols+colTo]...)
+	}
+	return slice
+}
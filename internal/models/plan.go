@@ -0,0 +1,18 @@
+package models
+
+// ExpressionPlan план вычисления выражения до и после оптимизации дерева
+type ExpressionPlan struct {
+	Before PlanStats `json:"before"`
+	After  PlanStats `json:"after"`
+}
+
+// PlanStats оценка стоимости вычисления дерева выражения
+type PlanStats struct {
+	// Postfix дерево в постфиксной записи
+	Postfix string `json:"postfix"`
+	// Operations количество подвыражений, которые будут созданы для агентов
+	Operations int `json:"operations"`
+	// Depth количество последовательных обращений к агентам: длина самой длинной цепочки
+	// подвыражений, каждое из которых ждет результат предыдущего
+	Depth int `json:"depth"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// Quota ограничения пользователя. Нулевые DailyExpressions и MaxInFlight - без ограничения
+type Quota struct {
+	UserId string `json:"userId"`
+	// DailyExpressions сколько выражений пользователь может создать за сутки
+	DailyExpressions int `json:"dailyExpressions"`
+	// MaxInFlight сколько subexpressions пользователя могут одновременно считаться агентами
+	MaxInFlight int `json:"maxInFlight"`
+	// Weight вес пользователя при распределении агентов: за один круг отправляется до Weight его subexpressions
+	Weight int `json:"weight"`
+}
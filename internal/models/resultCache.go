@@ -0,0 +1,19 @@
+package models
+
+// CalculationResult результат подсчета subexpression, который хранится в кэше результатов
+type CalculationResult struct {
+	Result       float64 `json:"result"`
+	ResultExact  string  `json:"resultExact"`
+	ResultIm     float64 `json:"resultIm"`
+	ResultMatrix *Matrix `json:"resultMatrix"`
+}
+
+// ResultCacheStats статистика кэша результатов с момента запуска оркестратора
+type ResultCacheStats struct {
+	// Hits количество subexpressions, результат которых взят из кэша без обращения к агентам
+	Hits int64 `json:"hits"`
+	// Misses количество subexpressions, которых не было в кэше
+	Misses int64 `json:"misses"`
+	// Size количество записей в кэше
+	Size int `json:"size"`
+}
@@ -1,7 +1,13 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
+// SubExpression подвыражение, которое считает агент.
+// У унарных операций ("neg", "pos") используется только первый операнд (Val1 или SubExpressionId1),
+// у функций ("sqrt", "max", "if", ...) - только Args и ArgIds
 type SubExpression struct {
 	Id               uuid.UUID     `json:"id" pg:"type:uuid"`
 	ExpressionId     uuid.UUID     `json:"expressionId" pg:"type:uuid"`
@@ -14,4 +20,40 @@ type SubExpression struct {
 	IsLast           bool          `json:"isLast"`
 	Error            bool          `json:"error"`
 	AgentId          uuid.NullUUID `json:"agentId"`
+	// Args аргументы функции, ArgIds - id subexpressions, результаты которых подставятся в Args
+	Args   []float64       `json:"args"`
+	ArgIds []uuid.NullUUID `json:"argIds"`
+	// ErrorReason причина ошибки подсчета (деление на ноль, корень из отрицательного числа, ...)
+	ErrorReason string `json:"errorReason"`
+	// Mode режим вычисления. В режиме ModeExact значения передаются десятичными строками
+	// Val1Exact, Val2Exact, ArgsExact и ResultExact, а float поля содержат их приближения
+	Mode        ExpressionMode `json:"mode"`
+	Val1Exact   string         `json:"val1Exact"`
+	Val2Exact   string         `json:"val2Exact"`
+	ArgsExact   []string       `json:"argsExact"`
+	ResultExact string         `json:"resultExact"`
+	// GuardId id subexpression условия if, от которого зависит, нужно ли считать эту ветку:
+	// subexpression отправляется агенту только после того, как условие посчитано и равно GuardBranch
+	GuardId     uuid.NullUUID `json:"guardId"`
+	GuardBranch bool          `json:"guardBranch"`
+	// Val1Im, Val2Im, ArgsIm и ResultIm мнимые части значений в режиме ModeComplex,
+	// действительные части передаются в Val1, Val2, Args и Result
+	Val1Im   float64   `json:"val1Im"`
+	Val2Im   float64   `json:"val2Im"`
+	ArgsIm   []float64 `json:"argsIm"`
+	ResultIm float64   `json:"resultIm"`
+	// Val1Matrix, Val2Matrix, ArgsMatrix и ResultMatrix значения-матрицы. Если операнд - матрица,
+	// соответствующее float поле не используется, у аргументов-чисел элемент ArgsMatrix равен nil
+	Val1Matrix   *Matrix   `json:"val1Matrix"`
+	Val2Matrix   *Matrix   `json:"val2Matrix"`
+	ArgsMatrix   []*Matrix `json:"argsMatrix"`
+	ResultMatrix *Matrix   `json:"resultMatrix"`
+	// Deadline срок выражения, оркестратор заполняет его при отправке агенту: после срока агент не считает subexpression
+	Deadline *time.Time `json:"deadline"`
+	// Priority приоритет выражения, CreatedAt - время создания subexpression, от которого отсчитывается
+	// повышение приоритета долго ожидающих subexpressions
+	Priority  int       `json:"priority"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Attempt номер отправки subexpression агентам, агент продлевает аренду этой отправки (см. Lease)
+	Attempt int `json:"attempt"`
 }
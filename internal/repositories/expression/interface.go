@@ -7,8 +7,17 @@ import (
 )
 
 type Repository interface {
-	// CreateExpression создает expression
-	CreateExpression(ctx context.Context, value, idempotentKey, userId string) (*models.Expression, error)
+	// CreateExpression создает expression из значения, ключа идемпотентности, пользователя и значений переменных.
+	// dailyLimit - суточная квота пользователя (0 - без ограничения): если пользователь уже создал за сутки
+	// dailyLimit выражений, выражение не создается и возвращается repositories.ErrQuotaExceeded
+	CreateExpression(ctx context.Context, expression *models.Expression, dailyLimit int) (*models.Expression, error)
+	// CreateExpressions создает выражения одного пользователя вместе с их subexpressions в одной транзакции.
+	// Id выражений и subexpressions назначаются заранее, выражения в статусе ExpressionOk сохраняются с результатом.
+	// Сохраняются первые выражения в пределах суточной квоты dailyLimit (0 - без ограничения) вместе с их
+	// subexpressions, возвращается их количество
+	CreateExpressions(ctx context.Context, expressions []*models.Expression, subExpressions []*models.SubExpression, dailyLimit int) (int, error)
+	// GetExpressionIdsByKeys возвращает id выражений пользователя по ключам идемпотентности, которые уже есть в бд
+	GetExpressionIdsByKeys(ctx context.Context, keys []string, userId string) (map[string]string, error)
 	// GetExpressions возвращает список expression
 	GetExpressions(ctx context.Context, userId string) ([]*models.Expression, error)
 	// GetExpressionById возвращает expression по id
@@ -17,10 +26,26 @@ type Repository interface {
 	GetExpressionByKey(ctx context.Context, key, userId string) (*models.Expression, error)
 	// UpdateExpression обновляет expression
 	UpdateExpression(context.Context, *models.Expression) error
-	// UpdateExpressionById обновляет результат expression по ID
-	UpdateExpressionById(ctx context.Context, id uuid.UUID, result float64) error
+	// UpdateExpressionById обновляет результат expression по ID, resultExact - точный результат в режиме ModeExact,
+	// resultIm - мнимая часть результата в режиме ModeComplex, resultMatrix - результат-матрица.
+	// Результат и ошибка (UpdateExpressionError) сохраняются только у выражений в статусе ExpressionInProgress, срок которых не истек
+	UpdateExpressionById(ctx context.Context, id uuid.UUID, result float64, resultExact string, resultIm float64, resultMatrix *models.Matrix) error
+	// UpdateExpressionError переводит expression по ID в статус ошибки с указанием причины
+	UpdateExpressionError(ctx context.Context, id uuid.UUID, reason string) error
+	// CancelExpression переводит выражение пользователя в статус ExpressionCancelled. Возвращает
+	// repositories.ErrExpressionNotFound, если выражения нет, и repositories.ErrExpressionFinished, если оно уже завершено
+	CancelExpression(ctx context.Context, id, userId string) error
+	// GetExpressionStatus возвращает пользователя, статус и срок expression по ID
+	GetExpressionStatus(ctx context.Context, id uuid.UUID) (*models.ExpressionStatus, error)
+	// ExpireExpressions переводит выражения в статусе ExpressionInProgress с истекшим сроком в статус ExpressionTimedOut
+	// и возвращает их id
+	ExpireExpressions(ctx context.Context) ([]uuid.UUID, error)
+	// AddSubExpressionsTotal увеличивает количество созданных subexpressions выражения на count
+	AddSubExpressionsTotal(ctx context.Context, id uuid.UUID, count int) error
 	// DeleteExpressionById удаляет expression по ID
 	DeleteExpressionById(ctx context.Context, id uuid.UUID) error
+	// GetExpressionChanges возвращает канал уведомлений об изменении состояния, прогресса или результата выражений
+	GetExpressionChanges() chan *models.ExpressionChange
 	// UpdateState обновляет статус expression по ID
 	UpdateState(ctx context.Context, id string, state models.ExpressionState) error
 }
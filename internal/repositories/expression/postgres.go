@@ -3,16 +3,22 @@ package expression
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/lib/pq"
+	"log"
 	"myproject/internal/models"
 	"myproject/internal/repositories"
+	"myproject/internal/repositories/subExpression"
+	"time"
 )
 
 type PostgresRepository struct {
-	db *sql.DB
+	db       *sql.DB
+	listener chan *models.ExpressionChange
 }
 
 func NewPostgresRepository(dataSourceName string) (*PostgresRepository, error) {
@@ -27,31 +33,204 @@ func NewPostgresRepository(dataSourceName string) (*PostgresRepository, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &PostgresRepository{db}, nil
+	listener := pq.NewListener(dataSourceName, 1*time.Second, 5*time.Second, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println(err)
+		}
+	})
+
+	// Подписываемся на канал уведомлений об изменениях выражений
+	err = listener.Listen("expressions_channel")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init listener: %w", err)
+	}
+
+	repo := &PostgresRepository{db, make(chan *models.ExpressionChange)}
+	go repo.handleNotification(listener.Notify)
+	return repo, nil
 }
 
-func (r *PostgresRepository) CreateExpression(ctx context.Context, s, idempotencyId, userId string) (*models.Expression, error) {
+// handleNotification обрабатывает триггер бд по изменению состояния, прогресса или результата выражения.
+// Выражение не читается из бд: его читают только те, у кого есть подписчики на это выражение
+func (r *PostgresRepository) handleNotification(notificationChan <-chan *pq.Notification) {
+	defer close(r.listener)
+	for notification := range notificationChan {
+		if notification == nil || notification.Extra == "" {
+			continue
+		}
+		changed := &models.ExpressionChange{}
+		if err := json.Unmarshal([]byte(notification.Extra), changed); err != nil {
+			log.Printf("Failed to parse JSON: %v", err)
+			continue
+		}
+		r.listener <- changed
+	}
+}
+
+func (r *PostgresRepository) GetExpressionChanges() chan *models.ExpressionChange {
+	return r.listener
+}
+
+func (r *PostgresRepository) CreateExpression(ctx context.Context, expression *models.Expression, dailyLimit int) (*models.Expression, error) {
 	var id string
-	expression := &models.Expression{
-		UserId:         userId,
-		IdempotencyKey: idempotencyId,
-		Value:          s,
-		State:          models.ExpressionState(models.ExpressionInProgress),
+	expression.State = models.ExpressionState(models.ExpressionInProgress)
+	if expression.Mode == "" {
+		expression.Mode = models.ModeFloat
+	}
+	bindings, err := json.Marshal(expression.Bindings)
+	if err != nil {
+		return nil, fmt.Errorf("marshal bindings failure %e", err)
+	}
+	formulaVersions, err := json.Marshal(expression.FormulaVersions)
+	if err != nil {
+		return nil, fmt.Errorf("marshal formula versions failure %e", err)
 	}
 
-	err := r.db.QueryRowContext(ctx, "INSERT INTO expressions (id, user_id, idempotency_key, value, state) VALUES (gen_random_uuid(), $1, $2, $3, $4) RETURNING id",
-		expression.UserId, expression.IdempotencyKey, expression.Value, expression.State).Scan(&id)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failure %e", err)
+	}
+	defer tx.Rollback()
+	remaining, err := remainingDailyExpressions(ctx, tx, expression.UserId, dailyLimit)
+	if err != nil {
+		return nil, err
+	}
+	if remaining == 0 {
+		return nil, repositories.ErrQuotaExceeded
+	}
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO expressions (id, user_id, idempotency_key, value, state, bindings, formula_versions, mode, result_unit, deadline, priority) VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
+		expression.UserId, expression.IdempotencyKey, expression.Value, expression.State, string(bindings), string(formulaVersions), expression.Mode, expression.ResultUnit, expression.Deadline,
+		expression.Priority).Scan(&id)
 
 	if err != nil {
 		return nil, fmt.Errorf("create expression failure %e", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction failure %e", err)
+	}
 
 	expression.Id = id
 	return expression, nil
 }
 
+func (r *PostgresRepository) CreateExpressions(ctx context.Context, expressions []*models.Expression, subExpressions []*models.SubExpression, dailyLimit int) (int, error) {
+	if len(expressions) == 0 {
+		return 0, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction failure %e", err)
+	}
+	defer tx.Rollback()
+	remaining, err := remainingDailyExpressions(ctx, tx, expressions[0].UserId, dailyLimit)
+	if err != nil {
+		return 0, err
+	}
+	if remaining >= 0 && remaining < len(expressions) {
+		expressions = expressions[:remaining]
+		subExpressions = expressionsSubExpressions(expressions, subExpressions)
+	}
+
+	rows := make([][]any, 0, len(expressions))
+	for _, expression := range expressions {
+		if expression.Mode == "" {
+			expression.Mode = models.ModeFloat
+		}
+		bindings, err := json.Marshal(expression.Bindings)
+		if err != nil {
+			return 0, fmt.Errorf("marshal bindings failure %e", err)
+		}
+		formulaVersions, err := json.Marshal(expression.FormulaVersions)
+		if err != nil {
+			return 0, fmt.Errorf("marshal formula versions failure %e", err)
+		}
+		matrix, err := json.Marshal(expression.ResultMatrix)
+		if err != nil {
+			return 0, fmt.Errorf("marshal result matrix failure %e", err)
+		}
+		// у выражений без subexpressions результат известен сразу
+		var result sql.NullFloat64
+		if expression.State == models.ExpressionOk {
+			result = sql.NullFloat64{Float64: expression.Result, Valid: true}
+		}
+		rows = append(rows, []any{
+			expression.Id, expression.UserId, expression.IdempotencyKey, expression.Value, expression.State, string(bindings), string(formulaVersions), expression.Mode, expression.ResultUnit,
+			result, expression.ResultExact, expression.ResultIm, string(matrix), expression.Deadline, expression.Priority, expression.SubExpressionsTotal,
+		})
+	}
+	// выражения и subexpressions сохраняются многострочными INSERT: запрос на каждую строку делает импорт
+	// десятков тысяч выражений слишком долгим
+	err = repositories.InsertRows(ctx, tx, "INSERT INTO expressions (id, user_id, idempotency_key, value, state, bindings, formula_versions, mode, result_unit, result, result_exact, result_im, result_matrix, deadline, priority, sub_expressions_total)",
+		"($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, '')::NUMERIC, $12, NULLIF($13, 'null')::JSONB, $14, $15, $16)",
+		rows)
+	if err != nil {
+		return 0, fmt.Errorf("create expression failure %e", err)
+	}
+	if err := subExpression.InsertSubExpressions(ctx, tx, subExpressions); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction failure %e", err)
+	}
+	return len(expressions), nil
+}
+
+// remainingDailyExpressions возвращает, сколько еще выражений пользователь может создать сегодня при суточной
+// квоте dailyLimit, -1 - без ограничения. Создания выражений пользователя блокируют друг друга до конца транзакции,
+// поэтому параллельные вызовы видят выражения, созданные до них, и не превышают квоту
+func remainingDailyExpressions(ctx context.Context, tx *sql.Tx, userId string, dailyLimit int) (int, error) {
+	if dailyLimit <= 0 {
+		return -1, nil
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", userId); err != nil {
+		return 0, fmt.Errorf("lock user quota failure %e", err)
+	}
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM expressions WHERE user_id=$1 AND created_at >= date_trunc('day', NOW())", userId).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count expressions failure %e", err)
+	}
+	return max(0, dailyLimit-count), nil
+}
+
+// expressionsSubExpressions возвращает subexpressions выражений expressions
+func expressionsSubExpressions(expressions []*models.Expression, subExpressions []*models.SubExpression) []*models.SubExpression {
+	ids := make(map[string]struct{}, len(expressions))
+	for _, expression := range expressions {
+		ids[expression.Id] = struct{}{}
+	}
+	var result []*models.SubExpression
+	for _, subExpr := range subExpressions {
+		if _, ok := ids[subExpr.ExpressionId.String()]; ok {
+			result = append(result, subExpr)
+		}
+	}
+	return result
+}
+
+func (r *PostgresRepository) GetExpressionIdsByKeys(ctx context.Context, keys []string, userId string) (map[string]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT idempotency_key, id FROM expressions WHERE user_id=$1 AND idempotency_key = ANY($2)",
+		userId, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string)
+	for rows.Next() {
+		var key, id string
+		if err := rows.Scan(&key, &id); err != nil {
+			return nil, err
+		}
+		ids[key] = id
+	}
+	return ids, rows.Err()
+}
+
 func (r *PostgresRepository) GetExpressions(ctx context.Context, userId string) ([]*models.Expression, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, idempotency_key, value, state, result FROM expressions WHERE user_id=$1", userId)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+expressionColumns+" FROM expressions WHERE user_id=$1", userId)
 	if err != nil {
 		return nil, fmt.Errorf("get expression failure %e", err)
 	}
@@ -59,17 +238,11 @@ func (r *PostgresRepository) GetExpressions(ctx context.Context, userId string)
 
 	var expressions []*models.Expression
 	for rows.Next() {
-		var expr models.Expression
-		var result sql.NullFloat64
-		if err := rows.Scan(&expr.Id, &expr.UserId, &expr.IdempotencyKey, &expr.Value, &expr.State, &result); err != nil {
+		expr, err := scanExpression(rows)
+		if err != nil {
 			return nil, err
 		}
-		if result.Valid {
-			expr.Result = result.Float64
-		} else {
-			expr.Result = 0 // или любое другое значение по умолчанию
-		}
-		expressions = append(expressions, &expr)
+		expressions = append(expressions, expr)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -82,32 +255,33 @@ func (r *PostgresRepository) GetExpressions(ctx context.Context, userId string)
 func (r *PostgresRepository) GetExpressionById(ctx context.Context, id, userId string) (*models.Expression, error) {
 	const op = "repositories.postgres.GetExpressionById"
 
-	row := r.db.QueryRowContext(ctx, "SELECT id, user_id, idempotency_key, value, state, result FROM expressions WHERE id=$1 and user_id=$2", id, userId)
-	var expr models.Expression
-	var result sql.NullFloat64
-	if err := row.Scan(&expr.Id, &expr.UserId, &expr.IdempotencyKey, &expr.Value, &expr.State, &result); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("%s: %w", op, repositories.ErrExpressionNotFound)
-		}
-		return nil, err
+	expr, err := scanExpression(r.db.QueryRowContext(ctx, "SELECT "+expressionColumns+" FROM expressions WHERE id=$1 and user_id=$2", id, userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", op, repositories.ErrExpressionNotFound)
 	}
-	if result.Valid {
-		expr.Result = result.Float64
-	} else {
-		expr.Result = 0 // или любое другое значение по умолчанию
-	}
-
-	return &expr, nil
+	return expr, err
 }
 
 func (r *PostgresRepository) GetExpressionByKey(ctx context.Context, key, userId string) (*models.Expression, error) {
-	row := r.db.QueryRowContext(ctx, "SELECT id, user_id, idempotency_key, value, state, result FROM expressions WHERE idempotency_key=$1 AND user_id=$2", key, userId)
+	expr, err := scanExpression(r.db.QueryRowContext(ctx, "SELECT "+expressionColumns+" FROM expressions WHERE idempotency_key=$1 AND user_id=$2", key, userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return expr, err
+}
+
+// expressionColumns колонки expressions в порядке scanExpression
+const expressionColumns = "id, user_id, idempotency_key, value, state, result, error_reason, bindings, formula_versions, mode, result_exact, result_unit, result_im, result_matrix, sub_expressions_done, sub_expressions_total, deadline, priority"
+
+// scanExpression читает expression из строки с колонками expressionColumns
+func scanExpression(row interface{ Scan(dest ...any) error }) (*models.Expression, error) {
 	var expr models.Expression
 	var result sql.NullFloat64
-	if err := row.Scan(&expr.Id, &expr.UserId, &expr.IdempotencyKey, &expr.Value, &expr.State, &result); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
+	var errorReason, resultExact sql.NullString
+	var deadline sql.NullTime
+	var bindings, formulaVersions, resultMatrix []byte
+	if err := row.Scan(&expr.Id, &expr.UserId, &expr.IdempotencyKey, &expr.Value, &expr.State, &result, &errorReason, &bindings, &formulaVersions, &expr.Mode, &resultExact, &expr.ResultUnit, &expr.ResultIm, &resultMatrix,
+		&expr.SubExpressionsDone, &expr.SubExpressionsTotal, &deadline, &expr.Priority); err != nil {
 		return nil, err
 	}
 	if result.Valid {
@@ -115,7 +289,20 @@ func (r *PostgresRepository) GetExpressionByKey(ctx context.Context, key, userId
 	} else {
 		expr.Result = 0 // или любое другое значение по умолчанию
 	}
-
+	expr.ErrorReason = errorReason.String
+	expr.ResultExact = resultExact.String
+	if deadline.Valid {
+		expr.Deadline = &deadline.Time
+	}
+	if err := unmarshalJSON(bindings, &expr.Bindings); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSON(formulaVersions, &expr.FormulaVersions); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSON(resultMatrix, &expr.ResultMatrix); err != nil {
+		return nil, err
+	}
 	return &expr, nil
 }
 
@@ -125,9 +312,13 @@ func (r *PostgresRepository) UpdateExpression(ctx context.Context, expression *m
 	return err
 }
 
-func (r *PostgresRepository) UpdateExpressionById(ctx context.Context, id uuid.UUID, result float64) error {
-	_, err := r.db.ExecContext(ctx, "UPDATE expressions SET result=$1, state=$3 WHERE id=$2",
-		result, id, models.ExpressionState(models.ExpressionOk))
+func (r *PostgresRepository) UpdateExpressionById(ctx context.Context, id uuid.UUID, result float64, resultExact string, resultIm float64, resultMatrix *models.Matrix) error {
+	matrix, err := json.Marshal(resultMatrix)
+	if err != nil {
+		return fmt.Errorf("marshal result matrix failure %e", err)
+	}
+	_, err = r.db.ExecContext(ctx, "UPDATE expressions SET result=$1, state=$3, result_exact=NULLIF($4, '')::NUMERIC, result_im=$5, result_matrix=NULLIF($6, 'null')::JSONB WHERE id=$2 AND state=$7 AND (deadline IS NULL OR deadline > NOW())",
+		result, id, models.ExpressionState(models.ExpressionOk), resultExact, resultIm, string(matrix), models.ExpressionInProgress)
 	return err
 }
 
@@ -137,6 +328,76 @@ func (r *PostgresRepository) UpdateState(ctx context.Context, key string, state
 	return err
 }
 
+func (r *PostgresRepository) UpdateExpressionError(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE expressions SET state=$2, error_reason=$3 WHERE id=$1 AND state=$4 AND (deadline IS NULL OR deadline > NOW())",
+		id, models.ExpressionError, reason, models.ExpressionInProgress)
+	return err
+}
+
+func (r *PostgresRepository) CancelExpression(ctx context.Context, id, userId string) error {
+	const op = "repositories.postgres.CancelExpression"
+
+	res, err := r.db.ExecContext(ctx, "UPDATE expressions SET state=$3 WHERE id=$1 AND user_id=$2 AND state=$4",
+		id, userId, models.ExpressionCancelled, models.ExpressionInProgress)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected > 0 {
+		return err
+	}
+	// выражения нет или оно уже завершено
+	if _, err := r.GetExpressionById(ctx, id, userId); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s: %w", op, repositories.ErrExpressionFinished)
+}
+
+func (r *PostgresRepository) GetExpressionStatus(ctx context.Context, id uuid.UUID) (*models.ExpressionStatus, error) {
+	var status models.ExpressionStatus
+	var deadline sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT user_id, state, deadline FROM expressions WHERE id=$1", id).Scan(&status.UserId, &status.State, &deadline)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrExpressionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline.Valid {
+		status.Deadline = &deadline.Time
+	}
+	return &status, nil
+}
+
+func (r *PostgresRepository) ExpireExpressions(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := r.db.QueryContext(ctx, "UPDATE expressions SET state=$1, error_reason=$2 WHERE state=$3 AND deadline <= NOW() RETURNING id",
+		models.ExpressionTimedOut, "deadline exceeded", models.ExpressionInProgress)
+	if err != nil {
+		return nil, fmt.Errorf("expire expressions failure %e", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *PostgresRepository) AddSubExpressionsTotal(ctx context.Context, id uuid.UUID, count int) error {
+	const op = "repositories.postgres.AddSubExpressionsTotal"
+
+	_, err := r.db.ExecContext(ctx, "UPDATE expressions SET sub_expressions_total = sub_expressions_total + $2 WHERE id = $1",
+		id, count)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
 func (r *PostgresRepository) DeleteExpressionById(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.ExecContext(ctx, "DELETE FROM expressions WHERE id=$1",
 		id.String())
@@ -146,6 +407,17 @@ func (r *PostgresRepository) DeleteExpressionById(ctx context.Context, id uuid.U
 	return nil
 }
 
+// unmarshalJSON разбирает jsonb колонку выражения (значения переменных, версии формул) в dst
+func unmarshalJSON(data []byte, dst any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("unmarshal jsonb failure %e", err)
+	}
+	return nil
+}
+
 // Close closes the database connection.
 func (r *PostgresRepository) Close() error {
 	return r.db.Close()
@@ -0,0 +1,13 @@
+package formula
+
+import (
+	"context"
+	"myproject/internal/models"
+)
+
+type Repository interface {
+	// CreateFormula сохраняет формулу пользователя новой версией, предыдущие версии остаются без изменений
+	CreateFormula(ctx context.Context, formula *models.Formula) (*models.Formula, error)
+	// GetFormulas возвращает последние версии всех формул пользователя по имени формулы
+	GetFormulas(ctx context.Context, userId string) (map[string]*models.Formula, error)
+}
@@ -0,0 +1,71 @@
+package formula
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/lib/pq"
+	"myproject/internal/models"
+)
+
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresRepository(dataSourceName string) (*PostgresRepository, error) {
+	db, err := sql.Open("pgx", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Check the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &PostgresRepository{db}, nil
+}
+
+func (r *PostgresRepository) CreateFormula(ctx context.Context, formula *models.Formula) (*models.Formula, error) {
+	const op = "repositories.postgres.CreateFormula"
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO formulas (user_id, name, params, body, version)
+		VALUES ($1, $2, $3, $4, (SELECT COALESCE(MAX(version), 0) + 1 FROM formulas WHERE user_id=$1 AND name=$2))
+		RETURNING id, version`,
+		formula.UserId, formula.Name, pq.Array(formula.Params), formula.Body).Scan(&formula.Id, &formula.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return formula, nil
+}
+
+func (r *PostgresRepository) GetFormulas(ctx context.Context, userId string) (map[string]*models.Formula, error) {
+	const op = "repositories.postgres.GetFormulas"
+
+	rows, err := r.db.QueryContext(ctx, `SELECT DISTINCT ON (name) id, user_id, name, params, body, version
+		FROM formulas WHERE user_id=$1 ORDER BY name, version DESC`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	formulas := make(map[string]*models.Formula)
+	for rows.Next() {
+		var formula models.Formula
+		if err := rows.Scan(&formula.Id, &formula.UserId, &formula.Name, pq.Array(&formula.Params), &formula.Body, &formula.Version); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		formulas[formula.Name] = &formula
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return formulas, nil
+}
+
+// Close closes the database connection.
+func (r *PostgresRepository) Close() error {
+	return r.db.Close()
+}
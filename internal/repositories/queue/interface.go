@@ -16,3 +16,10 @@ type Repository interface {
 	// Consume возвращает канал, откуда можно читать записи с очереди
 	Consume() (<-chan []byte, error)
 }
+
+// PriorityRepository очередь с приоритетами: записи с большим приоритетом читаются раньше
+type PriorityRepository interface {
+	Repository
+	// PublishWithPriority публикует запись в очередь с приоритетом priority
+	PublishWithPriority(task []byte, priority uint8) error
+}
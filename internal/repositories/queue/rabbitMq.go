@@ -16,13 +16,25 @@ type RabbitMQRepository struct {
 	closeCh   chan *amqp.Error
 	url       string
 	queueName string
+	// exchange fanout exchange, через который запись получают все подписчики, а не один из них
+	exchange string
+	// maxPriority наибольший приоритет записей очереди с приоритетами, 0 - очередь без приоритетов
+	maxPriority uint8
 }
 
 func NewRabbitMQRepository(url, queueName string) (*RabbitMQRepository, error) {
-	repo := &RabbitMQRepository{
-		url: url,
-	}
-	repo.queueName = queueName
+	return newRabbitMQRepository(&RabbitMQRepository{url: url, queueName: queueName})
+}
+
+// NewRabbitMQPriorityRepository создает очередь с приоритетами от 0 до maxPriority. Подписчик получает
+// следующую запись только после того, как прочитал предыдущую, поэтому записи с большим приоритетом,
+// опубликованные позже, обгоняют ожидающие в очереди. Аргументы существующей очереди не меняются: объявление
+// очереди без приоритетов с тем же именем завершается ошибкой PRECONDITION_FAILED, поэтому имя должно быть новым
+func NewRabbitMQPriorityRepository(url, queueName string, maxPriority uint8) (*RabbitMQRepository, error) {
+	return newRabbitMQRepository(&RabbitMQRepository{url: url, queueName: queueName, maxPriority: maxPriority})
+}
+
+func newRabbitMQRepository(repo *RabbitMQRepository) (*RabbitMQRepository, error) {
 	ticker := time.NewTicker(time.Second / 2)
 	var err error
 	count := 0
@@ -43,6 +55,18 @@ func NewRabbitMQRepository(url, queueName string) (*RabbitMQRepository, error) {
 	return repo, nil
 }
 
+// NewRabbitMQFanoutRepository создает очередь, записи которой получает каждый подписчик: соединение открывается
+// сразу, повторно вызывать Connect не нужно. Публикующий объявляет только fanout exchange с именем exchange,
+// а подписчик при Consume - свою временную очередь, привязанную к нему
+func NewRabbitMQFanoutRepository(url, exchange string) (*RabbitMQRepository, error) {
+	repo := &RabbitMQRepository{url: url, exchange: exchange}
+	if err := repo.Connect(); err != nil {
+		return nil, err
+	}
+	repo.NotifyClose()
+	return repo, nil
+}
+
 func (r *RabbitMQRepository) Connect() error {
 	var err error
 	r.conn, err = amqp.Dial(r.url)
@@ -56,13 +80,17 @@ func (r *RabbitMQRepository) Connect() error {
 		return fmt.Errorf("failed to open a channel: %w", err)
 	}
 
+	if r.exchange != "" {
+		return r.declareFanout()
+	}
+
 	r.queue, err = r.channel.QueueDeclare(
-		r.queueName, // name
-		true,        // durable
-		false,       // delete when unused
-		false,       // exclusive
-		false,       // no-wait
-		nil,         // arguments
+		r.queueName,   // name
+		true,          // durable
+		false,         // delete when unused
+		false,         // exclusive
+		false,         // no-wait
+		r.arguments(), // arguments
 	)
 	if err != nil {
 		r.channel.Close() // Закрываем канал, если очередь не объявлена
@@ -70,6 +98,64 @@ func (r *RabbitMQRepository) Connect() error {
 		return fmt.Errorf("failed to declare a queue: %w", err)
 	}
 
+	if r.maxPriority > 0 {
+		// без ограничения RabbitMQ сразу передает подписчику все записи очереди и приоритеты не учитываются
+		err = r.channel.Qos(1, 0, false)
+		if err != nil {
+			r.channel.Close()
+			r.conn.Close()
+			return fmt.Errorf("failed to set prefetch count: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// arguments возвращает аргументы объявления очереди
+func (r *RabbitMQRepository) arguments() amqp.Table {
+	if r.maxPriority == 0 {
+		return nil
+	}
+	return amqp.Table{"x-max-priority": int32(r.maxPriority)}
+}
+
+// declareFanout объявляет fanout exchange. Очередь подписчика объявляется в Consume, иначе в очереди
+// публикующего, которую никто не читает, копились бы все записи
+func (r *RabbitMQRepository) declareFanout() error {
+	err := r.channel.ExchangeDeclare(
+		r.exchange, // name
+		"fanout",   // type
+		true,       // durable
+		false,      // auto-deleted
+		false,      // internal
+		false,      // no-wait
+		nil,        // arguments
+	)
+	if err != nil {
+		r.channel.Close()
+		r.conn.Close()
+		return fmt.Errorf("failed to declare a fanout exchange: %w", err)
+	}
+	return nil
+}
+
+// bindFanoutQueue объявляет временную очередь подписчика и привязывает ее к fanout exchange
+func (r *RabbitMQRepository) bindFanoutQueue() error {
+	queue, err := r.channel.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare a fanout queue: %w", err)
+	}
+	if err := r.channel.QueueBind(queue.Name, "", r.exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind a fanout queue: %w", err)
+	}
+	r.queue = queue
 	return nil
 }
 
@@ -90,18 +176,23 @@ func (r *RabbitMQRepository) Close() error {
 }
 
 func (r *RabbitMQRepository) Publish(task []byte) error {
+	return r.PublishWithPriority(task, 0)
+}
+
+func (r *RabbitMQRepository) PublishWithPriority(task []byte, priority uint8) error {
 	select {
 	case err := <-r.closeCh:
 		return fmt.Errorf("publish failed: %w", err)
 	default:
 		err := r.channel.Publish(
-			"",           // exchange
+			r.exchange,   // exchange
 			r.queue.Name, // routing key
 			false,        // mandatory
 			false,        // immediate
 			amqp.Publishing{
 				ContentType: "text/plain",
 				Body:        task,
+				Priority:    priority,
 			})
 
 		return err
@@ -113,10 +204,17 @@ func (r *RabbitMQRepository) Consume() (<-chan []byte, error) {
 	case err := <-r.closeCh:
 		return nil, err
 	default:
+		if r.exchange != "" {
+			if err := r.bindFanoutQueue(); err != nil {
+				return nil, err
+			}
+		}
+		// в очереди с приоритетами запись подтверждается после того, как подписчик ее прочитал
+		autoAck := r.maxPriority == 0
 		msgs, err := r.channel.Consume(
 			r.queue.Name, // queue
 			"",           // consumer
-			true,         // auto-ack
+			autoAck,      // auto-ack
 			false,        // exclusive
 			false,        // no-local
 			false,        // no-wait
@@ -130,6 +228,11 @@ func (r *RabbitMQRepository) Consume() (<-chan []byte, error) {
 		go func() {
 			for msg := range msgs {
 				taskChan <- msg.Body
+				if !autoAck {
+					if err := msg.Ack(false); err != nil {
+						log.Printf("Failed to ack message: %v", err)
+					}
+				}
 			}
 		}()
 
@@ -0,0 +1,11 @@
+package quota
+
+import (
+	"context"
+	"myproject/internal/models"
+)
+
+type Repository interface {
+	// GetQuota возвращает ограничения пользователя из таблицы user_quotas, nil - ограничения не заданы
+	GetQuota(ctx context.Context, userId string) (*models.Quota, error)
+}
@@ -0,0 +1,49 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"myproject/internal/models"
+)
+
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresRepository(dataSourceName string) (*PostgresRepository, error) {
+	db, err := sql.Open("pgx", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Check the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &PostgresRepository{db}, nil
+}
+
+func (r *PostgresRepository) GetQuota(ctx context.Context, userId string) (*models.Quota, error) {
+	const op = "repositories.postgres.GetQuota"
+
+	quota := models.Quota{UserId: userId}
+	err := r.db.QueryRowContext(ctx, "SELECT daily_expressions, max_in_flight, weight FROM user_quotas WHERE user_id=$1", userId).
+		Scan(&quota.DailyExpressions, &quota.MaxInFlight, &quota.Weight)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &quota, nil
+}
+
+// Close closes the database connection.
+func (r *PostgresRepository) Close() error {
+	return r.db.Close()
+}
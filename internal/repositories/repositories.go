@@ -1,10 +1,61 @@
 package repositories
 
-import "errors"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
 
 var (
 	ErrUserExists         = errors.New("user already exists")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrAppNotFound        = errors.New("app not found")
 	ErrExpressionNotFound = errors.New("expression not found")
+	ErrExpressionFinished = errors.New("expression already finished")
+	ErrQuotaExceeded      = errors.New("daily expressions quota exceeded")
 )
+
+// maxQueryParams наибольшее количество параметров в одном запросе Postgres
+const maxQueryParams = 65535
+
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// Execer выполняет запрос в бд или в транзакции
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// InsertRows сохраняет строки многострочными INSERT вместо запроса на каждую строку. insert - запрос до VALUES,
+// row - значения одной строки с параметрами $1..$n (n = len(rows[i])), которые перенумеровываются для каждой
+// строки. Строки разбиваются на запросы так, чтобы в каждом было не больше maxQueryParams параметров
+func InsertRows(ctx context.Context, db Execer, insert, row string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	batch := maxQueryParams / len(rows[0])
+	for start := 0; start < len(rows); start += batch {
+		end := min(start+batch, len(rows))
+		var query strings.Builder
+		query.WriteString(insert)
+		query.WriteString(" VALUES ")
+		args := make([]any, 0, (end-start)*len(rows[0]))
+		for i, values := range rows[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			offset := len(args)
+			query.WriteString(placeholder.ReplaceAllStringFunc(row, func(param string) string {
+				n, _ := strconv.Atoi(param[1:])
+				return "$" + strconv.Itoa(n+offset)
+			}))
+			args = append(args, values...)
+		}
+		if _, err := db.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type execCall struct {
+	query string
+	args  []any
+}
+
+type recordingExecer struct {
+	calls []execCall
+}
+
+func (e *recordingExecer) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	e.calls = append(e.calls, execCall{query: query, args: args})
+	return nil, nil
+}
+
+func TestInsertRows(t *testing.T) {
+	db := &recordingExecer{}
+	err := InsertRows(context.Background(), db, "INSERT INTO t (a, b)", "($2, NULLIF($1, '')::UUID)", [][]any{{"x", 1}, {"y", 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []execCall{{
+		query: "INSERT INTO t (a, b) VALUES ($2, NULLIF($1, '')::UUID), ($4, NULLIF($3, '')::UUID)",
+		args:  []any{"x", 1, "y", 2},
+	}}, db.calls)
+}
+
+func TestInsertRowsBatches(t *testing.T) {
+	rows := make([][]any, maxQueryParams/2+1)
+	for i := range rows {
+		rows[i] = []any{i, i}
+	}
+	db := &recordingExecer{}
+	assert.NoError(t, InsertRows(context.Background(), db, "INSERT INTO t (a, b)", "($1, $2)", rows))
+	assert.Len(t, db.calls, 2)
+	assert.Len(t, db.calls[0].args, maxQueryParams-1)
+	assert.Equal(t, []any{maxQueryParams / 2, maxQueryParams / 2}, db.calls[1].args)
+
+	db = &recordingExecer{}
+	assert.NoError(t, InsertRows(context.Background(), db, "INSERT INTO t (a, b)", "($1, $2)", nil))
+	assert.Empty(t, db.calls)
+}
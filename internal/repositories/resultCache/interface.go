@@ -0,0 +1,43 @@
+package resultCache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"myproject/internal/models"
+)
+
+// Repository кэш результатов subexpressions по их содержимому: операции, режиму и значениям операндов
+type Repository interface {
+	// Get возвращает результат по ключу, false - если записи нет или истек ее TTL
+	Get(ctx context.Context, key string) (*models.CalculationResult, bool, error)
+	// Set сохраняет результат, при превышении размера кэша вытесняются давно неиспользуемые записи
+	Set(ctx context.Context, key string, result *models.CalculationResult) error
+	// Len возвращает количество записей в кэше
+	Len(ctx context.Context) (int, error)
+}
+
+// Key возвращает ключ subexpression, операнды которого уже известны: у subexpressions с одинаковыми операцией,
+// режимом и значениями операндов ключи совпадают, даже если они из разных выражений
+func Key(subExpr *models.SubExpression) string {
+	content, _ := json.Marshal(struct {
+		Action     string
+		Mode       models.ExpressionMode
+		Val1       float64
+		Val2       float64
+		Args       []float64
+		Val1Exact  string
+		Val2Exact  string
+		ArgsExact  []string
+		Val1Im     float64
+		Val2Im     float64
+		ArgsIm     []float64
+		Val1Matrix *models.Matrix
+		Val2Matrix *models.Matrix
+		ArgsMatrix []*models.Matrix
+	}{subExpr.Action, subExpr.Mode, subExpr.Val1, subExpr.Val2, subExpr.Args, subExpr.Val1Exact, subExpr.Val2Exact, subExpr.ArgsExact,
+		subExpr.Val1Im, subExpr.Val2Im, subExpr.ArgsIm, subExpr.Val1Matrix, subExpr.Val2Matrix, subExpr.ArgsMatrix})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
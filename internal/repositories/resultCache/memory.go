@@ -0,0 +1,45 @@
+package resultCache
+
+import (
+	"context"
+	"myproject/internal/lib/lru"
+	"myproject/internal/models"
+	"time"
+)
+
+// MemoryRepository кэш результатов в памяти оркестратора, не переживает его перезапуск
+type MemoryRepository struct {
+	cache *lru.Cache[string, memoryEntry]
+	ttl   time.Duration
+}
+
+type memoryEntry struct {
+	result    *models.CalculationResult
+	expiresAt time.Time
+}
+
+// NewMemoryRepository создает кэш на size записей, каждая из которых хранится не дольше ttl
+func NewMemoryRepository(size int, ttl time.Duration) *MemoryRepository {
+	return &MemoryRepository{cache: lru.New[string, memoryEntry](size), ttl: ttl}
+}
+
+func (r *MemoryRepository) Get(_ context.Context, key string) (*models.CalculationResult, bool, error) {
+	entry, ok := r.cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		r.cache.Remove(key)
+		return nil, false, nil
+	}
+	return entry.result, true, nil
+}
+
+func (r *MemoryRepository) Set(_ context.Context, key string, result *models.CalculationResult) error {
+	r.cache.Add(key, memoryEntry{result: result, expiresAt: time.Now().Add(r.ttl)})
+	return nil
+}
+
+func (r *MemoryRepository) Len(_ context.Context) (int, error) {
+	return r.cache.Len(), nil
+}
@@ -0,0 +1,88 @@
+package resultCache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"myproject/internal/models"
+	"time"
+)
+
+// PostgresRepository кэш результатов в таблице result_cache, общий для всех оркестраторов
+type PostgresRepository struct {
+	db   *sql.DB
+	size int
+	ttl  time.Duration
+}
+
+// NewPostgresRepository создает кэш на size записей, каждая из которых хранится не дольше ttl
+func NewPostgresRepository(dataSourceName string, size int, ttl time.Duration) (*PostgresRepository, error) {
+	db, err := sql.Open("pgx", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Check the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &PostgresRepository{db: db, size: size, ttl: ttl}, nil
+}
+
+func (r *PostgresRepository) Get(ctx context.Context, key string) (*models.CalculationResult, bool, error) {
+	const op = "repositories.postgres.GetResult"
+
+	var content []byte
+	err := r.db.QueryRowContext(ctx, "UPDATE result_cache SET used_at = NOW() WHERE key = $1 AND expires_at > NOW() RETURNING result",
+		key).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+	result := &models.CalculationResult{}
+	if err := json.Unmarshal(content, result); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+	return result, true, nil
+}
+
+func (r *PostgresRepository) Set(ctx context.Context, key string, result *models.CalculationResult) error {
+	const op = "repositories.postgres.SetResult"
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO result_cache (key, result, expires_at) VALUES ($1, $2::JSONB, NOW() + $3 * INTERVAL '1 second')
+		ON CONFLICT (key) DO UPDATE SET result = EXCLUDED.result, expires_at = EXCLUDED.expires_at, used_at = NOW()`,
+		key, string(content), r.ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// удаляем истекшие записи и давно неиспользуемые сверх размера кэша
+	_, err = r.db.ExecContext(ctx, `DELETE FROM result_cache WHERE expires_at <= NOW()
+		OR key IN (SELECT key FROM result_cache ORDER BY used_at DESC OFFSET $1)`,
+		r.size)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (r *PostgresRepository) Len(ctx context.Context) (int, error) {
+	const op = "repositories.postgres.LenResults"
+
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM result_cache WHERE expires_at > NOW()").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return count, nil
+}
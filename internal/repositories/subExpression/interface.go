@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/google/uuid"
 	"myproject/internal/models"
+	"time"
 )
 
 type Repository interface {
@@ -17,12 +18,25 @@ type Repository interface {
 	GetSubExpressionsList(ctx context.Context) ([]*models.SubExpression, error)
 	// DeleteSubExpressionsByExpressionId удаляет subexpression по его ID
 	DeleteSubExpressionsByExpressionId(ctx context.Context, expressionId uuid.UUID) error
-	// UpdateSubExpressionAgent обновляет agent_id у subexpression
-	UpdateSubExpressionAgent(ctx context.Context, idSubExpression, agentId uuid.UUID) error
-	// DeleteSubExpressionById удаляет subexpression по его id
-	DeleteSubExpressionById(ctx context.Context, id uuid.UUID) error
-	// GetNotCalculatedSubExpressionsByAgentId удаляет неподсчитанные subexpression по agent_id
-	GetNotCalculatedSubExpressionsByAgentId(ctx context.Context, agentId uuid.UUID) ([]*models.SubExpression, error)
-	// ReplaceExpressionsIds меняет sub_expression1 и sub_expression2 с oldId на newId
-	ReplaceExpressionsIds(ctx context.Context, oldId uuid.UUID, newId uuid.UUID) error
+	// StartLease начинает новую отправку неподсчитанного subexpression агентам: увеличивает счетчик попыток,
+	// записывает попытку в историю и выдает аренду на ttl до того, как агент возьмет subexpression. Возвращает номер попытки,
+	// 0 - subexpression уже посчитан или удален
+	StartLease(ctx context.Context, id uuid.UUID, ttl time.Duration) (int, error)
+	// RenewLease продлевает на ttl аренду агента, если она относится к последней попытке
+	RenewLease(ctx context.Context, lease *models.Lease, ttl time.Duration) error
+	// CompleteLease завершает аренду посчитанного subexpression и записывает его результат. false - результат
+	// уже получен от другой попытки или subexpression удален, этот результат не нужен
+	CompleteLease(ctx context.Context, expression *models.SubExpression) (bool, error)
+	// ExpireLeases снимает истекшие аренды, отмечает subexpressions для немедленной повторной отправки
+	// и возвращает их. Время отправки можно отложить через DelayRetry
+	ExpireLeases(ctx context.Context) ([]*models.SubExpression, error)
+	// GetReadySubExpressions возвращает неподсчитанные subexpressions, которые могут считаться, но не отправлены
+	// агентам и не ждут повторной отправки: до перезапуска оркестратора они ждали своей очереди в его памяти
+	GetReadySubExpressions(ctx context.Context) ([]*models.SubExpression, error)
+	// DelayRetry откладывает повторную отправку subexpression на delay от текущего времени
+	DelayRetry(ctx context.Context, id uuid.UUID, delay time.Duration) error
+	// TakeRetries возвращает subexpressions, время повторной отправки которых наступило, и снимает с них отметку
+	TakeRetries(ctx context.Context) ([]*models.SubExpression, error)
+	// GetAttempts возвращает историю отправок агентам subexpressions выражения
+	GetAttempts(ctx context.Context, expressionId uuid.UUID) ([]*models.SubExpressionAttempt, error)
 }
@@ -4,12 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
 	"log"
 	"myproject/internal/models"
+	"myproject/internal/repositories"
+	"strings"
 	"time"
 )
 
@@ -54,17 +57,41 @@ func NewPostgresRepository(dataSourceName string) (*PostgresRepository, error) {
 }
 
 func (r *PostgresRepository) CreateSubExpression(ctx context.Context, subExpression *models.SubExpression) (*models.SubExpression, error) {
-	var id uuid.UUID
+	subExpression.Id = uuid.New()
+	if err := InsertSubExpression(ctx, r.db, subExpression); err != nil {
+		return nil, err
+	}
+	return subExpression, nil
+}
 
-	err := r.db.QueryRowContext(ctx, "INSERT INTO sub_expressions (id, expressions_id, val1, val2,sub_expression_id1,sub_expression_id2,is_last, action, error) VALUES (gen_random_uuid(), $1, $2, $3, NULLIF($4, '')::UUID, NULLIF($5, '')::UUID, $6, $7, $8) RETURNING id",
-		subExpression.ExpressionId, subExpression.Val1, subExpression.Val2, subExpression.SubExpressionId1, subExpression.SubExpressionId2, subExpression.IsLast, subExpression.Action, subExpression.Error).Scan(&id)
+// InsertSubExpression сохраняет subexpression с уже назначенным id
+func InsertSubExpression(ctx context.Context, db repositories.Execer, subExpression *models.SubExpression) error {
+	return InsertSubExpressions(ctx, db, []*models.SubExpression{subExpression})
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("create expression failure %e", err)
+// InsertSubExpressions сохраняет subexpressions с уже назначенными id многострочными INSERT. db - бд или транзакция,
+// в которой вместе с subexpressions сохраняются их выражения (см. expression.Repository.CreateExpressions)
+func InsertSubExpressions(ctx context.Context, db repositories.Execer, subExpressions []*models.SubExpression) error {
+	rows := make([][]any, 0, len(subExpressions))
+	for _, subExpression := range subExpressions {
+		val1Matrix, val2Matrix, argsMatrix, err := marshalMatrices(subExpression.Val1Matrix, subExpression.Val2Matrix, subExpression.ArgsMatrix)
+		if err != nil {
+			return fmt.Errorf("marshal matrices failure %e", err)
+		}
+		rows = append(rows, []any{
+			subExpression.ExpressionId, subExpression.Val1, subExpression.Val2, subExpression.SubExpressionId1, subExpression.SubExpressionId2, subExpression.IsLast, subExpression.Action, subExpression.Error,
+			pq.Array(subExpression.Args), pq.Array(subExpression.ArgIds), expressionMode(subExpression.Mode), subExpression.Val1Exact, subExpression.Val2Exact, pq.Array(subExpression.ArgsExact), subExpression.GuardId, subExpression.GuardBranch,
+			subExpression.Val1Im, subExpression.Val2Im, pq.Array(subExpression.ArgsIm), val1Matrix, val2Matrix, argsMatrix, subExpression.Id, subExpression.Priority,
+		})
 	}
 
-	subExpression.Id = id
-	return subExpression, nil
+	err := repositories.InsertRows(ctx, db, "INSERT INTO sub_expressions (id, expressions_id, val1, val2,sub_expression_id1,sub_expression_id2,is_last, action, error, args, arg_ids, mode, val1_exact, val2_exact, args_exact, guard_id, guard_branch, val1_im, val2_im, args_im, val1_matrix, val2_matrix, args_matrix, priority)",
+		"($23, $1, $2, $3, NULLIF($4, '')::UUID, NULLIF($5, '')::UUID, $6, $7, $8, $9, $10, $11, NULLIF($12, '')::NUMERIC, NULLIF($13, '')::NUMERIC, $14, $15, $16, $17, $18, $19, NULLIF($20, 'null')::JSONB, NULLIF($21, 'null')::JSONB, NULLIF($22, 'null')::JSONB, $24)",
+		rows)
+	if err != nil {
+		return fmt.Errorf("create expression failure %e", err)
+	}
+	return nil
 }
 
 // handleNotification обрабатывает триггеры бд (триггер по обновлению и добавлению subexpressions)
@@ -127,36 +154,115 @@ func (r *PostgresRepository) GetSubExpressionsList(ctx context.Context) ([]*mode
 }
 
 func (r *PostgresRepository) UpdateSubExpressions(ctx context.Context, expression *models.SubExpression) error {
-	_, err := r.db.ExecContext(ctx, "UPDATE sub_expressions SET result=$1 WHERE id=$2",
-		expression.Result, expression.Id)
+	resultMatrix, err := json.Marshal(expression.ResultMatrix)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions SET result=$1, result_exact=NULLIF($3, '')::NUMERIC, result_im=$4 WHERE id=$2",
+		expression.Result, expression.Id, expression.ResultExact, expression.ResultIm)
+	if err != nil {
+		return err
+	}
+	err = r.resolveBranches(ctx, expression)
 	if err != nil {
 		return err
 	}
 
-	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions\nSET val1 = CASE WHEN sub_expression_id1 = $1 THEN $2 ELSE val1 END, val2 = CASE WHEN sub_expression_id2 = $1 THEN $2 ELSE val2 END\nWHERE sub_expression_id1 = $1 OR sub_expression_id2 = $1;\n",
-		expression.Id, expression.Result)
+	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions\nSET val1 = CASE WHEN sub_expression_id1 = $1 THEN $2 ELSE val1 END, val2 = CASE WHEN sub_expression_id2 = $1 THEN $2 ELSE val2 END,\n    val1_exact = CASE WHEN sub_expression_id1 = $1 THEN NULLIF($3, '')::NUMERIC ELSE val1_exact END, val2_exact = CASE WHEN sub_expression_id2 = $1 THEN NULLIF($3, '')::NUMERIC ELSE val2_exact END,\n    val1_im = CASE WHEN sub_expression_id1 = $1 THEN $4 ELSE val1_im END, val2_im = CASE WHEN sub_expression_id2 = $1 THEN $4 ELSE val2_im END,\n    val1_matrix = CASE WHEN sub_expression_id1 = $1 THEN NULLIF($5, 'null')::JSONB ELSE val1_matrix END, val2_matrix = CASE WHEN sub_expression_id2 = $1 THEN NULLIF($5, 'null')::JSONB ELSE val2_matrix END\nWHERE sub_expression_id1 = $1 OR sub_expression_id2 = $1;\n",
+		expression.Id, expression.Result, expression.ResultExact, expression.ResultIm, string(resultMatrix))
 	if err != nil {
 		return err
 	}
 	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions SET sub_expression_id1 = NULL WHERE sub_expression_id1 = $1",
 		expression.Id)
+	if err != nil {
+		return err
+	}
 	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions SET sub_expression_id2 = NULL WHERE sub_expression_id2 = $1",
 		expression.Id)
+	if err != nil {
+		return err
+	}
+	// подставляем результат в аргументы функций, зависящих от этого subexpression.
+	// Один subexpression может быть несколькими аргументами одной функции: dot(a+b, a+b)
+	_, err = r.db.ExecContext(ctx, `UPDATE sub_expressions s
+SET args    = (SELECT array_agg(CASE WHEN a.id = $1 THEN $2 ELSE a.val END ORDER BY a.n)
+               FROM unnest(s.args, s.arg_ids) WITH ORDINALITY AS a(val, id, n)),
+    args_exact = CASE WHEN s.args_exact IS NULL THEN NULL ELSE
+              (SELECT array_agg(CASE WHEN a.id = $1 THEN NULLIF($3, '')::NUMERIC ELSE a.val END ORDER BY a.n)
+               FROM unnest(s.args_exact, s.arg_ids) WITH ORDINALITY AS a(val, id, n)) END,
+    args_im = CASE WHEN s.args_im IS NULL THEN NULL ELSE
+              (SELECT array_agg(CASE WHEN a.id = $1 THEN $4 ELSE a.val END ORDER BY a.n)
+               FROM unnest(s.args_im, s.arg_ids) WITH ORDINALITY AS a(val, id, n)) END,
+    args_matrix = CASE WHEN s.args_matrix IS NULL OR NULLIF($5, 'null') IS NULL THEN s.args_matrix ELSE
+              (SELECT jsonb_agg(CASE WHEN a.id = $1 THEN NULLIF($5, 'null')::JSONB ELSE s.args_matrix -> (a.n - 1)::INT END ORDER BY a.n)
+               FROM unnest(s.arg_ids) WITH ORDINALITY AS a(id, n)) END,
+    arg_ids = (SELECT array_agg(NULLIF(a.id, $1) ORDER BY a.n)
+               FROM unnest(s.arg_ids) WITH ORDINALITY AS a(id, n))
+WHERE $1 = ANY(s.arg_ids)`,
+		expression.Id, expression.Result, expression.ResultExact, expression.ResultIm, string(resultMatrix))
+	return err
+}
+
+// resolveBranches выбирает ветки if, условием которых является посчитанный subexpression:
+// невыбранная ветка удаляется вместе с вложенными в нее ветками, выбранная отправляется агентам,
+// а в if вместо id невыбранной ветки ставится NULL, чтобы он посчитался по готовности выбранной
+func (r *PostgresRepository) resolveBranches(ctx context.Context, expression *models.SubExpression) error {
+	branch := isTruthy(expression)
+	_, err := r.db.ExecContext(ctx, `WITH RECURSIVE losing AS (
+    SELECT id FROM sub_expressions WHERE guard_id = $1 AND guard_branch <> $2
+    UNION
+    SELECT s.id FROM sub_expressions s JOIN losing l ON s.guard_id = l.id
+)
+DELETE FROM sub_expressions WHERE id IN (SELECT id FROM losing)`,
+		expression.Id, branch)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions SET guard_id = NULL WHERE guard_id = $1",
+		expression.Id)
+	if err != nil {
+		return err
+	}
+	// аргументы if: [условие, ветка true, ветка false], индексы массивов в postgres начинаются с 1
+	losingArg := 3
+	if !branch {
+		losingArg = 2
+	}
+	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions SET arg_ids[$2::INT] = NULL WHERE action = 'if' AND arg_ids[1] = $1",
+		expression.Id, losingArg)
 	return err
 }
 
+// isTruthy проверяет, что результат subexpression не равен нулю. В точном режиме проверяется точный результат,
+// так как очень маленькое число в приближении float64 может стать нулем, в комплексном - и мнимая часть
+func isTruthy(expression *models.SubExpression) bool {
+	if expression.Mode == models.ModeExact && expression.ResultExact != "" {
+		return strings.ContainsAny(expression.ResultExact, "123456789")
+	}
+	return expression.Result != 0 || expression.ResultIm != 0
+}
+
 func (r *PostgresRepository) GetExpressionByKey(ctx context.Context, key string) (*models.SubExpression, error) {
-	rows := r.db.QueryRowContext(ctx, "SELECT id, expressions_id, val1, val2, sub_expression_id1, sub_expression_id2, action, is_last, error FROM sub_expressions WHERE id = $1",
-		key)
+	return scanSubExpression(r.db.QueryRowContext(ctx, "SELECT "+subExpressionColumns+" FROM sub_expressions WHERE id = $1", key))
+}
+
+// subExpressionColumns колонки sub_expressions в порядке scanSubExpression
+const subExpressionColumns = "id, expressions_id, val1, val2, sub_expression_id1, sub_expression_id2, action, is_last, error, args, arg_ids, mode, val1_exact, val2_exact, args_exact, guard_id, guard_branch, val1_im, val2_im, args_im, val1_matrix, val2_matrix, args_matrix, priority, created_at::TIMESTAMPTZ, attempts"
+
+// scanSubExpression читает subexpression из строки с колонками subExpressionColumns
+func scanSubExpression(row interface{ Scan(dest ...any) error }) (*models.SubExpression, error) {
 	var expr models.SubExpression
-	var result sql.NullFloat64
-	if err := rows.Scan(&expr.Id, &expr.ExpressionId, &expr.Val1, &expr.Val2, &expr.SubExpressionId1, &expr.SubExpressionId2, &expr.Action, &expr.IsLast, &expr.Error); err != nil {
+	var val1Exact, val2Exact sql.NullString
+	var val1Matrix, val2Matrix, argsMatrix []byte
+	if err := row.Scan(&expr.Id, &expr.ExpressionId, &expr.Val1, &expr.Val2, &expr.SubExpressionId1, &expr.SubExpressionId2, &expr.Action, &expr.IsLast, &expr.Error,
+		pq.Array(&expr.Args), pq.Array(&expr.ArgIds), &expr.Mode, &val1Exact, &val2Exact, pq.Array(&expr.ArgsExact), &expr.GuardId, &expr.GuardBranch,
+		&expr.Val1Im, &expr.Val2Im, pq.Array(&expr.ArgsIm), &val1Matrix, &val2Matrix, &argsMatrix, &expr.Priority, &expr.CreatedAt, &expr.Attempt); err != nil {
 		return nil, err
 	}
-	if result.Valid {
-		expr.Result = result.Float64
-	} else {
-		expr.Result = 0 // или любое другое значение по умолчанию
+	expr.Val1Exact, expr.Val2Exact = val1Exact.String, val2Exact.String
+	if err := unmarshalMatrices(&expr, val1Matrix, val2Matrix, argsMatrix); err != nil {
+		return nil, err
 	}
 	return &expr, nil
 }
@@ -170,64 +276,205 @@ func (r *PostgresRepository) DeleteSubExpressionsByExpressionId(ctx context.Cont
 	return nil
 }
 
-func (r *PostgresRepository) UpdateSubExpressionAgent(ctx context.Context, idSubExpression, agentId uuid.UUID) error {
-	_, err := r.db.ExecContext(ctx, "UPDATE sub_expressions SET agent_id=$1 WHERE id=$2",
-		agentId, idSubExpression)
+func (r *PostgresRepository) StartLease(ctx context.Context, id uuid.UUID, ttl time.Duration) (int, error) {
+	const op = "repositories.postgres.StartLease"
+
+	var attempt int
+	err := r.db.QueryRowContext(ctx, `WITH started AS (
+    UPDATE sub_expressions
+    SET attempts = attempts + 1, agent_id = NULL, lease_expires_at = NOW() + $2 * INTERVAL '1 millisecond', retry_at = NULL
+    WHERE id = $1 AND result IS NULL
+    RETURNING id, expressions_id, attempts
+)
+INSERT INTO sub_expression_attempts (sub_expression_id, expression_id, attempt)
+SELECT id, expressions_id, attempts FROM started
+RETURNING attempt`,
+		id, ttl.Milliseconds()).Scan(&attempt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return attempt, nil
+}
+
+func (r *PostgresRepository) RenewLease(ctx context.Context, lease *models.Lease, ttl time.Duration) error {
+	const op = "repositories.postgres.RenewLease"
+
+	_, err := r.db.ExecContext(ctx, `WITH renewed AS (
+    UPDATE sub_expressions
+    SET agent_id = $2, lease_expires_at = NOW() + $4 * INTERVAL '1 millisecond'
+    WHERE id = $1 AND attempts = $3 AND result IS NULL
+    RETURNING id
+)
+UPDATE sub_expression_attempts a SET agent_id = $2
+FROM renewed WHERE a.sub_expression_id = renewed.id AND a.attempt = $3 AND a.agent_id IS NULL`,
+		lease.SubExpressionId, lease.AgentId, lease.Attempt, ttl.Milliseconds())
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
 
-func (r *PostgresRepository) DeleteSubExpressionById(ctx context.Context, id uuid.UUID) error {
-	_, err := r.db.ExecContext(ctx, "DELETE FROM sub_expressions WHERE id=$1",
-		id.String())
+func (r *PostgresRepository) CompleteLease(ctx context.Context, expression *models.SubExpression) (bool, error) {
+	const op = "repositories.postgres.CompleteLease"
+
+	outcome := models.AttemptCompleted
+	if expression.Error {
+		outcome = models.AttemptFailed
+	}
+	// попытка завершается, даже если результат уже получен от другой попытки
+	_, err := r.db.ExecContext(ctx, "UPDATE sub_expression_attempts SET finished_at = NOW(), outcome = $3 WHERE sub_expression_id = $1 AND attempt = $2 AND finished_at IS NULL",
+		expression.Id, expression.Attempt, outcome)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := r.db.ExecContext(ctx, "UPDATE sub_expressions SET result = $2, agent_id = NULL, lease_expires_at = NULL WHERE id = $1 AND result IS NULL",
+		expression.Id, expression.Result)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return affected > 0, nil
+}
+
+func (r *PostgresRepository) ExpireLeases(ctx context.Context) ([]*models.SubExpression, error) {
+	const op = "repositories.postgres.ExpireLeases"
+
+	rows, err := r.db.QueryContext(ctx, `WITH expired AS (
+    UPDATE sub_expressions
+    SET agent_id = NULL, lease_expires_at = NULL, retry_at = NOW()
+    WHERE result IS NULL AND lease_expires_at <= NOW()
+    RETURNING `+subExpressionColumns+`
+), finished AS (
+    UPDATE sub_expression_attempts a SET finished_at = NOW(), outcome = $1
+    FROM expired WHERE a.sub_expression_id = expired.id AND a.attempt = expired.attempts AND a.finished_at IS NULL
+)
+SELECT * FROM expired`, models.AttemptExpired)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	return scanSubExpressions(rows, op)
+}
+
+func (r *PostgresRepository) GetReadySubExpressions(ctx context.Context) ([]*models.SubExpression, error) {
+	const op = "repositories.postgres.GetReadySubExpressions"
+
+	// условие готовности то же, что в триггере notify_sub_expression_fields
+	rows, err := r.db.QueryContext(ctx, `SELECT `+subExpressionColumns+` FROM sub_expressions
+WHERE result IS NULL AND lease_expires_at IS NULL AND retry_at IS NULL
+  AND sub_expression_id1 IS NULL AND sub_expression_id2 IS NULL
+  AND cardinality(array_remove(coalesce(arg_ids, '{}'), NULL)) = 0 AND guard_id IS NULL
+ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	return scanSubExpressions(rows, op)
+}
+
+func (r *PostgresRepository) DelayRetry(ctx context.Context, id uuid.UUID, delay time.Duration) error {
+	const op = "repositories.postgres.DelayRetry"
+
+	_, err := r.db.ExecContext(ctx, `UPDATE sub_expressions SET retry_at = NOW() + $2 * INTERVAL '1 millisecond'
+WHERE id = $1 AND result IS NULL AND retry_at IS NOT NULL`,
+		id, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
 
-func (r *PostgresRepository) GetNotCalculatedSubExpressionsByAgentId(ctx context.Context, agentId uuid.UUID) ([]*models.SubExpression, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT id, expressions_id, sub_expression_id1, sub_expression_id2, val1, val2, action, is_last, error FROM sub_expressions WHERE agent_id=$1 AND result IS NULL",
-		agentId)
+func (r *PostgresRepository) TakeRetries(ctx context.Context) ([]*models.SubExpression, error) {
+	const op = "repositories.postgres.TakeRetries"
+
+	rows, err := r.db.QueryContext(ctx, `UPDATE sub_expressions SET retry_at = NULL
+WHERE result IS NULL AND retry_at <= NOW()
+RETURNING `+subExpressionColumns)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	defer rows.Close()
 
+	return scanSubExpressions(rows, op)
+}
+
+// scanSubExpressions читает subexpressions из строк с колонками subExpressionColumns
+func scanSubExpressions(rows *sql.Rows, op string) ([]*models.SubExpression, error) {
 	var expressions []*models.SubExpression
 	for rows.Next() {
-		var expr models.SubExpression
-		var result sql.NullFloat64
-		if err := rows.Scan(&expr.Id, &expr.ExpressionId, &expr.SubExpressionId1, &expr.SubExpressionId2, &expr.Val1, &expr.Val2, &expr.Action, &expr.IsLast, &expr.Error); err != nil {
-			return nil, err
-		}
-		if result.Valid {
-			expr.Result = result.Float64
-		} else {
-			expr.Result = 0 // или любое другое значение по умолчанию
+		expr, err := scanSubExpression(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		expressions = append(expressions, &expr)
+		expressions = append(expressions, expr)
 	}
+	return expressions, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+// expressionMode режим вычисления для записи в бд, по умолчанию - ModeFloat
+func expressionMode(mode models.ExpressionMode) models.ExpressionMode {
+	if mode == "" {
+		return models.ModeFloat
 	}
+	return mode
+}
 
-	return expressions, nil
+// marshalMatrices записывает матрицы операндов в JSON, nil записывается как "null" и сохраняется как NULL
+func marshalMatrices(val1, val2 *models.Matrix, args []*models.Matrix) (string, string, string, error) {
+	values := make([]string, 0, 3)
+	for _, value := range []any{val1, val2, args} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", "", "", err
+		}
+		values = append(values, string(data))
+	}
+	return values[0], values[1], values[2], nil
 }
 
-func (r *PostgresRepository) ReplaceExpressionsIds(ctx context.Context, oldId uuid.UUID, newId uuid.UUID) error {
-	_, err := r.db.ExecContext(ctx, "UPDATE sub_expressions SET sub_expression_id1=$1 WHERE sub_expression_id1=$2",
-		newId.String(), oldId.String())
-	if err != nil {
-		return err
+// unmarshalMatrices читает матрицы операндов из JSONB колонок, NULL остается nil
+func unmarshalMatrices(expr *models.SubExpression, val1, val2, args []byte) error {
+	for _, column := range []struct {
+		data []byte
+		dst  any
+	}{{val1, &expr.Val1Matrix}, {val2, &expr.Val2Matrix}, {args, &expr.ArgsMatrix}} {
+		if len(column.data) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(column.data, column.dst); err != nil {
+			return fmt.Errorf("unmarshal matrix failure %e", err)
+		}
 	}
-	_, err = r.db.ExecContext(ctx, "UPDATE sub_expressions SET sub_expression_id2=$1 WHERE sub_expression_id2=$2",
-		newId.String(), oldId.String())
+	return nil
+}
+
+func (r *PostgresRepository) GetAttempts(ctx context.Context, expressionId uuid.UUID) ([]*models.SubExpressionAttempt, error) {
+	const op = "repositories.postgres.GetAttempts"
+
+	rows, err := r.db.QueryContext(ctx, `SELECT sub_expression_id, expression_id, attempt, agent_id, started_at, finished_at, COALESCE(outcome, '')
+FROM sub_expression_attempts WHERE expression_id = $1 ORDER BY started_at, attempt`,
+		expressionId)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
-	return nil
+	defer rows.Close()
+
+	var attempts []*models.SubExpressionAttempt
+	for rows.Next() {
+		var attempt models.SubExpressionAttempt
+		if err := rows.Scan(&attempt.SubExpressionId, &attempt.ExpressionId, &attempt.Attempt, &attempt.AgentId,
+			&attempt.StartedAt, &attempt.FinishedAt, &attempt.Outcome); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, rows.Err()
 }
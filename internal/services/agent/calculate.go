@@ -2,6 +2,7 @@ package agent
 
 import (
 	"errors"
+	"math"
 	"myproject/internal/config"
 	"myproject/internal/models"
 	"time"
@@ -38,8 +39,139 @@ func Calculate(expression *models.SubExpression, timeouts config.CalculationTime
 		}
 		<-time.After(timeouts.TimeCalculateDivide)
 		return expression.Val1 / expression.Val2, nil
+	case "%":
+		if expression.Val2 == 0 {
+			return 0, errors.New("cannot divide by zero")
+		}
+		<-time.After(timeouts.TimeCalculateMod)
+		return floorMod(expression.Val1, expression.Val2), nil
+	case "//":
+		if expression.Val2 == 0 {
+			return 0, errors.New("cannot divide by zero")
+		}
+		<-time.After(timeouts.TimeCalculateIntDivide)
+		return math.Floor(expression.Val1 / expression.Val2), nil
+	case "^":
+		if expression.Val1 == 0 && expression.Val2 < 0 {
+			return 0, errors.New("cannot raise zero to a negative power")
+		}
+		result := math.Pow(expression.Val1, expression.Val2)
+		if math.IsNaN(result) {
+			return 0, errors.New("power of a negative number to a fractional exponent is not a real number")
+		}
+		<-time.After(timeouts.TimeCalculatePow)
+		return result, nil
+	case "sqrt":
+		if expression.Args[0] < 0 {
+			return 0, errors.New("cannot take square root of a negative number")
+		}
+		<-time.After(timeouts.TimeCalculateSqrt)
+		return math.Sqrt(expression.Args[0]), nil
+	case "abs":
+		<-time.After(timeouts.TimeCalculateAbs)
+		return math.Abs(expression.Args[0]), nil
+	case "min":
+		<-time.After(timeouts.TimeCalculateMin)
+		ans = expression.Args[0]
+		for _, arg := range expression.Args[1:] {
+			ans = math.Min(ans, arg)
+		}
+		return ans, nil
+	case "max":
+		<-time.After(timeouts.TimeCalculateMax)
+		ans = expression.Args[0]
+		for _, arg := range expression.Args[1:] {
+			ans = math.Max(ans, arg)
+		}
+		return ans, nil
+	case "round":
+		// round(x) округляет до целого, round(x, n) - до n знаков после запятой (половины округляются от нуля)
+		scale := 1.0
+		if len(expression.Args) > 1 {
+			digits := expression.Args[1]
+			if digits != math.Trunc(digits) {
+				return 0, errors.New("number of digits to round to must be an integer")
+			}
+			scale = math.Pow(10, digits)
+		}
+		<-time.After(timeouts.TimeCalculateRound)
+		return math.Round(expression.Args[0]*scale) / scale, nil
+	case "log":
+		// log(x) натуральный логарифм, log(x, base) - логарифм по основанию base
+		if expression.Args[0] <= 0 {
+			return 0, errors.New("cannot take logarithm of a non-positive number")
+		}
+		ans = math.Log(expression.Args[0])
+		if len(expression.Args) > 1 {
+			base := expression.Args[1]
+			if base <= 0 || base == 1 {
+				return 0, errors.New("logarithm base must be positive and not equal to one")
+			}
+			ans /= math.Log(base)
+		}
+		<-time.After(timeouts.TimeCalculateLog)
+		return ans, nil
+	case "sin":
+		<-time.After(timeouts.TimeCalculateSin)
+		return math.Sin(expression.Args[0]), nil
+	case "cos":
+		<-time.After(timeouts.TimeCalculateCos)
+		return math.Cos(expression.Args[0]), nil
+	case "<", "<=", ">", ">=", "==", "!=":
+		<-time.After(timeouts.TimeCalculateComparison)
+		return compare(expression.Action, expression.Val1, expression.Val2), nil
+	case "not":
+		<-time.After(timeouts.TimeCalculateLogical)
+		return boolToFloat(expression.Val1 == 0), nil
+	case "if":
+		// Args: [условие, ветка true, ветка false], невыбранная ветка не считалась и равна 0
+		<-time.After(timeouts.TimeCalculateIf)
+		if expression.Args[0] != 0 {
+			return expression.Args[1], nil
+		}
+		return expression.Args[2], nil
+	case "neg":
+		<-time.After(timeouts.TimeCalculateMinus)
+		return -expression.Val1, nil
+	case "pos":
+		<-time.After(timeouts.TimeCalculatePlus)
+		return expression.Val1, nil
 	default:
 		err = errors.New("not allowed action")
 		return 0, err
 	}
 }
+
+// floorMod остаток от деления с округлением частного вниз: знак остатка совпадает со знаком делителя,
+// так что a == b*floor(a/b) + floorMod(a, b). Работает и для дробных чисел: 5.5 % 2 = 1.5, -7 % 3 = 2, 7 % -3 = -2
+func floorMod(a, b float64) float64 {
+	mod := math.Mod(a, b)
+	if mod != 0 && (mod < 0) != (b < 0) {
+		mod += b
+	}
+	return mod
+}
+
+// compare сравнивает a и b, результат 1 (истина) или 0 (ложь)
+func compare(action string, a, b float64) float64 {
+	switch action {
+	case "<":
+		return boolToFloat(a < b)
+	case "<=":
+		return boolToFloat(a <= b)
+	case ">":
+		return boolToFloat(a > b)
+	case ">=":
+		return boolToFloat(a >= b)
+	case "==":
+		return boolToFloat(a == b)
+	}
+	return boolToFloat(a != b)
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"time"
+)
+
+// CalculateComplex считает subexpression в комплексных числах (models.ModeComplex) с паузой из config.
+// Действительные части операндов берутся из Val1, Val2 и Args, мнимые - из Val1Im, Val2Im и ArgsIm
+func CalculateComplex(expression *models.SubExpression, timeouts config.CalculationTimeoutsConfig) (re, im float64, err error) {
+	result, err := calculateComplex(expression)
+	if err != nil {
+		return 0, 0, err
+	}
+	<-time.After(actionTimeout(expression.Action, timeouts))
+	return real(result), imag(result), nil
+}
+
+func calculateComplex(expression *models.SubExpression) (complex128, error) {
+	val1 := complex(expression.Val1, expression.Val1Im)
+	val2 := complex(expression.Val2, expression.Val2Im)
+	switch expression.Action {
+	case "+":
+		return val1 + val2, nil
+	case "-":
+		return val1 - val2, nil
+	case "*":
+		return val1 * val2, nil
+	case "/":
+		if val2 == 0 {
+			return 0, errors.New("cannot divide by zero")
+		}
+		return val1 / val2, nil
+	case "^":
+		return powComplex(val1, val2)
+	case "==":
+		return boolToComplex(val1 == val2), nil
+	case "!=":
+		return boolToComplex(val1 != val2), nil
+	case "neg":
+		return -val1, nil
+	case "pos":
+		return val1, nil
+	case "not":
+		return boolToComplex(val1 == 0), nil
+	case "sqrt", "abs", "log", "sin", "cos", "if":
+		if len(expression.Args) == 0 {
+			return 0, errors.New("function called without arguments")
+		}
+		args := make([]complex128, len(expression.Args))
+		for i, arg := range expression.Args {
+			var argIm float64
+			if i < len(expression.ArgsIm) {
+				argIm = expression.ArgsIm[i]
+			}
+			args[i] = complex(arg, argIm)
+		}
+		return functionComplex(expression.Action, args)
+	default:
+		return 0, errors.New("action is not supported in complex mode")
+	}
+}
+
+func functionComplex(action string, args []complex128) (complex128, error) {
+	switch action {
+	case "if":
+		if len(args) != 3 {
+			return 0, errors.New("if expects condition and two branches")
+		}
+		if args[0] != 0 {
+			return args[1], nil
+		}
+		return args[2], nil
+	case "sqrt":
+		// в отличие от Calculate корень из отрицательного числа определен: sqrt(-1) = i
+		return cmplx.Sqrt(args[0]), nil
+	case "abs":
+		return complex(cmplx.Abs(args[0]), 0), nil
+	case "log":
+		// главное значение логарифма: log(-1) = πi
+		if args[0] == 0 {
+			return 0, errors.New("cannot take logarithm of zero")
+		}
+		ans := cmplx.Log(args[0])
+		if len(args) > 1 {
+			if args[1] == 0 || args[1] == 1 {
+				return 0, errors.New("logarithm base must not be equal to zero or one")
+			}
+			ans /= cmplx.Log(args[1])
+		}
+		return ans, nil
+	case "sin":
+		return cmplx.Sin(args[0]), nil
+	case "cos":
+		return cmplx.Cos(args[0]), nil
+	}
+	return 0, errors.New("not allowed action")
+}
+
+// maxComplexIntExponent максимальный модуль целого показателя, который возводится в степень умножением:
+// так (3+4i)^2 = -7+24i считается без погрешности cmplx.Pow
+const maxComplexIntExponent = 1 << 20
+
+func powComplex(base, exponent complex128) (complex128, error) {
+	if base == 0 && real(exponent) < 0 {
+		return 0, errors.New("cannot raise zero to a negative power")
+	}
+	n := real(exponent)
+	if imag(exponent) != 0 || n != math.Trunc(n) || math.Abs(n) > maxComplexIntExponent {
+		return cmplx.Pow(base, exponent), nil
+	}
+	// быстрое возведение в целую степень
+	result := complex(1, 0)
+	power := int(math.Abs(n))
+	for square := base; power > 0; power >>= 1 {
+		if power&1 == 1 {
+			result *= square
+		}
+		square *= square
+	}
+	if n < 0 {
+		return 1 / result, nil
+	}
+	return result, nil
+}
+
+func boolToComplex(value bool) complex128 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"math"
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"testing"
+)
+
+func TestCalculateComplex(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression *models.SubExpression
+		wantRe     float64
+		wantIm     float64
+		wantErr    bool
+	}{
+		{
+			name:       "(3+4i)+(1-2i)",
+			expression: &models.SubExpression{Action: "+", Val1: 3, Val1Im: 4, Val2: 1, Val2Im: -2},
+			wantRe:     4,
+			wantIm:     2,
+		},
+		{
+			name:       "(3+4i)*(1-2i)",
+			expression: &models.SubExpression{Action: "*", Val1: 3, Val1Im: 4, Val2: 1, Val2Im: -2},
+			wantRe:     11,
+			wantIm:     -2,
+		},
+		{
+			name:       "(11-2i)/(1-2i)",
+			expression: &models.SubExpression{Action: "/", Val1: 11, Val1Im: -2, Val2: 1, Val2Im: -2},
+			wantRe:     3,
+			wantIm:     4,
+		},
+		{
+			name:       "1/0i",
+			expression: &models.SubExpression{Action: "/", Val1: 1},
+			wantErr:    true,
+		},
+		{
+			name:       "(3+4i)^2 без погрешности",
+			expression: &models.SubExpression{Action: "^", Val1: 3, Val1Im: 4, Val2: 2},
+			wantRe:     -7,
+			wantIm:     24,
+		},
+		{
+			name:       "i^-1",
+			expression: &models.SubExpression{Action: "^", Val1Im: 1, Val2: -1},
+			wantIm:     -1,
+		},
+		{
+			name:       "0^-1",
+			expression: &models.SubExpression{Action: "^", Val2: -1},
+			wantErr:    true,
+		},
+		{
+			name:       "sqrt(-1)",
+			expression: &models.SubExpression{Action: "sqrt", Args: []float64{-1}, ArgsIm: []float64{0}},
+			wantIm:     1,
+		},
+		{
+			name:       "abs(3+4i)",
+			expression: &models.SubExpression{Action: "abs", Args: []float64{3}, ArgsIm: []float64{4}},
+			wantRe:     5,
+		},
+		{
+			name:       "log(-1)",
+			expression: &models.SubExpression{Action: "log", Args: []float64{-1}, ArgsIm: []float64{0}},
+			wantIm:     math.Pi,
+		},
+		{
+			name:       "log(0)",
+			expression: &models.SubExpression{Action: "log", Args: []float64{0}, ArgsIm: []float64{0}},
+			wantErr:    true,
+		},
+		{
+			name:       "if(i, 1, 2)",
+			expression: &models.SubExpression{Action: "if", Args: []float64{0, 1, 2}, ArgsIm: []float64{1, 0, 0}},
+			wantRe:     1,
+		},
+		{
+			name:       "(1+i) == (1+i)",
+			expression: &models.SubExpression{Action: "==", Val1: 1, Val1Im: 1, Val2: 1, Val2Im: 1},
+			wantRe:     1,
+		},
+		{
+			name:       "сравнение < не поддерживается",
+			expression: &models.SubExpression{Action: "<", Val1: 1, Val2: 2},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRe, gotIm, err := CalculateComplex(tt.expression, config.CalculationTimeoutsConfig{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CalculateComplex() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if math.Abs(gotRe-tt.wantRe) > 1e-12 || math.Abs(gotIm-tt.wantIm) > 1e-12 {
+				t.Errorf("CalculateComplex() got = %v%+vi, want %v%+vi", gotRe, gotIm, tt.wantRe, tt.wantIm)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"errors"
+	"math/big"
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"time"
+)
+
+// exactDivisionScale количество знаков после запятой, до которого округляется результат в точном режиме,
+// если он не представим конечной десятичной дробью (например 1/3). Остальные результаты не округляются
+const exactDivisionScale = 50
+
+// maxExactExponent максимальный модуль показателя степени в точном режиме, чтобы 10^1000000 не занимал всю память
+const maxExactExponent = 10000
+
+// CalculateExact считает subexpression в точном режиме (models.ModeExact) с паузой из config.
+// Возвращает точный результат десятичной строкой и его приближение float64
+func CalculateExact(expression *models.SubExpression, timeouts config.CalculationTimeoutsConfig) (ans string, approx float64, err error) {
+	result, err := calculateRat(expression)
+	if err != nil {
+		return "", 0, err
+	}
+	<-time.After(actionTimeout(expression.Action, timeouts))
+	approx, _ = result.Float64()
+	return formatRat(result), approx, nil
+}
+
+func calculateRat(expression *models.SubExpression) (*big.Rat, error) {
+	switch expression.Action {
+	case "+", "-", "*", "/", "%", "//", "^", "<", "<=", ">", ">=", "==", "!=":
+		val1, err := parseRat(expression.Val1Exact)
+		if err != nil {
+			return nil, err
+		}
+		val2, err := parseRat(expression.Val2Exact)
+		if err != nil {
+			return nil, err
+		}
+		return binaryRat(expression.Action, val1, val2)
+	case "neg", "pos", "not":
+		val1, err := parseRat(expression.Val1Exact)
+		if err != nil {
+			return nil, err
+		}
+		switch expression.Action {
+		case "neg":
+			return val1.Neg(val1), nil
+		case "not":
+			return boolToRat(val1.Sign() == 0), nil
+		}
+		return val1, nil
+	case "abs", "min", "max", "round", "if":
+		args := make([]*big.Rat, len(expression.ArgsExact))
+		for i, arg := range expression.ArgsExact {
+			value, err := parseRat(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		if len(args) == 0 {
+			return nil, errors.New("function called without arguments")
+		}
+		return functionRat(expression.Action, args)
+	default:
+		return nil, errors.New("action is not supported in exact mode")
+	}
+}
+
+func binaryRat(action string, val1, val2 *big.Rat) (*big.Rat, error) {
+	result := new(big.Rat)
+	switch action {
+	case "+":
+		return result.Add(val1, val2), nil
+	case "-":
+		return result.Sub(val1, val2), nil
+	case "*":
+		return result.Mul(val1, val2), nil
+	case "/":
+		if val2.Sign() == 0 {
+			return nil, errors.New("cannot divide by zero")
+		}
+		return result.Quo(val1, val2), nil
+	case "%":
+		// a % b = a - b*floor(a/b), знак остатка совпадает со знаком делителя, как и в Calculate
+		if val2.Sign() == 0 {
+			return nil, errors.New("cannot divide by zero")
+		}
+		quotient := floorRat(new(big.Rat).Quo(val1, val2))
+		return result.Sub(val1, quotient.Mul(quotient, val2)), nil
+	case "//":
+		if val2.Sign() == 0 {
+			return nil, errors.New("cannot divide by zero")
+		}
+		return floorRat(result.Quo(val1, val2)), nil
+	case "<", "<=", ">", ">=", "==", "!=":
+		// сравнение точных значений, а не их приближений: 0.1 + 0.2 == 0.3 истинно
+		cmp := val1.Cmp(val2)
+		return boolToRat(action == "<" && cmp < 0 || action == "<=" && cmp <= 0 ||
+			action == ">" && cmp > 0 || action == ">=" && cmp >= 0 ||
+			action == "==" && cmp == 0 || action == "!=" && cmp != 0), nil
+	case "^":
+		if !val2.IsInt() {
+			return nil, errors.New("exact mode supports only integer exponents")
+		}
+		exponent := val2.Num()
+		if exponent.CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+			return nil, errors.New("exponent is too large for exact mode")
+		}
+		if val1.Sign() == 0 && exponent.Sign() < 0 {
+			return nil, errors.New("cannot raise zero to a negative power")
+		}
+		n := new(big.Int).Abs(exponent)
+		result.SetFrac(new(big.Int).Exp(val1.Num(), n, nil), new(big.Int).Exp(val1.Denom(), n, nil))
+		if exponent.Sign() < 0 {
+			result.Inv(result)
+		}
+		return result, nil
+	}
+	return nil, errors.New("not allowed action")
+}
+
+func functionRat(action string, args []*big.Rat) (*big.Rat, error) {
+	switch action {
+	case "if":
+		if len(args) != 3 {
+			return nil, errors.New("if expects condition and two branches")
+		}
+		if args[0].Sign() != 0 {
+			return args[1], nil
+		}
+		return args[2], nil
+	case "abs":
+		return new(big.Rat).Abs(args[0]), nil
+	case "min", "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if cmp := arg.Cmp(result); action == "min" && cmp < 0 || action == "max" && cmp > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	case "round":
+		// как и в Calculate, половины округляются от нуля: round(2.5) = 3, round(-2.5) = -3
+		scale := big.NewRat(1, 1)
+		if len(args) > 1 {
+			if !args[1].IsInt() {
+				return nil, errors.New("number of digits to round to must be an integer")
+			}
+			digits := args[1].Num()
+			if digits.CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+				return nil, errors.New("number of digits to round to is too large for exact mode")
+			}
+			power := new(big.Int).Exp(big.NewInt(10), new(big.Int).Abs(digits), nil)
+			scale.SetInt(power)
+			if digits.Sign() < 0 {
+				scale.Inv(scale)
+			}
+		}
+		scaled := new(big.Rat).Mul(new(big.Rat).Abs(args[0]), scale)
+		rounded := floorRat(scaled.Add(scaled, big.NewRat(1, 2)))
+		if args[0].Sign() < 0 {
+			rounded.Neg(rounded)
+		}
+		return rounded.Quo(rounded, scale), nil
+	}
+	return nil, errors.New("not allowed action")
+}
+
+func boolToRat(value bool) *big.Rat {
+	if value {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat)
+}
+
+// floorRat округляет r вниз до целого, r изменяется
+func floorRat(r *big.Rat) *big.Rat {
+	// знаменатель Rat всегда положителен, поэтому евклидово деление Int.Div совпадает с округлением вниз
+	return r.SetInt(new(big.Int).Div(r.Num(), r.Denom()))
+}
+
+func parseRat(value string) (*big.Rat, error) {
+	result, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, errors.New("invalid exact value " + value)
+	}
+	return result, nil
+}
+
+// formatRat записывает r десятичной дробью: без округления, если знаменатель содержит только множители 2 и 5,
+// иначе с округлением до exactDivisionScale знаков
+func formatRat(r *big.Rat) string {
+	denom := new(big.Int).Set(r.Denom())
+	twos := removeFactor(denom, 2)
+	fives := removeFactor(denom, 5)
+	if denom.Cmp(big.NewInt(1)) != 0 {
+		return r.FloatString(exactDivisionScale)
+	}
+	// 1/(2^a * 5^b) имеет ровно max(a, b) знаков после запятой
+	return r.FloatString(max(twos, fives))
+}
+
+// removeFactor делит n на factor, пока делится, и возвращает количество делений
+func removeFactor(n *big.Int, factor int64) int {
+	count := 0
+	f := big.NewInt(factor)
+	quotient, remainder := new(big.Int), new(big.Int)
+	for {
+		quotient.QuoRem(n, f, remainder)
+		if remainder.Sign() != 0 {
+			return count
+		}
+		n.Set(quotient)
+		count++
+	}
+}
+
+// actionTimeout пауза из config для операции action
+func actionTimeout(action string, timeouts config.CalculationTimeoutsConfig) time.Duration {
+	switch action {
+	case "+", "pos":
+		return timeouts.TimeCalculatePlus
+	case "-", "neg":
+		return timeouts.TimeCalculateMinus
+	case "*":
+		return timeouts.TimeCalculateMult
+	case "/":
+		return timeouts.TimeCalculateDivide
+	case "%":
+		return timeouts.TimeCalculateMod
+	case "//":
+		return timeouts.TimeCalculateIntDivide
+	case "^":
+		return timeouts.TimeCalculatePow
+	case "abs":
+		return timeouts.TimeCalculateAbs
+	case "min":
+		return timeouts.TimeCalculateMin
+	case "max":
+		return timeouts.TimeCalculateMax
+	case "round":
+		return timeouts.TimeCalculateRound
+	case "sqrt":
+		return timeouts.TimeCalculateSqrt
+	case "log":
+		return timeouts.TimeCalculateLog
+	case "sin":
+		return timeouts.TimeCalculateSin
+	case "cos":
+		return timeouts.TimeCalculateCos
+	case "<", "<=", ">", ">=", "==", "!=":
+		return timeouts.TimeCalculateComparison
+	case "not":
+		return timeouts.TimeCalculateLogical
+	case "if":
+		return timeouts.TimeCalculateIf
+	case "dot", "matmul", "transpose", "blocks", "slice":
+		return timeouts.TimeCalculateMatrix
+	}
+	return 0
+}
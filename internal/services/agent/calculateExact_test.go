@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"testing"
+)
+
+func TestCalculateExact(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression *models.SubExpression
+		wantAns    string
+		wantErr    bool
+	}{
+		{
+			name:       "0.1+0.2",
+			expression: &models.SubExpression{Action: "+", Val1Exact: "0.1", Val2Exact: "0.2"},
+			wantAns:    "0.3",
+		},
+		{
+			name:       "большое произведение",
+			expression: &models.SubExpression{Action: "*", Val1Exact: "12345678901234567890", Val2Exact: "98765432109876543210"},
+			wantAns:    "1219326311370217952237463801111263526900",
+		},
+		{
+			name:       "1/8",
+			expression: &models.SubExpression{Action: "/", Val1Exact: "1", Val2Exact: "8"},
+			wantAns:    "0.125",
+		},
+		{
+			name:       "1/3 округляется до exactDivisionScale знаков",
+			expression: &models.SubExpression{Action: "/", Val1Exact: "1", Val2Exact: "3"},
+			wantAns:    "0.33333333333333333333333333333333333333333333333333",
+		},
+		{
+			name:       "1/0",
+			expression: &models.SubExpression{Action: "/", Val1Exact: "1", Val2Exact: "0"},
+			wantErr:    true,
+		},
+		{
+			name:       "-7.5 % 2",
+			expression: &models.SubExpression{Action: "%", Val1Exact: "-7.5", Val2Exact: "2"},
+			wantAns:    "0.5",
+		},
+		{
+			name:       "-7 // 2",
+			expression: &models.SubExpression{Action: "//", Val1Exact: "-7", Val2Exact: "2"},
+			wantAns:    "-4",
+		},
+		{
+			name:       "0.1^3",
+			expression: &models.SubExpression{Action: "^", Val1Exact: "0.1", Val2Exact: "3"},
+			wantAns:    "0.001",
+		},
+		{
+			name:       "2^-2",
+			expression: &models.SubExpression{Action: "^", Val1Exact: "2", Val2Exact: "-2"},
+			wantAns:    "0.25",
+		},
+		{
+			name:       "2^0.5",
+			expression: &models.SubExpression{Action: "^", Val1Exact: "2", Val2Exact: "0.5"},
+			wantErr:    true,
+		},
+		{
+			name:       "neg",
+			expression: &models.SubExpression{Action: "neg", Val1Exact: "1e-3"},
+			wantAns:    "-0.001",
+		},
+		{
+			name:       "max(0.1, 0.30, 0.2)",
+			expression: &models.SubExpression{Action: "max", ArgsExact: []string{"0.1", "0.30", "0.2"}},
+			wantAns:    "0.3",
+		},
+		{
+			name:       "round(-2.345, 2)",
+			expression: &models.SubExpression{Action: "round", ArgsExact: []string{"-2.345", "2"}},
+			wantAns:    "-2.35",
+		},
+		{
+			name:       "round(1250, -2)",
+			expression: &models.SubExpression{Action: "round", ArgsExact: []string{"1250", "-2"}},
+			wantAns:    "1300",
+		},
+		{
+			name:       "0.30 == 0.3",
+			expression: &models.SubExpression{Action: "==", Val1Exact: "0.30", Val2Exact: "0.3"},
+			wantAns:    "1",
+		},
+		{
+			name:       "!0.0",
+			expression: &models.SubExpression{Action: "not", Val1Exact: "0.0"},
+			wantAns:    "1",
+		},
+		{
+			name:       "if(1, 0.5, 0)",
+			expression: &models.SubExpression{Action: "if", ArgsExact: []string{"1", "0.5", "0"}},
+			wantAns:    "0.5",
+		},
+		{
+			name:       "sqrt не поддерживается",
+			expression: &models.SubExpression{Action: "sqrt", ArgsExact: []string{"4"}},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// паузы из config проверяются в TestCalculate, здесь они не нужны
+			gotAns, _, err := CalculateExact(tt.expression, config.CalculationTimeoutsConfig{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CalculateExact() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotAns != tt.wantAns {
+				t.Errorf("CalculateExact() gotAns = %v, want %v", gotAns, tt.wantAns)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"errors"
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"time"
+)
+
+// hasMatrixOperands проверяет, что среди операндов subexpression есть векторы или матрицы
+func hasMatrixOperands(expression *models.SubExpression) bool {
+	if expression.Val1Matrix != nil || expression.Val2Matrix != nil {
+		return true
+	}
+	for _, arg := range expression.ArgsMatrix {
+		if arg != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CalculateMatrix считает subexpression с векторами и матрицами с паузой из config.
+// Возвращает результат-матрицу, а для dot - число (матрица в этом случае nil)
+func CalculateMatrix(expression *models.SubExpression, timeouts config.CalculationTimeoutsConfig) (*models.Matrix, float64, error) {
+	var result *models.Matrix
+	var err error
+	switch expression.Action {
+	case "+", "-", "*", "/":
+		result, err = elementWise(expression)
+	case "neg", "pos":
+		result, err = elementWise(&models.SubExpression{Action: expression.Action, Val1Matrix: expression.Val1Matrix})
+	case "dot":
+		u, v, err := matrixArgs(expression, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(u.Data) != len(v.Data) {
+			return nil, 0, errors.New("vectors must have the same length")
+		}
+		var ans float64
+		for i := range u.Data {
+			ans += u.Data[i] * v.Data[i]
+		}
+		<-time.After(timeouts.TimeCalculateMatrix)
+		return nil, ans, nil
+	case "matmul":
+		result, err = matmul(expression)
+	case "transpose":
+		a, _, err := matrixArgs(expression, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		result = &models.Matrix{Rows: a.Cols, Cols: a.Rows, Data: make([]float64, len(a.Data))}
+		for i := 0; i < a.Rows; i++ {
+			for j := 0; j < a.Cols; j++ {
+				result.Data[j*a.Rows+i] = a.At(i, j)
+			}
+		}
+	case "blocks":
+		result, err = joinBlocks(expression)
+	case "slice":
+		result, err = slice(expression)
+	default:
+		return nil, 0, errors.New("action is not supported for matrices")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	<-time.After(actionTimeout(expression.Action, timeouts))
+	return result, 0, nil
+}
+
+// elementWise применяет операцию к каждому элементу, число применяется ко всем элементам матрицы: [1, 2] * 2 = [2, 4]
+func elementWise(expression *models.SubExpression) (*models.Matrix, error) {
+	a, b := expression.Val1Matrix, expression.Val2Matrix
+	shape := a
+	if shape == nil {
+		shape = b
+	}
+	if shape == nil {
+		return nil, errors.New("operation expects a matrix operand")
+	}
+	if a != nil && b != nil && (a.Rows != b.Rows || a.Cols != b.Cols) {
+		return nil, errors.New("matrix sizes do not match")
+	}
+	result := &models.Matrix{Rows: shape.Rows, Cols: shape.Cols, Data: make([]float64, len(shape.Data))}
+	for i := range result.Data {
+		val1, val2 := expression.Val1, expression.Val2
+		if a != nil {
+			val1 = a.Data[i]
+		}
+		if b != nil {
+			val2 = b.Data[i]
+		}
+		switch expression.Action {
+		case "+":
+			result.Data[i] = val1 + val2
+		case "-":
+			result.Data[i] = val1 - val2
+		case "*":
+			result.Data[i] = val1 * val2
+		case "/":
+			if val2 == 0 {
+				return nil, errors.New("cannot divide by zero")
+			}
+			result.Data[i] = val1 / val2
+		case "neg":
+			result.Data[i] = -val1
+		case "pos":
+			result.Data[i] = val1
+		}
+	}
+	return result, nil
+}
+
+// matmul считает произведение матриц matmul(a, b) или его блок matmul(a, b, строка от, строка до, столбец от, столбец до)
+func matmul(expression *models.SubExpression) (*models.Matrix, error) {
+	a, b, err := matrixArgs(expression, 2)
+	if err != nil {
+		return nil, err
+	}
+	if a.Cols != b.Rows {
+		return nil, errors.New("matrix sizes do not match")
+	}
+	rowFrom, rowTo, colFrom, colTo := 0, a.Rows, 0, b.Cols
+	if len(expression.Args) == 6 {
+		rowFrom, rowTo = int(expression.Args[2]), int(expression.Args[3])
+		colFrom, colTo = int(expression.Args[4]), int(expression.Args[5])
+		if rowFrom < 0 || rowFrom >= rowTo || rowTo > a.Rows || colFrom < 0 || colFrom >= colTo || colTo > b.Cols {
+			return nil, errors.New("matrix block is out of range")
+		}
+	}
+	result := &models.Matrix{Rows: rowTo - rowFrom, Cols: colTo - colFrom, Data: make([]float64, 0, (rowTo-rowFrom)*(colTo-colFrom))}
+	for i := rowFrom; i < rowTo; i++ {
+		for j := colFrom; j < colTo; j++ {
+			var sum float64
+			for k := 0; k < a.Cols; k++ {
+				sum += a.At(i, k) * b.At(k, j)
+			}
+			result.Data = append(result.Data, sum)
+		}
+	}
+	return result, nil
+}
+
+// slice вырезает часть матрицы slice(матрица, строка от, строка до, столбец от, столбец до)
+func slice(expression *models.SubExpression) (*models.Matrix, error) {
+	a, _, err := matrixArgs(expression, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(expression.Args) != 5 {
+		return nil, errors.New("slice expects matrix and bounds")
+	}
+	rowFrom, rowTo := int(expression.Args[1]), int(expression.Args[2])
+	colFrom, colTo := int(expression.Args[3]), int(expression.Args[4])
+	if rowFrom < 0 || rowFrom >= rowTo || rowTo > a.Rows || colFrom < 0 || colFrom >= colTo || colTo > a.Cols {
+		return nil, errors.New("matrix slice is out of range")
+	}
+	return a.Slice(rowFrom, rowTo, colFrom, colTo), nil
+}
+
+// joinBlocks собирает матрицу из блоков blocks(число блоков по строкам, блоки построчно...)
+func joinBlocks(expression *models.SubExpression) (*models.Matrix, error) {
+	if len(expression.Args) < 2 || len(expression.ArgsMatrix) != len(expression.Args) {
+		return nil, errors.New("blocks expects number of block rows and blocks")
+	}
+	blocks := expression.ArgsMatrix[1:]
+	blockRows := int(expression.Args[0])
+	if blockRows <= 0 || len(blocks)%blockRows != 0 {
+		return nil, errors.New("invalid number of block rows")
+	}
+	blockCols := len(blocks) / blockRows
+	result := &models.Matrix{}
+	for _, block := range blocks[:blockCols] {
+		if block == nil {
+			return nil, errors.New("matrix block is not calculated")
+		}
+		result.Cols += block.Cols
+	}
+	for r := 0; r < blockRows; r++ {
+		row := blocks[r*blockCols : (r+1)*blockCols]
+		for _, block := range row {
+			if block == nil || block.Rows != row[0].Rows {
+				return nil, errors.New("matrix blocks do not match")
+			}
+		}
+		for i := 0; i < row[0].Rows; i++ {
+			for _, block := range row {
+				result.Data = append(result.Data, block.Data[i*block.Cols:(i+1)*block.Cols]...)
+			}
+		}
+		result.Rows += row[0].Rows
+	}
+	if len(result.Data) != result.Rows*result.Cols {
+		return nil, errors.New("matrix blocks do not match")
+	}
+	return result, nil
+}
+
+// matrixArgs возвращает первые count аргументов-матриц функции (второй результат nil при count = 1)
+func matrixArgs(expression *models.SubExpression, count int) (*models.Matrix, *models.Matrix, error) {
+	if len(expression.ArgsMatrix) < count {
+		return nil, nil, errors.New("function expects matrix arguments")
+	}
+	for _, arg := range expression.ArgsMatrix[:count] {
+		if arg == nil {
+			return nil, nil, errors.New("function expects matrix arguments")
+		}
+	}
+	if count == 1 {
+		return expression.ArgsMatrix[0], nil, nil
+	}
+	return expression.ArgsMatrix[0], expression.ArgsMatrix[1], nil
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestCalculateMatrix(t *testing.T) {
+	a := &models.Matrix{Rows: 2, Cols: 3, Data: []float64{1, 2, 3, 4, 5, 6}}
+	b := &models.Matrix{Rows: 3, Cols: 2, Data: []float64{7, 8, 9, 10, 11, 12}}
+	tests := []struct {
+		name       string
+		expression *models.SubExpression
+		wantMatrix *models.Matrix
+		wantAns    float64
+		wantErr    bool
+	}{
+		{
+			name:       "[1, 2] + [3, 4]",
+			expression: &models.SubExpression{Action: "+", Val1Matrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{1, 2}}, Val2Matrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{3, 4}}},
+			wantMatrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{4, 6}},
+		},
+		{
+			name:       "10 / [2, 5]",
+			expression: &models.SubExpression{Action: "/", Val1: 10, Val2Matrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{2, 5}}},
+			wantMatrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{5, 2}},
+		},
+		{
+			name:       "[1, 2] / 0",
+			expression: &models.SubExpression{Action: "/", Val1Matrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{1, 2}}},
+			wantErr:    true,
+		},
+		{
+			name:       "-[1, 2]",
+			expression: &models.SubExpression{Action: "neg", Val1Matrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{1, 2}}},
+			wantMatrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{-1, -2}},
+		},
+		{
+			name:       "dot([1, 2, 3], [4, 5, 6])",
+			expression: &models.SubExpression{Action: "dot", Args: []float64{0, 0}, ArgsMatrix: []*models.Matrix{{Rows: 1, Cols: 3, Data: []float64{1, 2, 3}}, {Rows: 1, Cols: 3, Data: []float64{4, 5, 6}}}},
+			wantAns:    32,
+		},
+		{
+			name:       "matmul",
+			expression: &models.SubExpression{Action: "matmul", Args: []float64{0, 0}, ArgsMatrix: []*models.Matrix{a, b}},
+			wantMatrix: &models.Matrix{Rows: 2, Cols: 2, Data: []float64{58, 64, 139, 154}},
+		},
+		{
+			name:       "блок matmul: вторая строка, первый столбец",
+			expression: &models.SubExpression{Action: "matmul", Args: []float64{0, 0, 1, 2, 0, 1}, ArgsMatrix: []*models.Matrix{a, b, nil, nil, nil, nil}},
+			wantMatrix: &models.Matrix{Rows: 1, Cols: 1, Data: []float64{139}},
+		},
+		{
+			name:       "блок matmul за пределами матрицы",
+			expression: &models.SubExpression{Action: "matmul", Args: []float64{0, 0, 1, 3, 0, 1}, ArgsMatrix: []*models.Matrix{a, b, nil, nil, nil, nil}},
+			wantErr:    true,
+		},
+		{
+			name:       "slice: вторая строка, столбцы со второго",
+			expression: &models.SubExpression{Action: "slice", Args: []float64{0, 1, 2, 1, 3}, ArgsMatrix: []*models.Matrix{a, nil, nil, nil, nil}},
+			wantMatrix: &models.Matrix{Rows: 1, Cols: 2, Data: []float64{5, 6}},
+		},
+		{
+			name:       "slice за пределами матрицы",
+			expression: &models.SubExpression{Action: "slice", Args: []float64{0, 0, 3, 0, 1}, ArgsMatrix: []*models.Matrix{a, nil, nil, nil, nil}},
+			wantErr:    true,
+		},
+		{
+			name: "сборка из блоков 2x2",
+			expression: &models.SubExpression{Action: "blocks", Args: []float64{2, 0, 0, 0, 0}, ArgsMatrix: []*models.Matrix{nil,
+				{Rows: 1, Cols: 1, Data: []float64{58}}, {Rows: 1, Cols: 1, Data: []float64{64}},
+				{Rows: 1, Cols: 1, Data: []float64{139}}, {Rows: 1, Cols: 1, Data: []float64{154}}}},
+			wantMatrix: &models.Matrix{Rows: 2, Cols: 2, Data: []float64{58, 64, 139, 154}},
+		},
+		{
+			name:       "transpose",
+			expression: &models.SubExpression{Action: "transpose", Args: []float64{0}, ArgsMatrix: []*models.Matrix{a}},
+			wantMatrix: &models.Matrix{Rows: 3, Cols: 2, Data: []float64{1, 4, 2, 5, 3, 6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMatrix, gotAns, err := CalculateMatrix(tt.expression, config.CalculationTimeoutsConfig{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CalculateMatrix() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotMatrix, tt.wantMatrix) {
+				t.Errorf("CalculateMatrix() gotMatrix = %v, want %v", gotMatrix, tt.wantMatrix)
+			}
+			if gotAns != tt.wantAns {
+				t.Errorf("CalculateMatrix() gotAns = %v, want %v", gotAns, tt.wantAns)
+			}
+		})
+	}
+}
@@ -89,6 +89,317 @@ func TestCalculate(t *testing.T) {
 			wantAns: 0,
 			wantErr: true,
 		},
+		{
+			name: "7%3",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7,
+					Val2:   3,
+					Action: "%",
+				},
+			},
+			wantAns: 1,
+			wantErr: false,
+		},
+		{
+			name: "-7%3",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   -7,
+					Val2:   3,
+					Action: "%",
+				},
+			},
+			wantAns: 2,
+			wantErr: false,
+		},
+		{
+			name: "7%-3",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7,
+					Val2:   -3,
+					Action: "%",
+				},
+			},
+			wantAns: -2,
+			wantErr: false,
+		},
+		{
+			name: "5.5%2",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   5.5,
+					Val2:   2,
+					Action: "%",
+				},
+			},
+			wantAns: 1.5,
+			wantErr: false,
+		},
+		{
+			name: "-5.5%2",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   -5.5,
+					Val2:   2,
+					Action: "%",
+				},
+			},
+			wantAns: 0.5,
+			wantErr: false,
+		},
+		{
+			name: "7%0",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7,
+					Val2:   0,
+					Action: "%",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "7//2",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7,
+					Val2:   2,
+					Action: "//",
+				},
+			},
+			wantAns: 3,
+			wantErr: false,
+		},
+		{
+			name: "-7//2",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   -7,
+					Val2:   2,
+					Action: "//",
+				},
+			},
+			wantAns: -4,
+			wantErr: false,
+		},
+		{
+			name: "7.5//-2",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7.5,
+					Val2:   -2,
+					Action: "//",
+				},
+			},
+			wantAns: -4,
+			wantErr: false,
+		},
+		{
+			name: "7//0",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7,
+					Val2:   0,
+					Action: "//",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "2^10",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   2,
+					Val2:   10,
+					Action: "^",
+				},
+			},
+			wantAns: 1024,
+			wantErr: false,
+		},
+		{
+			name: "4^0.5",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   4,
+					Val2:   0.5,
+					Action: "^",
+				},
+			},
+			wantAns: 2,
+			wantErr: false,
+		},
+		{
+			name: "0^-1",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   0,
+					Val2:   -1,
+					Action: "^",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "-8^0.5",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   -8,
+					Val2:   0.5,
+					Action: "^",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "sqrt(16)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{16},
+					Action: "sqrt",
+				},
+			},
+			wantAns: 4,
+			wantErr: false,
+		},
+		{
+			name: "sqrt(-1)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{-1},
+					Action: "sqrt",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "abs(-3)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{-3},
+					Action: "abs",
+				},
+			},
+			wantAns: 3,
+			wantErr: false,
+		},
+		{
+			name: "max(3,7,2)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{3, 7, 2},
+					Action: "max",
+				},
+			},
+			wantAns: 7,
+			wantErr: false,
+		},
+		{
+			name: "min(3,-7,2)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{3, -7, 2},
+					Action: "min",
+				},
+			},
+			wantAns: -7,
+			wantErr: false,
+		},
+		{
+			name: "round(2.345,2)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{2.345, 2},
+					Action: "round",
+				},
+			},
+			wantAns: 2.35,
+			wantErr: false,
+		},
+		{
+			name: "round(-2.5)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{-2.5},
+					Action: "round",
+				},
+			},
+			wantAns: -3,
+			wantErr: false,
+		},
+		{
+			name: "round(1,0.5)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{1, 0.5},
+					Action: "round",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "log(0)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{0},
+					Action: "log",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "log(8,2)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{8, 2},
+					Action: "log",
+				},
+			},
+			wantAns: 3,
+			wantErr: false,
+		},
+		{
+			name: "log(8,1)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{8, 1},
+					Action: "log",
+				},
+			},
+			wantAns: 0,
+			wantErr: true,
+		},
+		{
+			name: "-7.5",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   7.5,
+					Action: "neg",
+				},
+			},
+			wantAns: -7.5,
+			wantErr: false,
+		},
+		{
+			name: "+3",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   3,
+					Action: "pos",
+				},
+			},
+			wantAns: 3,
+			wantErr: false,
+		},
 		{
 			name: "&*100/",
 			args: args{
@@ -101,6 +412,40 @@ func TestCalculate(t *testing.T) {
 			wantAns: 0,
 			wantErr: true,
 		},
+		{
+			name: "3<=3",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   3,
+					Val2:   3,
+					Action: "<=",
+				},
+			},
+			wantAns: 1,
+			wantErr: false,
+		},
+		{
+			name: "!5",
+			args: args{
+				expression: &models.SubExpression{
+					Val1:   5,
+					Action: "not",
+				},
+			},
+			wantAns: 0,
+			wantErr: false,
+		},
+		{
+			name: "if(0, 1, 2)",
+			args: args{
+				expression: &models.SubExpression{
+					Args:   []float64{0, 1, 2},
+					Action: "if",
+				},
+			},
+			wantAns: 2,
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -5,8 +5,10 @@ import (
 	"github.com/google/uuid"
 	"log"
 	"myproject/internal/config"
+	"myproject/internal/lib/lru"
 	"myproject/internal/models"
 	"myproject/internal/repositories/queue"
+	"sync"
 	"time"
 )
 
@@ -17,27 +19,45 @@ type IAgent interface {
 	CalculateExpression(task *models.SubExpression)
 	// StartHeartbeats отправка heartbeats
 	StartHeartbeats()
+	// ReceiveCancellations принимает отмены выражений от оркестратора
+	ReceiveCancellations()
 }
 
 type Agent struct {
-	id                         string
-	expressionQueueRepository  queue.Repository
-	calculationQueueRepository queue.Repository
-	heartbeatQueueRepository   queue.Repository
-	rpcQueueRepository         queue.Repository
-	calculationTimeouts        config.CalculationTimeoutsConfig
+	id                          string
+	expressionQueueRepository   queue.Repository
+	calculationQueueRepository  queue.Repository
+	heartbeatQueueRepository    queue.Repository
+	leaseQueueRepository        queue.Repository
+	cancellationQueueRepository queue.Repository
+	calculationTimeouts         config.CalculationTimeoutsConfig
+	// leaseTTL срок аренды subexpression, агент продлевает ее в 3 раза чаще
+	leaseTTL time.Duration
+
+	mu sync.Mutex
+	// cancelled недавно отмененные выражения, их subexpressions, уже попавшие в очередь, не считаются
+	cancelled *lru.Cache[uuid.UUID, struct{}]
+	// current expressionId считаемого subexpression, abort закрывается при отмене его выражения
+	current uuid.UUID
+	abort   chan struct{}
 }
 
-func NewAgent(expressionQueueRepo, calculationQueueRepo, heartbeatQueueRepo, rpcQueueRepo queue.Repository,
-	timeouts config.CalculationTimeoutsConfig) *Agent {
+// cancelledExpressionsSize сколько последних отмененных выражений помнит агент
+const cancelledExpressionsSize = 1024
+
+func NewAgent(expressionQueueRepo, calculationQueueRepo, heartbeatQueueRepo, leaseQueueRepo, cancellationQueueRepo queue.Repository,
+	timeouts config.CalculationTimeoutsConfig, leaseTTL time.Duration) *Agent {
 	id := uuid.NewString()
 	return &Agent{
-		id:                         id,
-		expressionQueueRepository:  expressionQueueRepo,
-		calculationQueueRepository: calculationQueueRepo,
-		heartbeatQueueRepository:   heartbeatQueueRepo,
-		rpcQueueRepository:         rpcQueueRepo,
-		calculationTimeouts:        timeouts,
+		id:                          id,
+		expressionQueueRepository:   expressionQueueRepo,
+		calculationQueueRepository:  calculationQueueRepo,
+		heartbeatQueueRepository:    heartbeatQueueRepo,
+		leaseQueueRepository:        leaseQueueRepo,
+		cancellationQueueRepository: cancellationQueueRepo,
+		calculationTimeouts:         timeouts,
+		leaseTTL:                    leaseTTL,
+		cancelled:                   lru.New[uuid.UUID, struct{}](cancelledExpressionsSize),
 	}
 }
 
@@ -56,45 +76,113 @@ func (a *Agent) Start() {
 
 	// начинаем посылать heartbeats
 	go a.StartHeartbeats()
+	go a.ReceiveCancellations()
 
 	// обработка subexpressions из очереди
 	for task := range tasks {
 		expressionStruct := &models.SubExpression{}
 		_ = json.Unmarshal(task, expressionStruct)
 
-		// формирование ответа оркестратору и том, что взяли subexpression на обработку
-		idAgent, _ := uuid.Parse(a.id)
-		rpcAnswer := models.RPCAnswer{
-			IdSubExpression: expressionStruct.Id,
-			IdAgent:         idAgent,
-		}
-		err := a.rpcQueueRepository.Connect()
-		if err != nil {
-			log.Printf("error connect to rpc queue")
-		}
-		rpcJson, err := json.Marshal(rpcAnswer)
-		if err != nil {
-			log.Printf("error unmarshal rpc")
-		}
-		err = a.rpcQueueRepository.Publish(rpcJson)
-		if err != nil {
-			log.Printf("error publish rpc")
-		}
-		a.rpcQueueRepository.Close()
-
+		// пока subexpression считается, агент продлевает его аренду, иначе оркестратор отправит его повторно
+		stop := a.keepLease(expressionStruct)
 		// подсчет subexpression
 		a.CalculateExpression(expressionStruct)
+		stop()
 	}
 }
 
-func (a *Agent) CalculateExpression(task *models.SubExpression) {
-	result, err := Calculate(task, a.calculationTimeouts)
+// keepLease сообщает оркестратору, что агент взял subexpression, и продлевает аренду каждые leaseTTL/3.
+// Возвращает функцию, прекращающую продление
+func (a *Agent) keepLease(task *models.SubExpression) func() {
+	idAgent, _ := uuid.Parse(a.id)
+	lease, err := json.Marshal(models.Lease{SubExpressionId: task.Id, AgentId: idAgent, Attempt: task.Attempt})
+	if err != nil {
+		log.Printf("Failed to encode lease: %v", err)
+		return func() {}
+	}
+	err = a.leaseQueueRepository.Connect()
 	if err != nil {
-		task.Error = true
+		log.Printf("Failed to connect to lease queue: %v", err)
+		return func() {}
+	}
+	renew := func() {
+		if err := a.leaseQueueRepository.Publish(lease); err != nil {
+			log.Printf("Failed to renew lease of subexpression %s: %v", task.Id, err)
+		}
+	}
+	renew()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer a.leaseQueueRepository.Close()
+		ticker := time.NewTicker(max(a.leaseTTL/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renew()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
 	}
-	task.Result = result
+}
+
+func (a *Agent) CalculateExpression(task *models.SubExpression) {
+	if task.Deadline != nil && !time.Now().Before(*task.Deadline) {
+		log.Printf("expression %s is timed out, skip subexpression %s", task.ExpressionId, task.Id)
+		return
+	}
+	abort, ok := a.startTask(task)
+	if !ok {
+		log.Printf("expression %s is cancelled, skip subexpression %s", task.ExpressionId, task.Id)
+		return
+	}
+	defer a.finishTask()
 
-	err = a.calculationQueueRepository.Connect()
+	// подсчет ждет паузу из config, поэтому при отмене выражения или истечении его срока агент не дожидается его окончания
+	var expired <-chan time.Time
+	if task.Deadline != nil {
+		timer := time.NewTimer(time.Until(*task.Deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		switch {
+		case task.Mode == models.ModeExact:
+			task.ResultExact, task.Result, err = CalculateExact(task, a.calculationTimeouts)
+		case task.Mode == models.ModeComplex:
+			task.Result, task.ResultIm, err = CalculateComplex(task, a.calculationTimeouts)
+		case hasMatrixOperands(task):
+			task.ResultMatrix, task.Result, err = CalculateMatrix(task, a.calculationTimeouts)
+		default:
+			task.Result, err = Calculate(task, a.calculationTimeouts)
+		}
+		if err != nil {
+			task.Error = true
+			task.ErrorReason = err.Error()
+		}
+	}()
+	select {
+	case <-done:
+	case <-abort:
+		log.Printf("expression %s is cancelled, abort subexpression %s", task.ExpressionId, task.Id)
+		return
+	case <-expired:
+		log.Printf("expression %s is timed out, abort subexpression %s", task.ExpressionId, task.Id)
+		return
+	}
+
+	err := a.calculationQueueRepository.Connect()
 	if err != nil {
 		log.Fatalf("Failed to connect to queue: %v", err)
 	}
@@ -107,6 +195,58 @@ func (a *Agent) CalculateExpression(task *models.SubExpression) {
 	}
 }
 
+// startTask запоминает считаемый subexpression и возвращает канал, который закроется при отмене его выражения.
+// false - выражение уже отменено
+func (a *Agent) startTask(task *models.SubExpression) (<-chan struct{}, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.cancelled.Get(task.ExpressionId); ok {
+		return nil, false
+	}
+	a.current = task.ExpressionId
+	a.abort = make(chan struct{})
+	return a.abort, true
+}
+
+func (a *Agent) finishTask() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.current, a.abort = uuid.Nil, nil
+}
+
+// cancel запоминает отмененное выражение и прерывает подсчет его subexpression, если агент его считает
+func (a *Agent) cancel(expressionId uuid.UUID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.cancelled.Add(expressionId, struct{}{})
+	if a.abort != nil && a.current == expressionId {
+		close(a.abort)
+		a.abort = nil
+	}
+}
+
+func (a *Agent) ReceiveCancellations() {
+	// соединение с fanout exchange открыто при создании очереди
+	defer a.cancellationQueueRepository.Close()
+
+	cancellations, err := a.cancellationQueueRepository.Consume()
+	if err != nil {
+		log.Printf("Failed to consume cancellations from queue: %v", err)
+		return
+	}
+	for message := range cancellations {
+		cancellation := models.Cancellation{}
+		if err := json.Unmarshal(message, &cancellation); err != nil {
+			log.Printf("Failed to decode cancellation: %v", err)
+			continue
+		}
+		a.cancel(cancellation.ExpressionId)
+	}
+}
+
 func (a *Agent) StartHeartbeats() {
 	// Открываем соединение один раз, а не на каждую итерацию
 	err := a.heartbeatQueueRepository.Connect()
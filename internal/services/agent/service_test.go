@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseQueue очередь продлений аренд, запоминающая опубликованные записи
+type leaseQueue struct {
+	mu        sync.Mutex
+	published [][]byte
+	closed    bool
+}
+
+func (q *leaseQueue) Connect() error { return nil }
+
+func (q *leaseQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	return nil
+}
+
+func (q *leaseQueue) Publish(task []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.published = append(q.published, task)
+	return nil
+}
+
+func (q *leaseQueue) Consume() (<-chan []byte, error) { return nil, nil }
+
+func TestAgentCancel(t *testing.T) {
+	a := NewAgent(nil, nil, nil, nil, nil, config.CalculationTimeoutsConfig{}, time.Second)
+	task := &models.SubExpression{Id: uuid.New(), ExpressionId: uuid.New()}
+
+	abort, ok := a.startTask(task)
+	if !ok {
+		t.Fatalf("startTask() ok = false, want true")
+	}
+	a.cancel(uuid.New())
+	select {
+	case <-abort:
+		t.Fatalf("abort is closed by cancellation of another expression")
+	default:
+	}
+
+	a.cancel(task.ExpressionId)
+	select {
+	case <-abort:
+	default:
+		t.Fatalf("abort is not closed by cancellation of the expression")
+	}
+	a.finishTask()
+
+	if _, ok := a.startTask(&models.SubExpression{Id: uuid.New(), ExpressionId: task.ExpressionId}); ok {
+		t.Errorf("startTask() ok = true for subexpression of cancelled expression, want false")
+	}
+}
+
+// calculationQueueRepository агента в тестах nil, поэтому отправка результата завершила бы тест паникой
+func TestAgentDeadline(t *testing.T) {
+	a := NewAgent(nil, nil, nil, nil, nil, config.CalculationTimeoutsConfig{TimeCalculatePlus: time.Minute}, time.Second)
+
+	passed := time.Now().Add(-time.Second)
+	a.CalculateExpression(&models.SubExpression{Id: uuid.New(), ExpressionId: uuid.New(), Action: "+", Deadline: &passed})
+
+	deadline := time.Now().Add(10 * time.Millisecond)
+	start := time.Now()
+	a.CalculateExpression(&models.SubExpression{Id: uuid.New(), ExpressionId: uuid.New(), Action: "+", Deadline: &deadline})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CalculateExpression() waited %v after deadline", elapsed)
+	}
+}
+
+func TestAgentKeepLease(t *testing.T) {
+	leases := &leaseQueue{}
+	a := NewAgent(nil, nil, nil, leases, nil, config.CalculationTimeoutsConfig{}, 30*time.Millisecond)
+	task := &models.SubExpression{Id: uuid.New(), ExpressionId: uuid.New(), Attempt: 2}
+
+	stop := a.keepLease(task)
+	time.Sleep(55 * time.Millisecond)
+	stop()
+
+	leases.mu.Lock()
+	published := len(leases.published)
+	leases.mu.Unlock()
+	// аренда сообщается сразу и продлевается каждые 10ms
+	if published < 3 {
+		t.Fatalf("keepLease() published %d leases, want at least 3", published)
+	}
+	if !leases.closed {
+		t.Errorf("keepLease() stop did not close lease queue")
+	}
+	lease := models.Lease{}
+	if err := json.Unmarshal(leases.published[0], &lease); err != nil {
+		t.Fatalf("lease is not decoded: %v", err)
+	}
+	if lease.SubExpressionId != task.Id || lease.Attempt != task.Attempt || lease.AgentId.String() != a.id {
+		t.Errorf("keepLease() published %+v, want lease of subexpression %s attempt %d", lease, task.Id, task.Attempt)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if len(leases.published) != published {
+		t.Errorf("keepLease() renewed lease after stop")
+	}
+}
@@ -0,0 +1,239 @@
+package orchestrator
+
+import (
+	"github.com/google/uuid"
+	"myproject/internal/models"
+	"sync"
+	"time"
+)
+
+// userTasks готовые к отправке и отправленные, но еще не посчитанные subexpressions пользователя
+type userTasks struct {
+	quota    *models.Quota
+	ready    []*models.SubExpression
+	inFlight int
+}
+
+// inFlightTask отправленный агентам subexpression
+type inFlightTask struct {
+	userId       string
+	expressionId uuid.UUID
+}
+
+// scheduler определяет очередность отправки subexpressions агентам: пользователи с готовыми subexpressions
+// обходятся по кругу (weighted round-robin), за один круг пользователю отправляется до quota.Weight
+// subexpressions в порядке приоритета с учетом ожидания (см. before). Пользователь, у которого
+// quota.MaxInFlight subexpressions еще считаются, пропускается, а при maxInFlight subexpressions
+// всех пользователей отправка ждет результатов
+type scheduler struct {
+	mu          sync.Mutex
+	maxInFlight int
+	// priorityAging время ожидания, за которое приоритет subexpression повышается на 1
+	priorityAging time.Duration
+	users         map[string]*userTasks
+	// ring пользователи с готовыми subexpressions в порядке обхода, current - чья очередь, credit -
+	// сколько еще subexpressions можно отправить ему в этом круге
+	ring     []string
+	current  int
+	credit   int
+	inFlight map[uuid.UUID]inFlightTask
+	// queued id готовых subexpressions всех пользователей, чтобы один subexpression не добавлялся дважды
+	queued map[uuid.UUID]struct{}
+	wake   chan struct{}
+}
+
+func newScheduler(maxInFlight int, priorityAging time.Duration) *scheduler {
+	return &scheduler{
+		maxInFlight:   maxInFlight,
+		priorityAging: priorityAging,
+		users:         make(map[string]*userTasks),
+		inFlight:      make(map[uuid.UUID]inFlightTask),
+		queued:        make(map[uuid.UUID]struct{}),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// push добавляет готовый к отправке subexpression пользователя. quota используется, если у пользователя
+// нет готовых и отправленных subexpressions, иначе действует квота, с которой они были добавлены.
+// Уже готовый или отправленный subexpression не добавляется повторно.
+// false - квота пользователя неизвестна, а quota nil: subexpression не добавлен, его нужно добавить с квотой
+func (s *scheduler) push(userId string, quota *models.Quota, subExpr *models.SubExpression) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queued[subExpr.Id]; ok {
+		return true
+	}
+	if _, ok := s.inFlight[subExpr.Id]; ok {
+		return true
+	}
+	tasks, ok := s.users[userId]
+	if !ok {
+		if quota == nil {
+			return false
+		}
+		tasks = &userTasks{quota: quota}
+		s.users[userId] = tasks
+	}
+	if len(tasks.ready) == 0 {
+		s.ring = append(s.ring, userId)
+		if len(s.ring) == 1 {
+			s.current, s.credit = 0, s.weight(userId)
+		}
+	}
+	// subexpressions с равным приоритетом отправляются в порядке добавления
+	i := len(tasks.ready)
+	for i > 0 && s.before(subExpr, tasks.ready[i-1]) {
+		i--
+	}
+	tasks.ready = append(tasks.ready, nil)
+	copy(tasks.ready[i+1:], tasks.ready[i:])
+	tasks.ready[i] = subExpr
+	s.queued[subExpr.Id] = struct{}{}
+	s.signal()
+	return true
+}
+
+// before проверяет, что a отправляется раньше b. Приоритет subexpression повышается на 1 за каждые priorityAging
+// ожидания, поэтому a обгоняет b, если CreatedAt-Priority*priorityAging у него раньше: сравнение не зависит от
+// текущего времени, и порядок готовых subexpressions не меняется со временем. Без priorityAging или времени
+// создания сравниваются приоритеты
+func (s *scheduler) before(a, b *models.SubExpression) bool {
+	if s.priorityAging <= 0 || a.CreatedAt.IsZero() || b.CreatedAt.IsZero() {
+		return a.Priority > b.Priority
+	}
+	agedA := a.CreatedAt.Add(-time.Duration(a.Priority) * s.priorityAging)
+	agedB := b.CreatedAt.Add(-time.Duration(b.Priority) * s.priorityAging)
+	return agedA.Before(agedB)
+}
+
+// next возвращает следующий subexpression для отправки, ожидая, пока такой появится
+func (s *scheduler) next() *models.SubExpression {
+	for {
+		if subExpr, ok := s.pop(); ok {
+			return subExpr
+		}
+		<-s.wake
+	}
+}
+
+func (s *scheduler) pop() (*models.SubExpression, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxInFlight > 0 && len(s.inFlight) >= s.maxInFlight {
+		return nil, false
+	}
+	for range s.ring {
+		userId := s.ring[s.current]
+		tasks := s.users[userId]
+		if s.credit > 0 && (tasks.quota.MaxInFlight <= 0 || tasks.inFlight < tasks.quota.MaxInFlight) {
+			subExpr := tasks.ready[0]
+			tasks.ready = tasks.ready[1:]
+			delete(s.queued, subExpr.Id)
+			tasks.inFlight++
+			s.inFlight[subExpr.Id] = inFlightTask{userId: userId, expressionId: subExpr.ExpressionId}
+			s.credit--
+			if len(tasks.ready) == 0 {
+				s.removeFromRing(s.current)
+			} else if s.credit == 0 {
+				s.advance()
+			}
+			return subExpr, true
+		}
+		s.advance()
+	}
+	return nil, false
+}
+
+func (s *scheduler) advance() {
+	s.current = (s.current + 1) % len(s.ring)
+	s.credit = s.weight(s.ring[s.current])
+}
+
+// removeFromRing убирает из обхода пользователя без готовых subexpressions, очередь переходит к следующему
+func (s *scheduler) removeFromRing(i int) {
+	s.ring = append(s.ring[:i], s.ring[i+1:]...)
+	if len(s.ring) == 0 {
+		s.current, s.credit = 0, 0
+		return
+	}
+	s.current = i % len(s.ring)
+	s.credit = s.weight(s.ring[s.current])
+}
+
+func (s *scheduler) weight(userId string) int {
+	return max(1, s.users[userId].quota.Weight)
+}
+
+// done отмечает, что результат subexpression получен (или он больше не будет посчитан), повторный вызов ничего не делает
+func (s *scheduler) done(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.inFlight[id]
+	if !ok {
+		return
+	}
+	delete(s.inFlight, id)
+	tasks := s.users[task.userId]
+	tasks.inFlight--
+	s.forgetUser(task.userId)
+	s.signal()
+}
+
+// releaseExpression убирает готовые subexpressions завершенного выражения и перестает ждать результатов
+// отправленных: агенты не возвращают результаты subexpressions отмененных и просроченных выражений
+func (s *scheduler) releaseExpression(expressionId uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, task := range s.inFlight {
+		if task.expressionId == expressionId {
+			delete(s.inFlight, id)
+			s.users[task.userId].inFlight--
+			s.forgetUser(task.userId)
+		}
+	}
+	for i := 0; i < len(s.ring); i++ {
+		userId := s.ring[i]
+		tasks := s.users[userId]
+		ready := tasks.ready[:0]
+		for _, subExpr := range tasks.ready {
+			if subExpr.ExpressionId != expressionId {
+				ready = append(ready, subExpr)
+			} else {
+				delete(s.queued, subExpr.Id)
+			}
+		}
+		tasks.ready = ready
+		if len(ready) > 0 {
+			continue
+		}
+		if i == s.current {
+			s.removeFromRing(i)
+		} else {
+			if i < s.current {
+				s.current--
+			}
+			s.ring = append(s.ring[:i], s.ring[i+1:]...)
+		}
+		s.forgetUser(userId)
+		i--
+	}
+	s.signal()
+}
+
+// forgetUser удаляет пользователя без готовых и отправленных subexpressions, чтобы его квота перечитывалась
+func (s *scheduler) forgetUser(userId string) {
+	if tasks := s.users[userId]; tasks.inFlight == 0 && len(tasks.ready) == 0 {
+		delete(s.users, userId)
+	}
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
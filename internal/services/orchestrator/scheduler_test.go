@@ -0,0 +1,158 @@
+package orchestrator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+// popUsers возвращает пользователей отправленных subexpressions в порядке отправки
+func popUsers(s *scheduler, users map[uuid.UUID]string) []string {
+	var order []string
+	for {
+		subExpr, ok := s.pop()
+		if !ok {
+			return order
+		}
+		order = append(order, users[subExpr.ExpressionId])
+	}
+}
+
+func TestSchedulerFairShare(t *testing.T) {
+	s := newScheduler(0, 0)
+	expressions := map[string]uuid.UUID{"a": uuid.New(), "b": uuid.New()}
+	users := map[uuid.UUID]string{expressions["a"]: "a", expressions["b"]: "b"}
+	for i := 0; i < 6; i++ {
+		s.push("a", &models.Quota{Weight: 2}, &models.SubExpression{Id: uuid.New(), ExpressionId: expressions["a"]})
+	}
+	for i := 0; i < 2; i++ {
+		s.push("b", &models.Quota{Weight: 1}, &models.SubExpression{Id: uuid.New(), ExpressionId: expressions["b"]})
+	}
+
+	// пользователь a с весом 2 получает два subexpressions за круг, а b не ждет, пока посчитаются все subexpressions a
+	assert.Equal(t, []string{"a", "a", "b", "a", "a", "b", "a", "a"}, popUsers(s, users))
+}
+
+func TestSchedulerInFlightLimits(t *testing.T) {
+	s := newScheduler(3, 0)
+	a, b := uuid.New(), uuid.New()
+	users := map[uuid.UUID]string{a: "a", b: "b"}
+	var first *models.SubExpression
+	for i := 0; i < 4; i++ {
+		subExpr := &models.SubExpression{Id: uuid.New(), ExpressionId: a}
+		if first == nil {
+			first = subExpr
+		}
+		s.push("a", &models.Quota{MaxInFlight: 2, Weight: 1}, subExpr)
+	}
+	s.push("b", &models.Quota{Weight: 1}, &models.SubExpression{Id: uuid.New(), ExpressionId: b})
+	s.push("b", nil, &models.SubExpression{Id: uuid.New(), ExpressionId: b})
+
+	// у a не больше 2 считающихся subexpressions, всего не больше 3
+	assert.Equal(t, []string{"a", "b", "a"}, popUsers(s, users))
+
+	s.done(first.Id)
+	s.done(first.Id)
+	assert.Equal(t, []string{"b"}, popUsers(s, users))
+}
+
+func TestSchedulerReleaseExpression(t *testing.T) {
+	s := newScheduler(2, 0)
+	cancelled, other := uuid.New(), uuid.New()
+	users := map[uuid.UUID]string{cancelled: "a", other: "b"}
+	for i := 0; i < 3; i++ {
+		s.push("a", &models.Quota{Weight: 1}, &models.SubExpression{Id: uuid.New(), ExpressionId: cancelled})
+	}
+	s.push("b", &models.Quota{Weight: 1}, &models.SubExpression{Id: uuid.New(), ExpressionId: other})
+	assert.Equal(t, []string{"a", "b"}, popUsers(s, users))
+
+	// результаты subexpressions отмененного выражения не придут, их места освобождаются
+	s.releaseExpression(cancelled)
+	assert.Empty(t, popUsers(s, users))
+	assert.Len(t, s.inFlight, 1)
+	assert.NotContains(t, s.users, "a")
+}
+
+// пользователь удаляется из планировщика после результата последнего subexpression, поэтому добавление
+// без квоты, проверенное до этого, не должно оставить пользователя без квоты
+func TestSchedulerPushAfterUserForgotten(t *testing.T) {
+	s := newScheduler(0, 0)
+	quota := &models.Quota{Weight: 1}
+	first := &models.SubExpression{Id: uuid.New()}
+	assert.True(t, s.push("a", quota, first))
+	_, ok := s.pop()
+	assert.True(t, ok)
+
+	s.done(first.Id)
+	assert.False(t, s.push("a", nil, &models.SubExpression{Id: uuid.New()}))
+	assert.NotContains(t, s.users, "a")
+	assert.NotPanics(t, func() {
+		_, ok = s.pop()
+	})
+	assert.False(t, ok)
+
+	assert.True(t, s.push("a", quota, &models.SubExpression{Id: uuid.New()}))
+	_, ok = s.pop()
+	assert.True(t, ok)
+}
+
+func TestSchedulerPushDuplicate(t *testing.T) {
+	s := newScheduler(0, 0)
+	quota := &models.Quota{Weight: 1}
+	subExpr := &models.SubExpression{Id: uuid.New()}
+
+	// subexpression может прийти и из уведомления, и из загрузки готовых subexpressions при запуске
+	s.push("a", quota, subExpr)
+	s.push("a", quota, subExpr)
+	_, ok := s.pop()
+	assert.True(t, ok)
+	s.push("a", quota, subExpr)
+	_, ok = s.pop()
+	assert.False(t, ok)
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s := newScheduler(0, 0)
+	quota := &models.Quota{Weight: 1}
+	for _, priority := range []int{1, 5, 1, 9} {
+		s.push("a", quota, &models.SubExpression{Id: uuid.New(), Priority: priority})
+	}
+	var priorities []int
+	for {
+		subExpr, ok := s.pop()
+		if !ok {
+			break
+		}
+		priorities = append(priorities, subExpr.Priority)
+	}
+	assert.Equal(t, []int{9, 5, 1, 1}, priorities)
+}
+
+func TestSchedulerPriorityAging(t *testing.T) {
+	s := newScheduler(0, time.Minute)
+	quota := &models.Quota{Weight: 1}
+	now := time.Now()
+	// низкоприоритетный subexpression ждет 10 минут: его приоритет 1+10 выше, чем у новых с приоритетом 9
+	old := &models.SubExpression{Id: uuid.New(), Priority: 1, CreatedAt: now.Add(-10 * time.Minute)}
+	s.push("a", quota, old)
+	for i := 0; i < 3; i++ {
+		s.push("a", quota, &models.SubExpression{Id: uuid.New(), Priority: 9, CreatedAt: now})
+	}
+	recent := &models.SubExpression{Id: uuid.New(), Priority: 1, CreatedAt: now}
+	s.push("a", quota, recent)
+
+	first, _ := s.pop()
+	assert.Equal(t, old.Id, first.Id)
+	var last *models.SubExpression
+	for {
+		subExpr, ok := s.pop()
+		if !ok {
+			break
+		}
+		last = subExpr
+	}
+	assert.Equal(t, recent.Id, last.Id)
+}
@@ -3,91 +3,437 @@ package orchestrator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"log"
+	"math"
+	"math/rand"
+	"myproject/internal/config"
 	"myproject/internal/models"
+	"myproject/internal/repositories"
 	"myproject/internal/repositories/agent"
 	"myproject/internal/repositories/expression"
+	"myproject/internal/repositories/formula"
 	"myproject/internal/repositories/queue"
+	"myproject/internal/repositories/quota"
+	"myproject/internal/repositories/resultCache"
 	"myproject/internal/repositories/subExpression"
 	"myproject/internal/services/orchestrator/utils"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrQuotaExceeded пользователь создал за сутки столько выражений, сколько позволяет его квота
+var ErrQuotaExceeded = repositories.ErrQuotaExceeded
+
 type IOrchestrator interface {
-	CreateExpression(ctx context.Context, expression, idempotencyKey, userId string) (error, string)
+	// CreateExpression создает выражение со значениями переменных bindings в режиме вычисления mode и разбивает его на subexpressions.
+	// Если выражение не посчитается до deadline (nil - без срока), оно переходит в статус ExpressionTimedOut.
+	// Subexpressions выражений с большим priority (от 0 до models.PriorityMax) отправляются агентам раньше.
+	// Если пользователь исчерпал суточную квоту выражений, возвращается ErrQuotaExceeded
+	CreateExpression(ctx context.Context, expression, idempotencyKey, userId string, bindings map[string]float64, mode models.ExpressionMode, deadline *time.Time, priority int) (error, string)
+	// CreateExpressions создает пакет выражений пользователя в одной транзакции, результаты возвращаются в порядке items.
+	// Выражения сверх суточной квоты не создаются, их результат - ErrQuotaExceeded
+	CreateExpressions(ctx context.Context, items []*models.ExpressionBatchItem, userId string) ([]*models.ExpressionBatchResult, error)
 	GetExpressions(ctx context.Context, userId string) ([]*models.Expression, error)
+	// CreateFormula разбирает определение формулы вида "vat(x) = x * 1.2" и сохраняет его новой версией формулы пользователя
+	CreateFormula(ctx context.Context, definition, userId string) (*models.Formula, error)
+	// GetFormulas возвращает последние версии формул пользователя
+	GetFormulas(ctx context.Context, userId string) ([]*models.Formula, error)
+	// ExplainExpression возвращает план вычисления выражения до и после оптимизации дерева, не сохраняя выражение
+	ExplainExpression(ctx context.Context, expression, userId string, bindings map[string]float64, mode models.ExpressionMode) (*models.ExpressionPlan, error)
 	GetSubExpressions(ctx context.Context) ([]*models.SubExpression, error)
 	GetExpression(ctx context.Context, id, userId string) (*models.Expression, error)
 	GetExpressionByKey(ctx context.Context, key, userId string) (*models.Expression, error)
+	// GetExpressionAttempts возвращает историю отправок агентам subexpressions выражения пользователя
+	GetExpressionAttempts(ctx context.Context, id, userId string) ([]*models.SubExpressionAttempt, error)
+	// WatchExpression возвращает канал изменений выражения пользователя: сначала текущее состояние, затем каждое
+	// изменение состояния, прогресса (SubExpressionsDone из SubExpressionsTotal) и результата.
+	// Канал закрывается, когда выражение посчитано или завершилось с ошибкой, либо после отмены ctx
+	WatchExpression(ctx context.Context, id, userId string) (<-chan *models.Expression, error)
+	// WatchMyExpressions возвращает канал изменений всех выражений пользователя, канал закрывается после отмены ctx
+	WatchMyExpressions(ctx context.Context, userId string) <-chan *models.Expression
 	UpdateExpressionState(ctx context.Context, key string, state models.ExpressionState) error
+	// CancelExpression отменяет выражение пользователя: удаляет его неподсчитанные subexpressions
+	// и сообщает агентам, чтобы они прервали подсчет уже взятых subexpressions
+	CancelExpression(ctx context.Context, id, userId string) error
 	// ReceiveHeartbeats принимает heartbeats из очереди от агента
 	ReceiveHeartbeats()
 	// ReceiveCalculations принимает подсчитанные subexpression из очереди от агента
 	ReceiveCalculations(ctx context.Context)
 	CreateAgentIfNotExists(id string)
 	GetAgents() ([]*models.Agent, error)
-	// SendSubExpression отправляет subexpressions в очередь, которые могут подсчитаться (являются независимыми от ответов других subexpressions)
+	// SendSubExpression передает планировщику subexpressions, которые могут подсчитаться (являются независимыми от ответов других subexpressions)
 	SendSubExpression()
-	// ReceiveRPCTasks принимает ответы от агента о том, какой subexpression он взял на обработку
-	ReceiveRPCTasks(ctx context.Context)
-	// RetrySubExpressions переназначает неподсчитанные subexpressions умершего агента на другого
+	// DispatchSubExpressions отправляет subexpressions в очередь в порядке, определенном планировщиком:
+	// по кругу между пользователями с учетом их весов и ограничений на количество считающихся subexpressions
+	DispatchSubExpressions()
+	// ReceiveLeaseRenewals принимает от агентов продления аренд считаемых subexpressions
+	ReceiveLeaseRenewals(ctx context.Context)
+	// RetrySubExpressions повторно отправляет агентам subexpressions с истекшей арендой (с задержкой, растущей
+	// с каждой попыткой), завершает с ошибкой выражения, subexpression которых исчерпал попытки,
+	// и завершает выражения с истекшим сроком
 	RetrySubExpressions(ctx context.Context)
+	// GetResultCacheStats возвращает количество попаданий и промахов кэша результатов subexpressions
+	GetResultCacheStats(ctx context.Context) (*models.ResultCacheStats, error)
 }
 
 type Orchestrator struct {
-	expressionRepository        expression.Repository
-	subExpressionRepository     subExpression.Repository
-	agentRepository             agent.Repository
-	expressionsQueueRepository  queue.Repository
-	calculationsQueueRepository queue.Repository
-	heartbeatsQueueRepository   queue.Repository
-	rpcQueueRepository          queue.Repository
-	retrySubExpressionTimout    time.Duration
+	expressionRepository         expression.Repository
+	subExpressionRepository      subExpression.Repository
+	formulaRepository            formula.Repository
+	quotaRepository              quota.Repository
+	agentRepository              agent.Repository
+	expressionsQueueRepository   queue.PriorityRepository
+	calculationsQueueRepository  queue.Repository
+	heartbeatsQueueRepository    queue.Repository
+	leaseQueueRepository         queue.Repository
+	cancellationsQueueRepository queue.Repository
+	cancellationsMu              sync.Mutex
+	// resultCacheRepository кэш результатов subexpressions, nil - кэш выключен
+	resultCacheRepository resultCache.Repository
+	resultCacheHits       atomic.Int64
+	resultCacheMisses     atomic.Int64
+	// watchers подписчики WatchExpression и WatchMyExpressions
+	watchers *watchers
+	leases   config.LeasesConfig
+	retries  config.RetriesConfig
+	// priorityAging время ожидания, за которое приоритет subexpression повышается на 1
+	priorityAging time.Duration
+	// quotas ограничения пользователей без записи в user_quotas
+	quotas    config.QuotasConfig
+	scheduler *scheduler
 }
 
 func NewOrchestrator(ctx context.Context, expressionRepo expression.Repository,
 	subExpressionRepo subExpression.Repository,
-	expressionsQueueRepo queue.Repository,
+	formulaRepo formula.Repository,
+	quotaRepo quota.Repository,
+	expressionsQueueRepo queue.PriorityRepository,
 	calculationsQueueRepository queue.Repository,
 	heartbeatsQueueRepository queue.Repository,
-	rpcQueueRepository queue.Repository,
+	leaseQueueRepository queue.Repository,
+	cancellationsQueueRepository queue.Repository,
 	agentRepo agent.Repository,
-	retrySubExpressionTimout time.Duration) *Orchestrator {
+	resultCacheRepo resultCache.Repository,
+	leases config.LeasesConfig,
+	retries config.RetriesConfig,
+	priorityAging time.Duration,
+	quotas config.QuotasConfig) *Orchestrator {
 	orch := &Orchestrator{
-		expressionRepository:        expressionRepo,
-		subExpressionRepository:     subExpressionRepo,
-		agentRepository:             agentRepo,
-		expressionsQueueRepository:  expressionsQueueRepo,
-		calculationsQueueRepository: calculationsQueueRepository,
-		heartbeatsQueueRepository:   heartbeatsQueueRepository,
-		rpcQueueRepository:          rpcQueueRepository,
-		retrySubExpressionTimout:    retrySubExpressionTimout,
+		expressionRepository:         expressionRepo,
+		subExpressionRepository:      subExpressionRepo,
+		formulaRepository:            formulaRepo,
+		quotaRepository:              quotaRepo,
+		agentRepository:              agentRepo,
+		expressionsQueueRepository:   expressionsQueueRepo,
+		calculationsQueueRepository:  calculationsQueueRepository,
+		heartbeatsQueueRepository:    heartbeatsQueueRepository,
+		leaseQueueRepository:         leaseQueueRepository,
+		cancellationsQueueRepository: cancellationsQueueRepository,
+		resultCacheRepository:        resultCacheRepo,
+		watchers:                     newWatchers(),
+		leases:                       leases,
+		retries:                      retries,
+		priorityAging:                priorityAging,
+		quotas:                       quotas,
+		scheduler:                    newScheduler(quotas.MaxInFlight, priorityAging),
 	}
+	go orch.rescheduleReady(ctx)
 	go orch.SendSubExpression()
+	go orch.DispatchSubExpressions()
 	go orch.ReceiveHeartbeats()
 	go orch.ReceiveCalculations(ctx)
-	go orch.ReceiveRPCTasks(ctx)
+	go orch.ReceiveLeaseRenewals(ctx)
 	go orch.RetrySubExpressions(ctx)
+	go orch.broadcastExpressions()
 	return orch
 }
 
-func (o *Orchestrator) CreateExpression(ctx context.Context, expression, idempotencyKey, userId string) (error, string) {
-	createdExpression, err := o.expressionRepository.CreateExpression(ctx, expression, idempotencyKey, userId)
+func (o *Orchestrator) CreateExpression(ctx context.Context, expression, idempotencyKey, userId string, bindings map[string]float64, mode models.ExpressionMode, deadline *time.Time, priority int) (error, string) {
+	if err := checkPriority(priority); err != nil {
+		return err, ""
+	}
+	quota, err := o.userQuota(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("error get quota: %e", err), ""
+	}
+	expr := &models.Expression{
+		Value:          expression,
+		IdempotencyKey: idempotencyKey,
+		UserId:         userId,
+		Bindings:       bindings,
+		Mode:           mode,
+		Deadline:       deadline,
+		Priority:       priority,
+	}
+	root, err := o.expressionTree(ctx, expr)
 	if err != nil {
 		return err, ""
 	}
-	_, err = orchestratorutils.SplitToSubtasks(ctx, createdExpression, o.subExpressionRepository)
+	root = orchestratorutils.Optimize(root)
+	// квота проверяется в транзакции создания выражения, поэтому параллельные вызовы ее не превышают
+	createdExpression, err := o.expressionRepository.CreateExpression(ctx, expr, quota.DailyExpressions)
+	if err != nil {
+		return err, ""
+	}
+	exprId, _ := uuid.Parse(createdExpression.Id)
+	tasks, err := orchestratorutils.SplitToSubtasks(ctx, createdExpression, root, o.subExpressionRepository)
 	if err != nil {
-		exprId, _ := uuid.Parse(createdExpression.Id)
 		o.subExpressionRepository.DeleteSubExpressionsByExpressionId(ctx, exprId)
 		o.expressionRepository.DeleteExpressionById(ctx, exprId)
 		return fmt.Errorf("error split to subtasks: %e", err), ""
 	}
+	// выражение без операций (например "5", "(5)" или "[1, 2]") агентам не отправляется, результат известен сразу
+	if len(tasks) == 0 {
+		setLiteralResult(createdExpression, root)
+		err = o.expressionRepository.UpdateExpressionById(ctx, exprId, createdExpression.Result, createdExpression.ResultExact, createdExpression.ResultIm, createdExpression.ResultMatrix)
+		if err != nil {
+			return fmt.Errorf("error update expression: %e", err), ""
+		}
+	} else if err := o.expressionRepository.AddSubExpressionsTotal(ctx, exprId, len(tasks)); err != nil {
+		// количество subexpressions для прогресса выражения записывается одним обновлением после разбиения
+		log.Printf("error add subexpressions total: %e", err)
+	}
 	return nil, createdExpression.Id
 }
 
+func checkPriority(priority int) error {
+	if priority < 0 || priority > models.PriorityMax {
+		return fmt.Errorf("priority must be from 0 to %d", models.PriorityMax)
+	}
+	return nil
+}
+
+// userQuota возвращает ограничения пользователя из user_quotas или из конфига, если для него они не заданы
+func (o *Orchestrator) userQuota(ctx context.Context, userId string) (*models.Quota, error) {
+	quota, err := o.quotaRepository.GetQuota(ctx, userId)
+	if err != nil || quota != nil {
+		return quota, err
+	}
+	return &models.Quota{
+		UserId:           userId,
+		DailyExpressions: o.quotas.DailyExpressions,
+		MaxInFlight:      o.quotas.MaxInFlightPerUser,
+		Weight:           o.quotas.Weight,
+	}, nil
+}
+
+// setLiteralResult записывает в выражение результат дерева без операций: числа или матрицы
+func setLiteralResult(expr *models.Expression, root orchestratorutils.Node) {
+	expr.State = models.ExpressionOk
+	switch n := root.(type) {
+	case *orchestratorutils.NumberNode:
+		expr.Result, expr.ResultIm = n.Value, n.Imag
+		if expr.Mode == models.ModeExact {
+			expr.ResultExact = n.Literal
+		}
+	case *orchestratorutils.MatrixNode:
+		expr.ResultMatrix = &n.Value
+	}
+}
+
+// MaxExpressionsBatch наибольшее количество выражений в одном пакете CreateExpressions
+const MaxExpressionsBatch = 1000
+
+func (o *Orchestrator) CreateExpressions(ctx context.Context, items []*models.ExpressionBatchItem, userId string) ([]*models.ExpressionBatchResult, error) {
+	if len(items) > MaxExpressionsBatch {
+		return nil, fmt.Errorf("too many expressions in batch: %d, max %d", len(items), MaxExpressionsBatch)
+	}
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.IdempotencyKey)
+	}
+	// выражения с уже использованными ключами не создаются повторно, как и в CreateExpression
+	ids, err := o.expressionRepository.GetExpressionIdsByKeys(ctx, keys, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get expressions by keys: %e", err)
+	}
+	formulas, err := o.formulaRepository.GetFormulas(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get formulas: %e", err)
+	}
+	quota, err := o.userQuota(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get quota: %e", err)
+	}
+
+	results := make([]*models.ExpressionBatchResult, len(items))
+	var expressions []*models.Expression
+	var subExpressions []*models.SubExpression
+	for i, item := range items {
+		results[i] = &models.ExpressionBatchResult{}
+		switch {
+		case item.Expression == "":
+			results[i].Error = errors.New("expression is required")
+			continue
+		case item.IdempotencyKey == "":
+			results[i].Error = errors.New("idempotencyKey is required")
+			continue
+		}
+		if id, ok := ids[item.IdempotencyKey]; ok {
+			results[i].ExpressionId = id
+			continue
+		}
+		priority := models.PriorityDefault
+		if item.Priority != nil {
+			priority = *item.Priority
+		}
+		if err := checkPriority(priority); err != nil {
+			results[i].Error = err
+			continue
+		}
+		switch item.Mode {
+		case "", models.ModeFloat, models.ModeExact, models.ModeComplex:
+		default:
+			results[i].Error = fmt.Errorf("unknown mode %q", item.Mode)
+			continue
+		}
+		if item.Deadline != nil && !item.Deadline.After(time.Now()) {
+			results[i].Error = errors.New("deadline has already passed")
+			continue
+		}
+		expr := &models.Expression{
+			Id:             uuid.NewString(),
+			Value:          item.Expression,
+			IdempotencyKey: item.IdempotencyKey,
+			UserId:         userId,
+			Bindings:       item.Bindings,
+			Mode:           item.Mode,
+			Deadline:       item.Deadline,
+			Priority:       priority,
+			State:          models.ExpressionInProgress,
+		}
+		if expr.Mode == "" {
+			expr.Mode = models.ModeFloat
+		}
+		root, err := checkedTree(expr, formulas)
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		root = orchestratorutils.Optimize(root)
+		tasks, err := orchestratorutils.SplitToSubtasks(ctx, expr, root, unsavedSubExpressions{})
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		if len(tasks) == 0 {
+			setLiteralResult(expr, root)
+		}
+		expr.SubExpressionsTotal = len(tasks)
+		ids[item.IdempotencyKey] = expr.Id
+		results[i].ExpressionId = expr.Id
+		expressions = append(expressions, expr)
+		subExpressions = append(subExpressions, tasks...)
+	}
+	saved, err := o.expressionRepository.CreateExpressions(ctx, expressions, subExpressions, quota.DailyExpressions)
+	if err != nil {
+		return nil, fmt.Errorf("error create expressions: %e", err)
+	}
+	// выражения сверх квоты не сохранены, как и повторы их ключей в этом же пакете
+	unsaved := make(map[string]struct{}, len(expressions)-saved)
+	for _, expr := range expressions[saved:] {
+		unsaved[expr.Id] = struct{}{}
+	}
+	for _, result := range results {
+		if _, ok := unsaved[result.ExpressionId]; ok {
+			result.ExpressionId, result.Error = "", ErrQuotaExceeded
+		}
+	}
+	return results, nil
+}
+
+// unsavedSubExpressions назначает subexpressions id при разбиении, не сохраняя их:
+// в CreateExpressions они сохраняются вместе с выражениями в одной транзакции
+type unsavedSubExpressions struct{}
+
+func (unsavedSubExpressions) CreateSubExpression(_ context.Context, subExpr *models.SubExpression) (*models.SubExpression, error) {
+	subExpr.Id = uuid.New()
+	return subExpr, nil
+}
+
+// ExplainExpression возвращает план вычисления выражения до и после оптимизации дерева (см. orchestratorutils.Optimize):
+// сколько подвыражений будет создано и сколько последовательных обращений к агентам потребуется. Выражение не сохраняется
+func (o *Orchestrator) ExplainExpression(ctx context.Context, expression, userId string, bindings map[string]float64, mode models.ExpressionMode) (*models.ExpressionPlan, error) {
+	expr := &models.Expression{
+		Value:    expression,
+		UserId:   userId,
+		Bindings: bindings,
+		Mode:     mode,
+	}
+	root, err := o.expressionTree(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ExpressionPlan{
+		Before: orchestratorutils.ExplainTree(root),
+		After:  orchestratorutils.ExplainTree(orchestratorutils.Optimize(root)),
+	}, nil
+}
+
+// expressionTree строит дерево выражения с формулами пользователя и проверяет, что его можно посчитать в режиме expr.Mode.
+// Формулы раскрываются до сохранения выражения: подзадачи строятся из текущих версий формул,
+// поэтому последующие изменения формул не влияют на уже созданные выражения.
+// Ошибка разбора возвращается как есть (*ParseError), чтобы клиент получил ее позицию
+func (o *Orchestrator) expressionTree(ctx context.Context, expr *models.Expression) (orchestratorutils.Node, error) {
+	formulas, err := o.formulaRepository.GetFormulas(ctx, expr.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("error get formulas: %e", err)
+	}
+	return checkedTree(expr, formulas)
+}
+
+// checkedTree строит дерево выражения с формулами formulas и проверяет, что его можно посчитать в режиме expr.Mode
+func checkedTree(expr *models.Expression, formulas map[string]*models.Formula) (orchestratorutils.Node, error) {
+	root, err := orchestratorutils.ExpressionTree(expr, formulas)
+	if err != nil {
+		return nil, err
+	}
+	switch expr.Mode {
+	case models.ModeExact:
+		err = orchestratorutils.CheckExactMode(root)
+	case models.ModeComplex:
+		err = orchestratorutils.CheckComplexMode(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (o *Orchestrator) CreateFormula(ctx context.Context, definition, userId string) (*models.Formula, error) {
+	newFormula, err := orchestratorutils.ParseFormulaDefinition(definition)
+	if err != nil {
+		return nil, err
+	}
+	newFormula.UserId = userId
+	formulas, err := o.formulaRepository.GetFormulas(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error get formulas: %e", err)
+	}
+	if err := orchestratorutils.CheckFormula(newFormula, formulas); err != nil {
+		return nil, err
+	}
+	return o.formulaRepository.CreateFormula(ctx, newFormula)
+}
+
+func (o *Orchestrator) GetFormulas(ctx context.Context, userId string) ([]*models.Formula, error) {
+	formulas, err := o.formulaRepository.GetFormulas(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*models.Formula, 0, len(formulas))
+	for _, f := range formulas {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
 func (o *Orchestrator) GetExpressions(ctx context.Context, userId string) ([]*models.Expression, error) {
 	return o.expressionRepository.GetExpressions(ctx, userId)
 }
@@ -104,6 +450,14 @@ func (o *Orchestrator) GetExpressionByKey(ctx context.Context, key, userId strin
 	return o.expressionRepository.GetExpressionByKey(ctx, key, userId)
 }
 
+func (o *Orchestrator) GetExpressionAttempts(ctx context.Context, id, userId string) ([]*models.SubExpressionAttempt, error) {
+	if _, err := o.expressionRepository.GetExpressionById(ctx, id, userId); err != nil {
+		return nil, err
+	}
+	exprId, _ := uuid.Parse(id)
+	return o.subExpressionRepository.GetAttempts(ctx, exprId)
+}
+
 func (o *Orchestrator) UpdateExpressionState(ctx context.Context, key string, state models.ExpressionState) error {
 	return o.expressionRepository.UpdateState(ctx, key, state)
 }
@@ -146,35 +500,76 @@ func (o *Orchestrator) ReceiveCalculations(ctx context.Context) {
 		err = json.Unmarshal(task, expressionStruct)
 		if err != nil {
 			log.Printf("error unmarshal subexpression: %e", err)
-		}
-		if expressionStruct.Error {
-			err = o.subExpressionRepository.DeleteSubExpressionsByExpressionId(ctx, expressionStruct.ExpressionId)
-			if err != nil {
-				log.Printf("error delete subexpressions: %e", err)
-			}
-			err = o.expressionRepository.UpdateState(ctx, expressionStruct.ExpressionId.String(), models.ExpressionError)
-			if err != nil {
-				log.Printf("error update state: %e", err)
-			}
 			continue
 		}
-		err = o.subExpressionRepository.UpdateSubExpressions(ctx, expressionStruct)
+		// результат повторно отправленного subexpression может прийти от нескольких агентов, подставляется первый
+		completed, err := o.subExpressionRepository.CompleteLease(ctx, expressionStruct)
 		if err != nil {
-			log.Printf("error update subexpression: %e", err)
+			log.Printf("error complete lease: %e", err)
+			continue
 		}
-		if expressionStruct.IsLast {
-			err = o.expressionRepository.UpdateExpressionById(ctx, expressionStruct.ExpressionId, expressionStruct.Result)
-			if err != nil {
-				log.Printf("error update expression: %e", err)
-			}
-			err = o.subExpressionRepository.DeleteSubExpressionsByExpressionId(ctx, expressionStruct.ExpressionId)
+		// запоздавший или повторный результат прошлой попытки не освобождает место попытки, которая еще считается
+		if completed {
+			o.scheduler.done(expressionStruct.Id)
+		}
+		// результаты subexpressions отмененного выражения не подставляются, но сохраняются в кэш:
+		// они посчитаны верно и могут пригодиться другим выражениям
+		if !expressionStruct.Error && o.resultCacheRepository != nil {
+			err = o.resultCacheRepository.Set(ctx, resultCache.Key(expressionStruct), &models.CalculationResult{
+				Result:       expressionStruct.Result,
+				ResultExact:  expressionStruct.ResultExact,
+				ResultIm:     expressionStruct.ResultIm,
+				ResultMatrix: expressionStruct.ResultMatrix,
+			})
 			if err != nil {
-				log.Printf("error delete subexpressions: %e", err)
+				log.Printf("error cache result: %e", err)
 			}
 		}
+		if !completed {
+			continue
+		}
+		if _, ok := o.isInProgress(ctx, expressionStruct.ExpressionId); !ok {
+			continue
+		}
+		o.finishSubExpression(ctx, expressionStruct)
 	}
 
 }
+
+// finishSubExpression сохраняет результат подсчитанного subexpression и подставляет его в зависящие от него subexpressions,
+// а результат последнего subexpression - в выражение. При ошибке подсчета выражение завершается с ошибкой
+func (o *Orchestrator) finishSubExpression(ctx context.Context, expressionStruct *models.SubExpression) {
+	var err error
+	if expressionStruct.Error {
+		o.scheduler.releaseExpression(expressionStruct.ExpressionId)
+		// сначала выражение переводится в состояние ошибки, как в failExpression: удаление subexpressions уменьшает
+		// счетчики прогресса только считающихся выражений, и подписчики не получат уменьшившийся прогресс
+		err = o.expressionRepository.UpdateExpressionError(ctx, expressionStruct.ExpressionId, expressionStruct.ErrorReason)
+		if err != nil {
+			log.Printf("error update state: %e", err)
+		}
+		err = o.subExpressionRepository.DeleteSubExpressionsByExpressionId(ctx, expressionStruct.ExpressionId)
+		if err != nil {
+			log.Printf("error delete subexpressions: %e", err)
+		}
+		return
+	}
+	err = o.subExpressionRepository.UpdateSubExpressions(ctx, expressionStruct)
+	if err != nil {
+		log.Printf("error update subexpression: %e", err)
+	}
+	if expressionStruct.IsLast {
+		err = o.expressionRepository.UpdateExpressionById(ctx, expressionStruct.ExpressionId, expressionStruct.Result, expressionStruct.ResultExact, expressionStruct.ResultIm, expressionStruct.ResultMatrix)
+		if err != nil {
+			log.Printf("error update expression: %e", err)
+		}
+		err = o.subExpressionRepository.DeleteSubExpressionsByExpressionId(ctx, expressionStruct.ExpressionId)
+		if err != nil {
+			log.Printf("error delete subexpressions: %e", err)
+		}
+	}
+}
+
 func (o *Orchestrator) CreateAgentIfNotExists(id string) {
 	_ = o.agentRepository.CreateIfNotExistsAndUpdateHeartbeat(id)
 }
@@ -183,83 +578,267 @@ func (o *Orchestrator) GetAgents() ([]*models.Agent, error) {
 	return o.agentRepository.GetAgents()
 }
 
+// cachedResult завершает subexpression без обращения к агентам, если его результат уже есть в кэше
+func (o *Orchestrator) cachedResult(subExpr *models.SubExpression) bool {
+	if o.resultCacheRepository == nil {
+		return false
+	}
+	ctx := context.Background()
+	result, ok, err := o.resultCacheRepository.Get(ctx, resultCache.Key(subExpr))
+	if err != nil {
+		log.Printf("error get cached result: %e", err)
+		return false
+	}
+	if !ok {
+		o.resultCacheMisses.Add(1)
+		return false
+	}
+	o.resultCacheHits.Add(1)
+	subExpr.Result, subExpr.ResultExact, subExpr.ResultIm, subExpr.ResultMatrix = result.Result, result.ResultExact, result.ResultIm, result.ResultMatrix
+	o.finishSubExpression(ctx, subExpr)
+	return true
+}
+
+func (o *Orchestrator) GetResultCacheStats(ctx context.Context) (*models.ResultCacheStats, error) {
+	stats := &models.ResultCacheStats{Hits: o.resultCacheHits.Load(), Misses: o.resultCacheMisses.Load()}
+	if o.resultCacheRepository == nil {
+		return stats, nil
+	}
+	size, err := o.resultCacheRepository.Len(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error get result cache size: %e", err)
+	}
+	stats.Size = size
+	return stats, nil
+}
+
+// isInProgress проверяет, что выражение еще считается и его срок не истек: subexpressions отмененного
+// или просроченного выражения не отправляются агентам. Возвращает пользователя и срок выражения
+func (o *Orchestrator) isInProgress(ctx context.Context, expressionId uuid.UUID) (*models.ExpressionStatus, bool) {
+	status, err := o.expressionRepository.GetExpressionStatus(ctx, expressionId)
+	if err != nil {
+		log.Printf("error get expression state: %e", err)
+		return nil, false
+	}
+	return status, status.State == models.ExpressionInProgress && (status.Deadline == nil || time.Now().Before(*status.Deadline))
+}
+
+func (o *Orchestrator) CancelExpression(ctx context.Context, id, userId string) error {
+	if err := o.expressionRepository.CancelExpression(ctx, id, userId); err != nil {
+		return err
+	}
+	exprId, _ := uuid.Parse(id)
+	return o.stopExpression(ctx, exprId)
+}
+
+// stopExpression удаляет неподсчитанные subexpressions завершенного выражения
+// и сообщает агентам, чтобы они прервали подсчет уже взятых subexpressions
+func (o *Orchestrator) stopExpression(ctx context.Context, exprId uuid.UUID) error {
+	o.scheduler.releaseExpression(exprId)
+	if err := o.subExpressionRepository.DeleteSubExpressionsByExpressionId(ctx, exprId); err != nil {
+		return fmt.Errorf("error delete subexpressions: %e", err)
+	}
+	cancellation, err := json.Marshal(models.Cancellation{ExpressionId: exprId})
+	if err != nil {
+		return fmt.Errorf("error marshal cancellation: %e", err)
+	}
+	// соединение с exchange отмен открыто все время работы оркестратора, а запросы на отмену приходят параллельно
+	o.cancellationsMu.Lock()
+	defer o.cancellationsMu.Unlock()
+	if err := o.cancellationsQueueRepository.Publish(cancellation); err != nil {
+		return fmt.Errorf("error publish cancellation: %e", err)
+	}
+	return nil
+}
+
 func (o *Orchestrator) SendSubExpression() {
+	ctx := context.Background()
 	listener := o.subExpressionRepository.GetSubExpressions()
 	for subExpr := range listener {
-		err := o.expressionsQueueRepository.Connect()
+		o.schedule(ctx, subExpr)
+	}
+}
+
+// rescheduleReady при запуске оркестратора передает планировщику готовые subexpressions, которые до перезапуска
+// ждали отправки в его памяти: уведомления о них уже получены, и без этого они бы никогда не посчитались
+func (o *Orchestrator) rescheduleReady(ctx context.Context) {
+	ready, err := o.subExpressionRepository.GetReadySubExpressions(ctx)
+	if err != nil {
+		log.Printf("error get ready subexpressions: %e", err)
+		return
+	}
+	for _, subExpr := range ready {
+		o.schedule(ctx, subExpr)
+	}
+}
+
+// schedule передает subexpression планировщику, если его выражение еще считается, а результата нет в кэше
+func (o *Orchestrator) schedule(ctx context.Context, subExpr *models.SubExpression) {
+	status, ok := o.isInProgress(ctx, subExpr.ExpressionId)
+	if !ok || o.cachedResult(subExpr) {
+		return
+	}
+	subExpr.Deadline = status.Deadline
+	// квота читается, когда у пользователя появляются subexpressions, и действует, пока они не посчитаются
+	if o.scheduler.push(status.UserId, nil, subExpr) {
+		return
+	}
+	quota, err := o.userQuota(ctx, status.UserId)
+	if err != nil {
+		log.Printf("error get quota: %e", err)
+		quota = &models.Quota{UserId: status.UserId, MaxInFlight: o.quotas.MaxInFlightPerUser, Weight: o.quotas.Weight}
+	}
+	o.scheduler.push(status.UserId, quota, subExpr)
+}
+
+func (o *Orchestrator) DispatchSubExpressions() {
+	err := o.expressionsQueueRepository.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to queue: %v", err)
+	}
+	defer o.expressionsQueueRepository.Close()
+
+	for {
+		subExpr := o.scheduler.next()
+		// аренда выдается до отправки: если subexpression потеряется в очереди, он будет отправлен повторно
+		attempt, err := o.subExpressionRepository.StartLease(context.Background(), subExpr.Id, o.leases.DispatchTTL)
 		if err != nil {
-			log.Printf("")
+			log.Printf("error start lease: %e", err)
+		}
+		if attempt == 0 {
+			// subexpression уже посчитан, удален или аренда не выдана: результата этой отправки не будет
+			o.scheduler.done(subExpr.Id)
+			continue
 		}
+		subExpr.Attempt = attempt
 		expressionJson, err := json.Marshal(subExpr)
 		if err != nil {
-			log.Printf("")
+			log.Printf("error marshal subexpression: %e", err)
+			o.scheduler.done(subExpr.Id)
+			continue
 		}
-		err = o.expressionsQueueRepository.Publish(expressionJson)
+		err = o.expressionsQueueRepository.PublishWithPriority(expressionJson, o.dispatchPriority(subExpr))
 		if err != nil {
-			log.Printf("")
+			log.Printf("error publish subexpression: %e", err)
+			// результат не придет, место subexpression в ограничениях пользователя освобождается
+			o.scheduler.done(subExpr.Id)
 		}
-		o.expressionsQueueRepository.Close()
 	}
 }
-func (o *Orchestrator) ReceiveRPCTasks(ctx context.Context) {
-	err := o.rpcQueueRepository.Connect()
+
+// dispatchPriority возвращает приоритет subexpression в очереди: приоритет выражения, повышенный на 1
+// за каждые priorityAging ожидания, чтобы subexpressions выражений с низким приоритетом не ждали бесконечно
+func (o *Orchestrator) dispatchPriority(subExpr *models.SubExpression) uint8 {
+	priority := subExpr.Priority
+	if o.priorityAging > 0 && !subExpr.CreatedAt.IsZero() {
+		priority += int(time.Since(subExpr.CreatedAt) / o.priorityAging)
+	}
+	return uint8(max(0, min(priority, models.PriorityMax)))
+}
+
+func (o *Orchestrator) ReceiveLeaseRenewals(ctx context.Context) {
+	err := o.leaseQueueRepository.Connect()
 	if err != nil {
 		log.Fatalf("Failed to connect to queue: %v", err)
 	}
-	defer o.rpcQueueRepository.Close()
+	defer o.leaseQueueRepository.Close()
 
-	rpcTasks, err := o.rpcQueueRepository.Consume()
+	renewals, err := o.leaseQueueRepository.Consume()
 	if err != nil {
 		log.Printf("Failed to consume tasks from queue: %v", err)
 	}
-	for rpc := range rpcTasks {
-		rpcAnswer := models.RPCAnswer{}
-		err = json.Unmarshal(rpc, &rpcAnswer)
+	for renewal := range renewals {
+		lease := &models.Lease{}
+		err = json.Unmarshal(renewal, lease)
 		if err != nil {
-			log.Printf("Failed to decode rpc answer: %v", err)
+			log.Printf("Failed to decode lease: %v", err)
 			continue
 		}
-		o.subExpressionRepository.UpdateSubExpressionAgent(ctx, rpcAnswer.IdSubExpression, rpcAnswer.IdAgent)
+		// продление аренды прошлой попытки игнорируется: subexpression уже отправлен повторно
+		err = o.subExpressionRepository.RenewLease(ctx, lease, o.leases.TTL)
+		if err != nil {
+			log.Printf("error renew lease: %e", err)
+		}
 	}
 }
 
 func (o *Orchestrator) RetrySubExpressions(ctx context.Context) {
 	ticker := time.NewTicker(time.Second)
 	for _ = range ticker.C {
-		agents, _ := o.agentRepository.GetAgents()
-		for _, agent := range agents {
-			timeAgent := time.Unix(agent.Heartbeat, 0)
-			// Если от агента не поступает ответа в течение retrySubExpressionTimout
-			if time.Now().Add(-o.retrySubExpressionTimout).After(timeAgent) {
-				agentId, _ := uuid.Parse(agent.Id)
-				// получаем все невыполненные subexpression этого агента
-				tempExpressions, err := o.subExpressionRepository.GetNotCalculatedSubExpressionsByAgentId(ctx, agentId)
-				if err != nil {
-					log.Printf("err get sub expressions by agent id %e", err)
-					continue
-				}
-				for _, expr := range tempExpressions {
-					oldId := expr.Id
-					// удаляем subexpression
-					err = o.subExpressionRepository.DeleteSubExpressionById(ctx, expr.Id)
-					if err != nil {
-						log.Printf("err delete sub expressions by agent id %e", err)
-						continue
-					}
-					// создаем новый
-					newExpr, err := o.subExpressionRepository.CreateSubExpression(ctx, expr)
-					if err != nil {
-						log.Printf("error create sub expressions %e", err)
-						continue
-					}
-					// меняем у зависимых от удаленного выражения sub_expression на новый
-					err = o.subExpressionRepository.ReplaceExpressionsIds(ctx, oldId, newExpr.Id)
-					if err != nil {
-						log.Printf("err delete sub expressions by agent id %e", err)
-						continue
-					}
-				}
+		o.expireExpressions(ctx)
+		// агент, взявший subexpression, умер или subexpression потерялся в очереди: отправляем его повторно
+		// с тем же id, поэтому зависящие от него subexpressions не меняются
+		expired, err := o.subExpressionRepository.ExpireLeases(ctx)
+		if err != nil {
+			log.Printf("error expire leases: %e", err)
+		}
+		for _, subExpr := range expired {
+			o.scheduler.done(subExpr.Id)
+			if o.retries.MaxAttempts > 0 && subExpr.Attempt >= o.retries.MaxAttempts {
+				o.failExpression(ctx, subExpr)
+				continue
 			}
+			// время повторной отправки хранится в бд, поэтому отправка не теряется при перезапуске оркестратора
+			delay := o.retryDelay(subExpr.Attempt)
+			log.Printf("lease of subexpression %s (attempt %d) is expired, retry in %v", subExpr.Id, subExpr.Attempt, delay)
+			if err := o.subExpressionRepository.DelayRetry(ctx, subExpr.Id, delay); err != nil {
+				log.Printf("error delay retry: %e", err)
+			}
+		}
+		retries, err := o.subExpressionRepository.TakeRetries(ctx)
+		if err != nil {
+			log.Printf("error take retries: %e", err)
+			continue
+		}
+		for _, subExpr := range retries {
+			o.schedule(ctx, subExpr)
+		}
+	}
+}
+
+// retryDelay возвращает задержку перед отправкой subexpression, попытка attempt которого не удалась:
+// Backoff*2^(attempt-1), но не больше MaxBackoff (0 - без ограничения), со случайным отклонением на долю Jitter
+func (o *Orchestrator) retryDelay(attempt int) time.Duration {
+	delay := o.retries.Backoff
+	for i := 1; i < attempt; i++ {
+		if o.retries.MaxBackoff > 0 && delay >= o.retries.MaxBackoff || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if o.retries.MaxBackoff > 0 && delay > o.retries.MaxBackoff {
+		delay = o.retries.MaxBackoff
+	}
+	if o.retries.Jitter > 0 {
+		delay += time.Duration(float64(delay) * o.retries.Jitter * (2*rand.Float64() - 1))
+	}
+	return max(0, delay)
+}
+
+// failExpression завершает с ошибкой выражение, subexpression которого исчерпал попытки: агенты, взявшие его,
+// умирают или не успевают посчитать его за срок аренды, поэтому дальнейшие попытки бесполезны
+func (o *Orchestrator) failExpression(ctx context.Context, subExpr *models.SubExpression) {
+	reason := fmt.Sprintf("subexpression %s was not calculated after %d attempts: agent lease expired", subExpr.Id, subExpr.Attempt)
+	log.Printf("expression %s failed: %s", subExpr.ExpressionId, reason)
+	if err := o.expressionRepository.UpdateExpressionError(ctx, subExpr.ExpressionId, reason); err != nil {
+		log.Printf("error update state: %e", err)
+		return
+	}
+	if err := o.stopExpression(ctx, subExpr.ExpressionId); err != nil {
+		log.Printf("error stop failed expression: %e", err)
+	}
+}
+
+// expireExpressions переводит выражения с истекшим сроком в статус ExpressionTimedOut и останавливает их подсчет
+func (o *Orchestrator) expireExpressions(ctx context.Context) {
+	ids, err := o.expressionRepository.ExpireExpressions(ctx)
+	if err != nil {
+		log.Printf("error expire expressions: %e", err)
+		return
+	}
+	for _, id := range ids {
+		if err := o.stopExpression(ctx, id); err != nil {
+			log.Printf("error stop expired expression: %e", err)
 		}
 	}
 }
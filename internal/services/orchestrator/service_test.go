@@ -0,0 +1,200 @@
+package orchestrator
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/config"
+	"myproject/internal/models"
+	"myproject/internal/repositories"
+	"myproject/internal/repositories/expression"
+	"myproject/internal/repositories/formula"
+	"myproject/internal/repositories/quota"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchPriority(t *testing.T) {
+	o := &Orchestrator{priorityAging: time.Minute}
+	tests := []struct {
+		name     string
+		subExpr  *models.SubExpression
+		expected uint8
+	}{
+		{
+			name:     "новое subexpression",
+			subExpr:  &models.SubExpression{Priority: 2, CreatedAt: time.Now()},
+			expected: 2,
+		},
+		{
+			name:     "ожидает 3 минуты",
+			subExpr:  &models.SubExpression{Priority: 2, CreatedAt: time.Now().Add(-3*time.Minute - time.Second)},
+			expected: 5,
+		},
+		{
+			name:     "приоритет не выше наибольшего",
+			subExpr:  &models.SubExpression{Priority: 8, CreatedAt: time.Now().Add(-time.Hour)},
+			expected: models.PriorityMax,
+		},
+		{
+			name:     "время создания неизвестно",
+			subExpr:  &models.SubExpression{Priority: 1},
+			expected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.dispatchPriority(tt.subExpr); got != tt.expected {
+				t.Errorf("dispatchPriority() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackoff time.Duration
+		attempt    int
+		expected   time.Duration
+	}{
+		{
+			name:       "первая попытка",
+			maxBackoff: 10 * time.Second,
+			attempt:    1,
+			expected:   time.Second,
+		},
+		{
+			name:       "задержка удваивается",
+			maxBackoff: 10 * time.Second,
+			attempt:    3,
+			expected:   4 * time.Second,
+		},
+		{
+			name:       "задержка не больше max_backoff",
+			maxBackoff: 10 * time.Second,
+			attempt:    50,
+			expected:   10 * time.Second,
+		},
+		{
+			name:       "без max_backoff задержка удваивается",
+			maxBackoff: 0,
+			attempt:    5,
+			expected:   16 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Orchestrator{retries: config.RetriesConfig{Backoff: time.Second, MaxBackoff: tt.maxBackoff}}
+			if got := o.retryDelay(tt.attempt); got != tt.expected {
+				t.Errorf("retryDelay() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	o := &Orchestrator{retries: config.RetriesConfig{Backoff: time.Second, MaxBackoff: 10 * time.Second, Jitter: 0.2}}
+	for i := 0; i < 100; i++ {
+		if got := o.retryDelay(3); got < 3200*time.Millisecond || got > 4800*time.Millisecond {
+			t.Fatalf("retryDelay() with jitter = %v, want within 20%% of 4s", got)
+		}
+	}
+}
+
+// quotaExpressions хранит количество созданных выражений и, как бд, проверяет квоту при создании
+type quotaExpressions struct {
+	expression.Repository
+	mu      sync.Mutex
+	created int
+}
+
+func (r *quotaExpressions) CreateExpression(_ context.Context, expr *models.Expression, dailyLimit int) (*models.Expression, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if dailyLimit > 0 && r.created >= dailyLimit {
+		return nil, repositories.ErrQuotaExceeded
+	}
+	r.created++
+	expr.Id = uuid.NewString()
+	return expr, nil
+}
+
+func (r *quotaExpressions) CreateExpressions(_ context.Context, expressions []*models.Expression, _ []*models.SubExpression, dailyLimit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := len(expressions)
+	if dailyLimit > 0 {
+		saved = min(saved, max(0, dailyLimit-r.created))
+	}
+	r.created += saved
+	return saved, nil
+}
+
+func (r *quotaExpressions) UpdateExpressionById(context.Context, uuid.UUID, float64, string, float64, *models.Matrix) error {
+	return nil
+}
+
+func (r *quotaExpressions) GetExpressionIdsByKeys(context.Context, []string, string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+type noFormulas struct {
+	formula.Repository
+}
+
+func (noFormulas) GetFormulas(context.Context, string) (map[string]*models.Formula, error) {
+	return nil, nil
+}
+
+type dailyQuota struct {
+	quota.Repository
+	dailyExpressions int
+}
+
+func (q dailyQuota) GetQuota(_ context.Context, userId string) (*models.Quota, error) {
+	return &models.Quota{UserId: userId, DailyExpressions: q.dailyExpressions}, nil
+}
+
+func TestCreateExpressionQuota(t *testing.T) {
+	expressions := &quotaExpressions{}
+	o := &Orchestrator{expressionRepository: expressions, formulaRepository: noFormulas{}, quotaRepository: dailyQuota{dailyExpressions: 2}}
+
+	// параллельные вызовы не превышают квоту: она проверяется при сохранении выражения
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i], _ = o.CreateExpression(context.Background(), "2", uuid.NewString(), "1", nil, models.ModeFloat, nil, models.PriorityDefault)
+		}(i)
+	}
+	wg.Wait()
+	exceeded := 0
+	for _, err := range errs {
+		if err != nil {
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+			exceeded++
+		}
+	}
+	assert.Equal(t, 3, exceeded)
+	assert.Equal(t, 2, expressions.created)
+}
+
+func TestCreateExpressionsQuota(t *testing.T) {
+	expressions := &quotaExpressions{created: 1}
+	o := &Orchestrator{expressionRepository: expressions, formulaRepository: noFormulas{}, quotaRepository: dailyQuota{dailyExpressions: 2}}
+
+	results, err := o.CreateExpressions(context.Background(), []*models.ExpressionBatchItem{
+		{Expression: "1", IdempotencyKey: "a"},
+		{Expression: "2", IdempotencyKey: "b"},
+		{Expression: "2", IdempotencyKey: "b"},
+	}, "1")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, results[0].ExpressionId)
+	assert.NoError(t, results[0].Error)
+	for _, result := range results[1:] {
+		assert.Empty(t, result.ExpressionId)
+		assert.ErrorIs(t, result.Error, ErrQuotaExceeded)
+	}
+}
@@ -0,0 +1,149 @@
+package orchestratorutils
+
+import "myproject/internal/models"
+
+// Node узел дерева разбора выражения
+type Node interface {
+	// Column позиция узла в исходном выражении (нумерация с 1)
+	Column() int
+}
+
+// NumberNode числовой литерал
+type NumberNode struct {
+	Value float64
+	// Literal запись числа в исходном выражении (для подставленной переменной - ее значение).
+	// Используется как точное значение в режиме ModeExact
+	Literal string
+	Col     int
+	// Dim размерность числа с единицей измерения (5km), значение Value переведено в единицы СИ
+	Dim Dimension
+	// Imag мнимая часть числа (4i), допустима только в режиме ModeComplex
+	Imag float64
+}
+
+// VariableNode именованная переменная, значение которой передается вместе с выражением
+type VariableNode struct {
+	Name string
+	Col  int
+}
+
+// UnaryNode унарная операция ("neg", "pos", "not")
+type UnaryNode struct {
+	Op      string
+	Operand Node
+	Col     int
+}
+
+// BinaryNode бинарная операция ("+", "-", "*", "/", "%", "//", "^", сравнения, "&&", "||")
+type BinaryNode struct {
+	Op    string
+	Left  Node
+	Right Node
+	Col   int
+	// Token оператор исходного выражения, из которого построен узел (см. lowerConditions), пустая строка - Op
+	Token string
+}
+
+// CallNode вызов встроенной функции (sqrt, max, ...) или сохраненной формулы пользователя
+type CallNode struct {
+	Name string
+	Args []Node
+	Col  int
+	// Token оператор исходного выражения, из которого построен узел (см. lowerConditions), пустая строка - Name
+	Token string
+}
+
+// ListNode список в квадратных скобках [a, b, ...], заменяется на MatrixNode в ExpressionTree
+type ListNode struct {
+	Items []Node
+	Col   int
+}
+
+// MatrixNode матрица или вектор из чисел, известных при создании выражения
+type MatrixNode struct {
+	Value models.Matrix
+	Col   int
+}
+
+func (n *NumberNode) Column() int   { return n.Col }
+func (n *VariableNode) Column() int { return n.Col }
+func (n *UnaryNode) Column() int    { return n.Col }
+func (n *BinaryNode) Column() int   { return n.Col }
+func (n *CallNode) Column() int     { return n.Col }
+func (n *ListNode) Column() int     { return n.Col }
+func (n *MatrixNode) Column() int   { return n.Col }
+
+// token лексема узла для сообщений об ошибках
+func (n *BinaryNode) token() string {
+	if n.Token != "" {
+		return n.Token
+	}
+	return n.Op
+}
+
+// token лексема узла для сообщений об ошибках
+func (n *CallNode) token() string {
+	if n.Token != "" {
+		return n.Token
+	}
+	return n.Name
+}
+
+// rewrite строит копию дерева снизу вверх: сначала переписываются потомки, затем к узлу применяется fn.
+// Неизмененные поддеревья переиспользуются, исходное дерево не изменяется
+func rewrite(node Node, fn func(Node) (Node, error)) (Node, error) {
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand, err := rewrite(n.Operand, fn)
+		if err != nil {
+			return nil, err
+		}
+		if operand != n.Operand {
+			node = &UnaryNode{Op: n.Op, Operand: operand, Col: n.Col}
+		}
+	case *BinaryNode:
+		left, err := rewrite(n.Left, fn)
+		if err != nil {
+			return nil, err
+		}
+		right, err := rewrite(n.Right, fn)
+		if err != nil {
+			return nil, err
+		}
+		if left != n.Left || right != n.Right {
+			node = &BinaryNode{Op: n.Op, Left: left, Right: right, Col: n.Col, Token: n.Token}
+		}
+	case *CallNode:
+		args, changed, err := rewriteAll(n.Args, fn)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			node = &CallNode{Name: n.Name, Args: args, Col: n.Col, Token: n.Token}
+		}
+	case *ListNode:
+		items, changed, err := rewriteAll(n.Items, fn)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			node = &ListNode{Items: items, Col: n.Col}
+		}
+	}
+	return fn(node)
+}
+
+// rewriteAll применяет rewrite к каждому узлу и сообщает, изменился ли хотя бы один из них
+func rewriteAll(nodes []Node, fn func(Node) (Node, error)) ([]Node, bool, error) {
+	result := make([]Node, len(nodes))
+	changed := false
+	for i, node := range nodes {
+		newNode, err := rewrite(node, fn)
+		if err != nil {
+			return nil, false, err
+		}
+		result[i] = newNode
+		changed = changed || newNode != node
+	}
+	return result, changed, nil
+}
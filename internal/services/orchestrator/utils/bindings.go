@@ -0,0 +1,93 @@
+package orchestratorutils
+
+import (
+	"strconv"
+
+	"myproject/internal/lib/lru"
+	"myproject/internal/models"
+)
+
+// parsedFormulas кэш деревьев разбора. Одна и та же формула часто приходит с разными значениями переменных,
+// поэтому разбирается один раз. Деревья из кэша не изменяются: Bind строит новое дерево
+var parsedFormulas = lru.New[string, Node](1024)
+
+// ParseCached разбирает выражение как Parse, но переиспользует дерево ранее встречавшейся формулы
+func ParseCached(expression string) (Node, error) {
+	if root, ok := parsedFormulas.Get(expression); ok {
+		return root, nil
+	}
+	root, err := Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	parsedFormulas.Add(expression, root)
+	return root, nil
+}
+
+// ExpressionTree возвращает дерево разбора выражения, в котором вызовы формул пользователя formulas
+// раскрыты, переменные заменены значениями, списки - матрицами, а && и || заменены на if (см. lowerConditions).
+// Использованные версии формул записываются в expr.FormulaVersions, единица измерения результата - в expr.ResultUnit
+func ExpressionTree(expr *models.Expression, formulas map[string]*models.Formula) (Node, error) {
+	root, err := ParseCached(expr.Value)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]int)
+	root, err = ExpandFormulas(root, formulas, used)
+	if err != nil {
+		return nil, err
+	}
+	if len(used) > 0 {
+		expr.FormulaVersions = used
+	}
+	root, err = Bind(root, expr.Bindings)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Mode != models.ModeComplex {
+		if err := checkRealNumbers(root); err != nil {
+			return nil, err
+		}
+	}
+	root, err = buildMatrices(root, expr.Mode)
+	if err != nil {
+		return nil, err
+	}
+	root = lowerConditions(root)
+	if _, err := inferShape(root); err != nil {
+		return nil, err
+	}
+	dim, err := inferDimension(root)
+	if err != nil {
+		return nil, err
+	}
+	expr.ResultUnit = dim.String()
+	return root, nil
+}
+
+// Bind подставляет значения переменных и возвращает новое дерево, исходное дерево не изменяется.
+// Для переменной без значения возвращает *ParseError с ее позицией
+func Bind(node Node, bindings map[string]float64) (Node, error) {
+	return rewrite(node, func(node Node) (Node, error) {
+		variable, ok := node.(*VariableNode)
+		if !ok {
+			return node, nil
+		}
+		value, ok := bindings[variable.Name]
+		if !ok {
+			return nil, &ParseError{Token: variable.Name, Column: variable.Col, Message: "unbound variable"}
+		}
+		return &NumberNode{Value: value, Literal: strconv.FormatFloat(value, 'f', -1, 64), Col: variable.Col}, nil
+	})
+}
+
+// checkRealNumbers проверяет, что в выражении нет мнимых чисел: они допустимы только в режиме ModeComplex
+func checkRealNumbers(root Node) error {
+	_, err := rewrite(root, func(node Node) (Node, error) {
+		if number, ok := node.(*NumberNode); ok && number.Imag != 0 {
+			return nil, &ParseError{Token: number.Literal, Column: number.Col, Message: "imaginary numbers require complex mode"}
+		}
+		return node, nil
+	})
+	return err
+}
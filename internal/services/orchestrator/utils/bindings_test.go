@@ -0,0 +1,85 @@
+package orchestratorutils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		bindings   map[string]float64
+		want       []float64
+	}{
+		{
+			name:       "price * qty * (1 + tax)",
+			expression: "price * qty * (1 + tax)",
+			bindings:   map[string]float64{"price": 10, "qty": 3, "tax": 0.2},
+			want:       []float64{10, 3, 1, 0.2},
+		},
+		{
+			name:       "max(a, -b, 2)",
+			expression: "max(a, -b, 2)",
+			bindings:   map[string]float64{"a": 1, "b": 5, "unused": 7},
+			want:       []float64{1, 5, 2},
+		},
+		{
+			name:       "без переменных",
+			expression: "1 + 2",
+			bindings:   nil,
+			want:       []float64{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ParseCached(tt.expression)
+			assert.NoError(t, err)
+			before := InfixToPostfix(tt.expression)
+
+			bound, err := Bind(root, tt.bindings)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, numbers(bound))
+
+			// дерево из кэша не должно измениться
+			again, err := ParseCached(tt.expression)
+			assert.NoError(t, err)
+			assert.Same(t, root, again)
+			assert.Equal(t, before, InfixToPostfix(tt.expression))
+		})
+	}
+}
+
+func TestBindUnboundVariable(t *testing.T) {
+	root, err := Parse("price * qty")
+	assert.NoError(t, err)
+
+	_, err = Bind(root, map[string]float64{"price": 1})
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "qty", parseErr.Token)
+	assert.Equal(t, 9, parseErr.Column)
+	assert.True(t, strings.Contains(parseErr.Message, "unbound"))
+}
+
+// numbers возвращает числа дерева в порядке обхода слева направо
+func numbers(node Node) []float64 {
+	switch n := node.(type) {
+	case *NumberNode:
+		return []float64{n.Value}
+	case *UnaryNode:
+		return numbers(n.Operand)
+	case *BinaryNode:
+		return append(numbers(n.Left), numbers(n.Right)...)
+	case *CallNode:
+		var result []float64
+		for _, arg := range n.Args {
+			result = append(result, numbers(arg)...)
+		}
+		return result
+	}
+	return nil
+}
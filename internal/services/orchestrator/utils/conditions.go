@@ -0,0 +1,54 @@
+package orchestratorutils
+
+// lowerConditions готовит условия к разбиению на подзадачи:
+//   - a && b заменяется на if(a, b != 0, 0), a || b - на if(a, 1, b != 0), поэтому правый операнд
+//     считается только при необходимости, как и ветки if. Ошибки в новых узлах сообщаются с исходным оператором;
+//   - if с уже известным условием (число или подставленная переменная) заменяется выбранной веткой.
+func lowerConditions(root Node) Node {
+	lowered, _ := rewrite(root, func(node Node) (Node, error) {
+		switch n := node.(type) {
+		case *BinaryNode:
+			switch n.Op {
+			case "&&":
+				node = &CallNode{Name: "if", Args: []Node{n.Left, truth(n), boolNode(false, n.Col)}, Col: n.Col, Token: n.Op}
+			case "||":
+				node = &CallNode{Name: "if", Args: []Node{n.Left, boolNode(true, n.Col), truth(n)}, Col: n.Col, Token: n.Op}
+			default:
+				return node, nil
+			}
+		case *CallNode:
+			if n.Name != "if" {
+				return node, nil
+			}
+		default:
+			return node, nil
+		}
+		return selectConstantBranch(node.(*CallNode)), nil
+	})
+	return lowered
+}
+
+// selectConstantBranch возвращает ветку if, если условие известно до подсчета, иначе сам if
+func selectConstantBranch(call *CallNode) Node {
+	cond, ok := call.Args[0].(*NumberNode)
+	if !ok {
+		return call
+	}
+	if cond.Value != 0 || cond.Imag != 0 {
+		return call.Args[1]
+	}
+	return call.Args[2]
+}
+
+// truth приводит правый операнд логического оператора op к 0 или 1: op.Right != 0
+func truth(op *BinaryNode) Node {
+	return &BinaryNode{Op: "!=", Left: op.Right, Right: boolNode(false, op.Col), Col: op.Col, Token: op.Op}
+}
+
+// boolNode число 1 для true и 0 для false
+func boolNode(value bool, column int) *NumberNode {
+	if value {
+		return &NumberNode{Value: 1, Literal: "1", Col: column}
+	}
+	return &NumberNode{Value: 0, Literal: "0", Col: column}
+}
@@ -0,0 +1,75 @@
+package orchestratorutils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestExpressionTreeConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		bindings   map[string]float64
+		want       string
+	}{
+		{
+			name:       "&& считает правый операнд только при истинном левом",
+			expression: "a > 1 && b",
+			bindings:   map[string]float64{"a": 2, "b": 3},
+			want:       "if(>(2, 1), !=(3, 0), 0)",
+		},
+		{
+			name:       "|| считает правый операнд только при ложном левом",
+			expression: "a > 1 || b < 0",
+			bindings:   map[string]float64{"a": 2, "b": 3},
+			want:       "if(>(2, 1), 1, !=(<(3, 0), 0))",
+		},
+		{
+			name:       "известное условие выбирает ветку сразу",
+			expression: "vip ? price * 0.9 : price",
+			bindings:   map[string]float64{"vip": 1, "price": 100},
+			want:       "*(100, 0.9)",
+		},
+		{
+			name:       "if(0, 1/0, 5) не делит на ноль",
+			expression: "if(0, 1/0, 5)",
+			want:       "5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ExpressionTree(&models.Expression{Value: tt.expression, Bindings: tt.bindings}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, treeString(root))
+		})
+	}
+}
+
+// treeString записывает дерево в префиксной форме: op(arg1, arg2)
+func treeString(node Node) string {
+	switch n := node.(type) {
+	case *NumberNode:
+		return n.Literal
+	case *VariableNode:
+		return n.Name
+	case *MatrixNode:
+		return fmt.Sprint(n.Value.Data)
+	case *UnaryNode:
+		return n.Op + "(" + treeString(n.Operand) + ")"
+	case *BinaryNode:
+		return n.Op + "(" + treeString(n.Left) + ", " + treeString(n.Right) + ")"
+	case *CallNode:
+		result := n.Name + "("
+		for i, arg := range n.Args {
+			if i > 0 {
+				result += ", "
+			}
+			result += treeString(arg)
+		}
+		return result + ")"
+	}
+	return ""
+}
@@ -0,0 +1,202 @@
+package orchestratorutils
+
+import (
+	"myproject/internal/models"
+	"slices"
+	"strings"
+)
+
+// ParseFormulaDefinition разбирает определение формулы вида "vat(x) = x * 1.2".
+// В теле формулы допускаются только ее параметры, встроенные функции и вызовы других формул.
+// При ошибке возвращает *ParseError с позицией в исходном определении
+func ParseFormulaDefinition(definition string) (*models.Formula, error) {
+	tokens, err := Tokenize(definition)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	name := p.next()
+	if name.Kind != TokenIdentifier {
+		return nil, unexpectedToken(name)
+	}
+	if _, ok := functions[name.Text]; ok {
+		return nil, &ParseError{Token: name.Text, Column: name.Column, Message: "formula name conflicts with built-in function"}
+	}
+	if tok := p.next(); tok.Kind != TokenLeftParen {
+		return nil, unexpectedToken(tok)
+	}
+	var params []string
+	if p.peek().Kind != TokenRightParen {
+		for {
+			param := p.next()
+			if param.Kind != TokenIdentifier {
+				return nil, unexpectedToken(param)
+			}
+			if _, ok := constants[param.Text]; ok {
+				return nil, &ParseError{Token: param.Text, Column: param.Column, Message: "parameter name conflicts with constant"}
+			}
+			if slices.Contains(params, param.Text) {
+				return nil, &ParseError{Token: param.Text, Column: param.Column, Message: "duplicate parameter"}
+			}
+			params = append(params, param.Text)
+			if p.peek().Kind != TokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if tok := p.next(); tok.Kind != TokenRightParen {
+		return nil, unexpectedToken(tok)
+	}
+	assign := p.next()
+	if assign.Kind != TokenAssign {
+		return nil, unexpectedToken(assign)
+	}
+
+	// тело разбирается отдельно, позиции ошибок сдвигаются на начало тела в определении
+	offset := assign.Column
+	body := string([]rune(definition)[offset:])
+	root, err := Parse(body)
+	if err != nil {
+		if parseErr, ok := err.(*ParseError); ok {
+			return nil, &ParseError{Token: parseErr.Token, Column: parseErr.Column + offset, Message: parseErr.Message}
+		}
+		return nil, err
+	}
+	_, err = rewrite(root, func(node Node) (Node, error) {
+		if variable, ok := node.(*VariableNode); ok && !slices.Contains(params, variable.Name) {
+			return nil, &ParseError{Token: variable.Name, Column: variable.Col + offset, Message: "unknown parameter"}
+		}
+		return node, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Formula{Name: name.Text, Params: params, Body: strings.TrimSpace(body)}, nil
+}
+
+// CheckFormula проверяет, что формулу можно раскрыть вместе с остальными формулами пользователя formulas:
+// все вызываемые в ней формулы существуют, вызываются с нужным числом аргументов и не образуют рекурсию
+func CheckFormula(formula *models.Formula, formulas map[string]*models.Formula) error {
+	withFormula := make(map[string]*models.Formula, len(formulas)+1)
+	for name, f := range formulas {
+		withFormula[name] = f
+	}
+	withFormula[formula.Name] = formula
+
+	call := &CallNode{Name: formula.Name, Col: 1}
+	for _, param := range formula.Params {
+		call.Args = append(call.Args, &VariableNode{Name: param, Col: 1})
+	}
+	_, err := ExpandFormulas(call, withFormula, make(map[string]int))
+	return err
+}
+
+// ExpandFormulas раскрывает вызовы формул пользователя: тело формулы подставляется вместо вызова,
+// а параметры заменяются аргументами. Версии раскрытых формул записываются в used.
+// Для неизвестной функции, неверного числа аргументов или рекурсии возвращает *ParseError
+func ExpandFormulas(node Node, formulas map[string]*models.Formula, used map[string]int) (Node, error) {
+	return expandFormulas(node, formulas, nil, used)
+}
+
+// expandFormulas раскрывает формулы, expanding - цепочка формул, внутри которых находится node
+func expandFormulas(node Node, formulas map[string]*models.Formula, expanding []string, used map[string]int) (Node, error) {
+	return rewrite(node, func(node Node) (Node, error) {
+		call, ok := node.(*CallNode)
+		if !ok {
+			return node, nil
+		}
+		if _, ok := functions[call.Name]; ok {
+			return node, nil
+		}
+		formula, ok := formulas[call.Name]
+		if !ok {
+			return nil, &ParseError{Token: call.Name, Column: call.Col, Message: "unknown function"}
+		}
+		if slices.Contains(expanding, call.Name) {
+			return nil, &ParseError{Token: call.Name, Column: call.Col, Message: "recursive formula"}
+		}
+		if len(call.Args) != len(formula.Params) {
+			return nil, wrongNumberOfArguments(call.Name, call.Col, len(call.Args))
+		}
+
+		body, err := ParseCached(formula.Body)
+		if err != nil {
+			return nil, formulaError(call, err)
+		}
+		args := make(map[string]Node, len(call.Args))
+		for i, param := range formula.Params {
+			args[param] = call.Args[i]
+		}
+		inlined, err := rewrite(body, func(node Node) (Node, error) {
+			variable, ok := node.(*VariableNode)
+			if !ok {
+				return relocate(node, call.Col), nil
+			}
+			arg, ok := args[variable.Name]
+			if !ok {
+				return nil, &ParseError{Token: variable.Name, Column: call.Col, Message: "unknown parameter"}
+			}
+			return arg, nil
+		})
+		if err != nil {
+			return nil, formulaError(call, err)
+		}
+		used[formula.Name] = formula.Version
+
+		// аргументы уже раскрыты, поэтому в подставленном теле остаются только вызовы из самой формулы
+		expanded, err := expandFormulas(inlined, formulas, append(slices.Clone(expanding), call.Name), used)
+		if err != nil {
+			return nil, formulaError(call, err)
+		}
+		return expanded, nil
+	})
+}
+
+// formulaError переносит ошибку из тела формулы на ее вызов call: позиция в теле формулы не относится
+// к выражению пользователя. Для вложенных формул указывается формула, вызванная в выражении
+func formulaError(call *CallNode, err error) error {
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		return err
+	}
+	return &ParseError{Token: parseErr.Token, Column: call.Col, Message: parseErr.Message, Formula: call.Name}
+}
+
+// relocate возвращает копию узла из тела формулы с позицией ее вызова col, чтобы ошибки, найденные
+// после раскрытия формул (единицы измерения, размеры матриц), указывали на вызов
+func relocate(node Node, col int) Node {
+	switch n := node.(type) {
+	case *NumberNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	case *VariableNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	case *UnaryNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	case *BinaryNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	case *CallNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	case *ListNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	case *MatrixNode:
+		relocated := *n
+		relocated.Col = col
+		return &relocated
+	}
+	return node
+}
@@ -0,0 +1,178 @@
+package orchestratorutils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestParseFormulaDefinition(t *testing.T) {
+	formula, err := ParseFormulaDefinition("vat(x) = x * 1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "vat", formula.Name)
+	assert.Equal(t, []string{"x"}, formula.Params)
+	assert.Equal(t, "x * 1.2", formula.Body)
+
+	formula, err = ParseFormulaDefinition("hyp(a, b) = sqrt(a^2 + b^2)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, formula.Params)
+}
+
+func TestParseFormulaDefinitionError(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantToken  string
+		wantColumn int
+	}{
+		{
+			name:       "без тела",
+			definition: "vat(x) =",
+			wantToken:  "",
+			wantColumn: 9,
+		},
+		{
+			name:       "без знака равенства",
+			definition: "vat(x) x * 1.2",
+			wantToken:  "x",
+			wantColumn: 8,
+		},
+		{
+			name:       "неизвестный параметр",
+			definition: "vat(x) = y * 1.2",
+			wantToken:  "y",
+			wantColumn: 10,
+		},
+		{
+			name:       "повторяющийся параметр",
+			definition: "f(x, x) = x",
+			wantToken:  "x",
+			wantColumn: 6,
+		},
+		{
+			name:       "имя встроенной функции",
+			definition: "sqrt(x) = x",
+			wantToken:  "sqrt",
+			wantColumn: 1,
+		},
+		{
+			name:       "ошибка в теле",
+			definition: "f(x) = x +* 2",
+			wantToken:  "*",
+			wantColumn: 11,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFormulaDefinition(tt.definition)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseFormulaDefinition() error = %v, want *ParseError", err)
+			}
+			assert.Equal(t, tt.wantToken, parseErr.Token)
+			assert.Equal(t, tt.wantColumn, parseErr.Column)
+		})
+	}
+}
+
+func TestExpressionTreeWithFormulas(t *testing.T) {
+	formulas := map[string]*models.Formula{
+		"vat":   {Name: "vat", Params: []string{"x"}, Body: "x * 1.2", Version: 2},
+		"total": {Name: "total", Params: []string{"price", "qty"}, Body: "vat(price * qty)", Version: 1},
+	}
+	expr := &models.Expression{Value: "total(p, 3) + vat(100) + 5", Bindings: map[string]float64{"p": 10}}
+
+	root, err := ExpressionTree(expr, formulas)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{10, 3, 1.2, 100, 1.2, 5}, numbers(root))
+	assert.Equal(t, map[string]int{"vat": 2, "total": 1}, expr.FormulaVersions)
+
+	// формулы раскрываются в новом дереве, дерево из кэша по-прежнему содержит вызовы
+	cached, err := ParseCached(expr.Value)
+	assert.NoError(t, err)
+	assert.Equal(t, "p 3 total 100 vat + 5 +", InfixToPostfix(expr.Value))
+	assert.IsType(t, &BinaryNode{}, cached)
+}
+
+func TestExpandFormulasError(t *testing.T) {
+	formulas := map[string]*models.Formula{
+		"vat": {Name: "vat", Params: []string{"x"}, Body: "x * 1.2"},
+	}
+	tests := []struct {
+		name        string
+		expression  string
+		wantToken   string
+		wantMessage string
+	}{
+		{
+			name:        "неизвестная формула",
+			expression:  "2 + foo(1)",
+			wantToken:   "foo",
+			wantMessage: "unknown function",
+		},
+		{
+			name:        "неверное число аргументов",
+			expression:  "vat(1, 2)",
+			wantToken:   "vat",
+			wantMessage: "wrong number of arguments (2) for function",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExpressionTree(&models.Expression{Value: tt.expression}, formulas)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ExpressionTree() error = %v, want *ParseError", err)
+			}
+			assert.Equal(t, tt.wantToken, parseErr.Token)
+			assert.Equal(t, tt.wantMessage, parseErr.Message)
+		})
+	}
+}
+
+func TestExpandFormulasErrorInBody(t *testing.T) {
+	formulas := map[string]*models.Formula{
+		"net":   {Name: "net", Params: []string{"x"}, Body: "x - fee(x)"},
+		"gross": {Name: "gross", Params: []string{"x"}, Body: "net(x) * 1.2"},
+		"dist":  {Name: "dist", Params: []string{"x"}, Body: "x + 1s"},
+	}
+	_, err := ExpressionTree(&models.Expression{Value: "1 + gross(2)"}, formulas)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ExpressionTree() error = %v, want *ParseError", err)
+	}
+	assert.Equal(t, "fee", parseErr.Token)
+	assert.Equal(t, 5, parseErr.Column)
+	assert.Equal(t, "gross", parseErr.Formula)
+	assert.Equal(t, `unknown function "fee" in formula "gross" at column 5`, parseErr.Error())
+
+	// ошибка единиц измерения находится после раскрытия и указывает на вызов формулы
+	_, err = ExpressionTree(&models.Expression{Value: "2 * dist(3m)"}, formulas)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ExpressionTree() error = %v, want *ParseError", err)
+	}
+	assert.Equal(t, 5, parseErr.Column)
+}
+
+func TestCheckFormulaRecursion(t *testing.T) {
+	formulas := map[string]*models.Formula{
+		"f": {Name: "f", Params: []string{"x"}, Body: "g(x) + 1"},
+	}
+	g, err := ParseFormulaDefinition("g(x) = f(x) * 2")
+	assert.NoError(t, err)
+
+	err = CheckFormula(g, formulas)
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "recursive formula", parseErr.Message)
+
+	self, err := ParseFormulaDefinition("h(x) = h(x - 1)")
+	assert.NoError(t, err)
+	assert.Error(t, CheckFormula(self, nil))
+
+	ok, err := ParseFormulaDefinition("g(x) = x * 2")
+	assert.NoError(t, err)
+	assert.NoError(t, CheckFormula(ok, formulas))
+}
@@ -0,0 +1,78 @@
+package orchestratorutils
+
+import "slices"
+
+// Function встроенная функция калькулятора
+type Function struct {
+	Name    string
+	MinArgs int
+	// MaxArgs максимальное количество аргументов, -1 - без ограничений
+	MaxArgs int
+	// Exact функция считается в точном режиме без округления (ModeExact)
+	Exact bool
+	// Complex функция определена для комплексных чисел (ModeComplex)
+	Complex bool
+	// Matrix аргументы функции - векторы и матрицы
+	Matrix bool
+}
+
+// functions встроенные функции: каждая считается агентом как отдельная операция
+var functions = map[string]Function{
+	"sqrt":  {Name: "sqrt", MinArgs: 1, MaxArgs: 1, Complex: true},
+	"abs":   {Name: "abs", MinArgs: 1, MaxArgs: 1, Exact: true, Complex: true},
+	"min":   {Name: "min", MinArgs: 1, MaxArgs: -1, Exact: true},
+	"max":   {Name: "max", MinArgs: 1, MaxArgs: -1, Exact: true},
+	"round": {Name: "round", MinArgs: 1, MaxArgs: 2, Exact: true},
+	"log":   {Name: "log", MinArgs: 1, MaxArgs: 2, Complex: true},
+	"sin":   {Name: "sin", MinArgs: 1, MaxArgs: 1, Complex: true},
+	"cos":   {Name: "cos", MinArgs: 1, MaxArgs: 1, Complex: true},
+	// if(cond, a, b) возвращает a, если cond не равно нулю, иначе b. Считается только выбранная ветка
+	"if": {Name: "if", MinArgs: 3, MaxArgs: 3, Exact: true, Complex: true},
+	// dot(u, v) скалярное произведение векторов, matmul(a, b) - произведение матриц
+	"dot":       {Name: "dot", MinArgs: 2, MaxArgs: 2, Matrix: true},
+	"matmul":    {Name: "matmul", MinArgs: 2, MaxArgs: 2, Matrix: true},
+	"transpose": {Name: "transpose", MinArgs: 1, MaxArgs: 1, Matrix: true},
+}
+
+// complexOperators операции, определенные для комплексных чисел: упорядочивания (<, >) и остатка от деления нет
+var complexOperators = []string{"+", "-", "*", "/", "^", "==", "!=", "neg", "pos", "not"}
+
+// acceptsArgs проверяет, можно ли вызвать функцию с count аргументами
+func (f Function) acceptsArgs(count int) bool {
+	return count >= f.MinArgs && (f.MaxArgs == -1 || count <= f.MaxArgs)
+}
+
+// CheckExactMode проверяет, что выражение можно посчитать в точном режиме: функции с иррациональным
+// результатом (sqrt, log, sin, cos) в нем не поддерживаются. Возвращает *ParseError с позицией вызова
+func CheckExactMode(root Node) error {
+	_, err := rewrite(root, func(node Node) (Node, error) {
+		if call, ok := node.(*CallNode); ok && !functions[call.Name].Exact {
+			return nil, &ParseError{Token: call.Name, Column: call.Col, Message: "function is not supported in exact mode"}
+		}
+		return node, nil
+	})
+	return err
+}
+
+// CheckComplexMode проверяет, что выражение можно посчитать в комплексных числах: сравнения <, <=, >, >=,
+// операции %, // и функции min, max, round для них не определены. Возвращает *ParseError с позицией операции
+func CheckComplexMode(root Node) error {
+	_, err := rewrite(root, func(node Node) (Node, error) {
+		switch n := node.(type) {
+		case *UnaryNode:
+			if !slices.Contains(complexOperators, n.Op) {
+				return nil, &ParseError{Token: n.Op, Column: n.Col, Message: "operator is not supported in complex mode"}
+			}
+		case *BinaryNode:
+			if !slices.Contains(complexOperators, n.Op) {
+				return nil, &ParseError{Token: n.Op, Column: n.Col, Message: "operator is not supported in complex mode"}
+			}
+		case *CallNode:
+			if !functions[n.Name].Complex {
+				return nil, &ParseError{Token: n.Name, Column: n.Col, Message: "function is not supported in complex mode"}
+			}
+		}
+		return node, nil
+	})
+	return err
+}
@@ -0,0 +1,62 @@
+package orchestratorutils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestCheckExactMode(t *testing.T) {
+	root, err := Parse("round(0.1 + 0.2, 1) * max(1, 2) ^ 2")
+	assert.NoError(t, err)
+	assert.NoError(t, CheckExactMode(root))
+
+	root, err = Parse("1 + sqrt(2)")
+	assert.NoError(t, err)
+	err = CheckExactMode(root)
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "sqrt", parseErr.Token)
+	assert.Equal(t, 5, parseErr.Column)
+}
+
+func TestCheckComplexMode(t *testing.T) {
+	root, err := Parse("sqrt(-4) * (3+4i) ^ 2 - abs(1i) / 2")
+	assert.NoError(t, err)
+	assert.NoError(t, CheckComplexMode(root))
+
+	tests := []struct {
+		expression string
+		token      string
+		column     int
+	}{
+		{expression: "1i < 2", token: "<", column: 4},
+		{expression: "5 % 2i", token: "%", column: 3},
+		{expression: "1 + max(1, 2i)", token: "max", column: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			root, err := Parse(tt.expression)
+			assert.NoError(t, err)
+			err = CheckComplexMode(root)
+			var parseErr *ParseError
+			assert.True(t, errors.As(err, &parseErr))
+			assert.Equal(t, tt.token, parseErr.Token)
+			assert.Equal(t, tt.column, parseErr.Column)
+		})
+	}
+}
+
+func TestExpressionTreeImaginary(t *testing.T) {
+	root, err := ExpressionTree(&models.Expression{Value: "3 + 4i", Mode: models.ModeComplex}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &NumberNode{Imag: 4, Literal: "4i", Col: 5}, root.(*BinaryNode).Right)
+
+	_, err = ExpressionTree(&models.Expression{Value: "3 + 4i"}, nil)
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "4i", parseErr.Token)
+	assert.Equal(t, 5, parseErr.Column)
+}
@@ -9,10 +9,32 @@ import (
 // GetOperators возвращает список операция
 func GetOperators(timeouts config.CalculationTimeoutsConfig) []*models.Operator {
 	operatorsMap := map[string]time.Duration{
-		"+": timeouts.TimeCalculatePlus / time.Second,
-		"-": timeouts.TimeCalculateMinus / time.Second,
-		"*": timeouts.TimeCalculateMult / time.Second,
-		"/": timeouts.TimeCalculateDivide / time.Second,
+		"+":         timeouts.TimeCalculatePlus / time.Second,
+		"-":         timeouts.TimeCalculateMinus / time.Second,
+		"*":         timeouts.TimeCalculateMult / time.Second,
+		"/":         timeouts.TimeCalculateDivide / time.Second,
+		"^":         timeouts.TimeCalculatePow / time.Second,
+		"%":         timeouts.TimeCalculateMod / time.Second,
+		"//":        timeouts.TimeCalculateIntDivide / time.Second,
+		"sqrt":      timeouts.TimeCalculateSqrt / time.Second,
+		"abs":       timeouts.TimeCalculateAbs / time.Second,
+		"min":       timeouts.TimeCalculateMin / time.Second,
+		"max":       timeouts.TimeCalculateMax / time.Second,
+		"round":     timeouts.TimeCalculateRound / time.Second,
+		"log":       timeouts.TimeCalculateLog / time.Second,
+		"sin":       timeouts.TimeCalculateSin / time.Second,
+		"cos":       timeouts.TimeCalculateCos / time.Second,
+		"<":         timeouts.TimeCalculateComparison / time.Second,
+		"<=":        timeouts.TimeCalculateComparison / time.Second,
+		">":         timeouts.TimeCalculateComparison / time.Second,
+		">=":        timeouts.TimeCalculateComparison / time.Second,
+		"==":        timeouts.TimeCalculateComparison / time.Second,
+		"!=":        timeouts.TimeCalculateComparison / time.Second,
+		"!":         timeouts.TimeCalculateLogical / time.Second,
+		"if":        timeouts.TimeCalculateIf / time.Second,
+		"dot":       timeouts.TimeCalculateMatrix / time.Second,
+		"matmul":    timeouts.TimeCalculateMatrix / time.Second,
+		"transpose": timeouts.TimeCalculateMatrix / time.Second,
 	}
 	var operators []*models.Operator
 	for key, value := range operatorsMap {
@@ -0,0 +1,166 @@
+package orchestratorutils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	TokenOperator
+	TokenLeftParen
+	TokenRightParen
+	TokenIdentifier
+	TokenComma
+	// TokenAssign знак "=" в определении формулы
+	TokenAssign
+	// TokenQuestion и TokenColon части тернарного оператора cond ? a : b
+	TokenQuestion
+	TokenColon
+	// TokenLeftBracket и TokenRightBracket скобки списка (вектора или матрицы): [1, 2], [[1, 2], [3, 4]]
+	TokenLeftBracket
+	TokenRightBracket
+)
+
+// twoCharOperators операторы из двух символов, проверяются раньше односимвольных
+var twoCharOperators = []string{"**", "//", "<=", ">=", "==", "!=", "&&", "||"}
+
+// Token лексема выражения
+type Token struct {
+	Kind TokenKind
+	Text string
+	// Column позиция первого символа лексемы в выражении (нумерация с 1)
+	Column int
+}
+
+// ParseError ошибка разбора выражения с указанием места ошибки
+type ParseError struct {
+	// Token лексема, на которой произошла ошибка (пустая строка - конец выражения)
+	Token string
+	// Column позиция лексемы в выражении (нумерация с 1)
+	Column  int
+	Message string
+	// Formula формула пользователя, в теле которой произошла ошибка. Column в этом случае - позиция ее вызова
+	Formula string
+}
+
+func (e *ParseError) Error() string {
+	message := e.Message
+	if e.Token != "" {
+		message += fmt.Sprintf(" %q", e.Token)
+	}
+	if e.Formula != "" {
+		message += fmt.Sprintf(" in formula %q", e.Formula)
+	}
+	return fmt.Sprintf("%s at column %d", message, e.Column)
+}
+
+// Tokenize разбивает выражение на лексемы, последней всегда идет TokenEOF
+func Tokenize(expression string) ([]Token, error) {
+	runes := []rune(expression)
+	var tokens []Token
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isDigit(r) || r == '.':
+			end, err := scanNumber(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: string(runes[i:end]), Column: i + 1})
+			i = end
+		case i+1 < len(runes) && slices.Contains(twoCharOperators, string(runes[i:i+2])):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(runes[i : i+2]), Column: i + 1})
+			i += 2
+		case strings.ContainsRune("+-*/%^<>!", r):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(r), Column: i + 1})
+			i++
+		case isLetter(r):
+			end := i
+			for end < len(runes) && (isLetter(runes[end]) || isDigit(runes[end])) {
+				end++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdentifier, Text: string(runes[i:end]), Column: i + 1})
+			i = end
+		case r == ',':
+			tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Column: i + 1})
+			i++
+		case r == '?':
+			tokens = append(tokens, Token{Kind: TokenQuestion, Text: "?", Column: i + 1})
+			i++
+		case r == ':':
+			tokens = append(tokens, Token{Kind: TokenColon, Text: ":", Column: i + 1})
+			i++
+		case r == '=':
+			tokens = append(tokens, Token{Kind: TokenAssign, Text: "=", Column: i + 1})
+			i++
+		case r == '(':
+			tokens = append(tokens, Token{Kind: TokenLeftParen, Text: "(", Column: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, Token{Kind: TokenRightParen, Text: ")", Column: i + 1})
+			i++
+		case r == '[':
+			tokens = append(tokens, Token{Kind: TokenLeftBracket, Text: "[", Column: i + 1})
+			i++
+		case r == ']':
+			tokens = append(tokens, Token{Kind: TokenRightBracket, Text: "]", Column: i + 1})
+			i++
+		default:
+			return nil, &ParseError{Token: string(r), Column: i + 1, Message: "unexpected character"}
+		}
+	}
+
+	return append(tokens, Token{Kind: TokenEOF, Column: len(runes) + 1}), nil
+}
+
+// scanNumber считывает число, начинающееся с позиции start, и возвращает позицию после него.
+// Число может быть целым (12), дробным (1.5, .5, 1.) и в экспоненциальной записи (2.5e-3)
+func scanNumber(runes []rune, start int) (int, error) {
+	i := start
+	digits := 0
+	for i < len(runes) && isDigit(runes[i]) {
+		i++
+		digits++
+	}
+	if i < len(runes) && runes[i] == '.' {
+		i++
+		for i < len(runes) && isDigit(runes[i]) {
+			i++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0, &ParseError{Token: string(runes[start:i]), Column: start + 1, Message: "malformed number"}
+	}
+	if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+		i++
+		if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+			i++
+		}
+		exponentStart := i
+		for i < len(runes) && isDigit(runes[i]) {
+			i++
+		}
+		if i == exponentStart {
+			return 0, &ParseError{Token: string(runes[start:i]), Column: start + 1, Message: "malformed exponent in number"}
+		}
+	}
+	return i, nil
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isLetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_'
+}
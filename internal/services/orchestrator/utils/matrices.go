@@ -0,0 +1,188 @@
+package orchestratorutils
+
+import (
+	"myproject/internal/models"
+	"strconv"
+)
+
+// matmulBlockSize наибольшее количество строк и столбцов результата в одном блоке произведения матриц:
+// matmul больших матриц разбивается на блоки, которые разные агенты считают параллельно
+const matmulBlockSize = 64
+
+// shape размер значения узла, у числа rows = 0
+type shape struct {
+	rows, cols int
+}
+
+func (s shape) isMatrix() bool {
+	return s.rows > 0
+}
+
+func (s shape) isVector() bool {
+	return s.rows == 1 || s.cols == 1
+}
+
+func (s shape) String() string {
+	return strconv.Itoa(s.rows) + "x" + strconv.Itoa(s.cols)
+}
+
+// buildMatrices заменяет списки чисел на MatrixNode: [1, 2, 3] - вектор из одной строки,
+// [[1, 2], [3, 4]] - матрица 2x2. Матрицы поддерживаются только в режиме ModeFloat
+func buildMatrices(root Node, mode models.ExpressionMode) (Node, error) {
+	return rewrite(root, func(node Node) (Node, error) {
+		list, ok := node.(*ListNode)
+		if !ok {
+			return node, nil
+		}
+		if mode != "" && mode != models.ModeFloat {
+			return nil, &ParseError{Token: "[", Column: list.Col, Message: "matrices are supported only in float mode"}
+		}
+		if _, ok := list.Items[0].(*MatrixNode); ok {
+			return stackRows(list)
+		}
+		data := make([]float64, len(list.Items))
+		for i, item := range list.Items {
+			value, err := matrixElement(item)
+			if err != nil {
+				return nil, err
+			}
+			data[i] = value
+		}
+		return &MatrixNode{Value: models.Matrix{Rows: 1, Cols: len(data), Data: data}, Col: list.Col}, nil
+	})
+}
+
+// stackRows собирает матрицу из строк, каждая из которых уже заменена на MatrixNode из одной строки
+func stackRows(list *ListNode) (Node, error) {
+	cols := list.Items[0].(*MatrixNode).Value.Cols
+	data := make([]float64, 0, len(list.Items)*cols)
+	for _, item := range list.Items {
+		row, ok := item.(*MatrixNode)
+		if !ok || row.Value.Rows != 1 {
+			return nil, &ParseError{Column: item.Column(), Message: "matrix rows must be lists of numbers"}
+		}
+		if row.Value.Cols != cols {
+			return nil, &ParseError{Column: item.Column(), Message: "matrix rows must have the same length"}
+		}
+		data = append(data, row.Value.Data...)
+	}
+	return &MatrixNode{Value: models.Matrix{Rows: len(list.Items), Cols: cols, Data: data}, Col: list.Col}, nil
+}
+
+// matrixElement возвращает значение элемента матрицы: числа без единиц измерения, в том числе со знаком (-1)
+func matrixElement(node Node) (float64, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		if n.Dim != (Dimension{}) {
+			return 0, &ParseError{Column: n.Col, Message: "units are not supported in matrices"}
+		}
+		return n.Value, nil
+	case *UnaryNode:
+		value, err := matrixElement(n.Operand)
+		switch n.Op {
+		case "neg":
+			return -value, err
+		case "pos":
+			return value, err
+		}
+	}
+	return 0, &ParseError{Column: node.Column(), Message: "matrix elements must be numbers"}
+}
+
+// inferShape проверяет размеры матриц в выражении и возвращает размер его значения.
+// С матрицами определены + - * / (поэлементно, число применяется к каждому элементу), унарные минус и плюс,
+// dot, matmul и transpose
+func inferShape(node Node) (shape, error) {
+	switch n := node.(type) {
+	case *MatrixNode:
+		return shape{rows: n.Value.Rows, cols: n.Value.Cols}, nil
+	case *UnaryNode:
+		operand, err := inferShape(n.Operand)
+		if err != nil {
+			return shape{}, err
+		}
+		if operand.isMatrix() && n.Op == "not" {
+			return shape{}, &ParseError{Token: n.Op, Column: n.Col, Message: "operator is not supported for matrices"}
+		}
+		return operand, nil
+	case *BinaryNode:
+		left, err := inferShape(n.Left)
+		if err != nil {
+			return shape{}, err
+		}
+		right, err := inferShape(n.Right)
+		if err != nil {
+			return shape{}, err
+		}
+		if !left.isMatrix() && !right.isMatrix() {
+			return shape{}, nil
+		}
+		switch n.Op {
+		case "+", "-", "*", "/":
+			if left.isMatrix() && right.isMatrix() && left != right {
+				return shape{}, mismatchedShapes(n.token(), n.Col, left, right)
+			}
+			if left.isMatrix() {
+				return left, nil
+			}
+			return right, nil
+		}
+		return shape{}, &ParseError{Token: n.token(), Column: n.Col, Message: "operator is not supported for matrices"}
+	case *CallNode:
+		return callShape(n)
+	}
+	return shape{}, nil
+}
+
+func callShape(n *CallNode) (shape, error) {
+	shapes := make([]shape, len(n.Args))
+	for i, arg := range n.Args {
+		argShape, err := inferShape(arg)
+		if err != nil {
+			return shape{}, err
+		}
+		shapes[i] = argShape
+	}
+	if !functions[n.Name].Matrix {
+		for _, argShape := range shapes {
+			if argShape.isMatrix() {
+				return shape{}, &ParseError{Token: n.token(), Column: n.Col, Message: "function is not supported for matrices"}
+			}
+		}
+		return shape{}, nil
+	}
+	for _, argShape := range shapes {
+		if !argShape.isMatrix() {
+			return shape{}, &ParseError{Token: n.Name, Column: n.Col, Message: "arguments must be matrices"}
+		}
+	}
+	switch n.Name {
+	case "dot":
+		a, b := shapes[0], shapes[1]
+		if !a.isVector() || !b.isVector() || a.rows*a.cols != b.rows*b.cols {
+			return shape{}, mismatchedShapes(n.Name, n.Col, a, b)
+		}
+		return shape{}, nil
+	case "matmul":
+		a, b := shapes[0], shapes[1]
+		if a.cols != b.rows {
+			return shape{}, mismatchedShapes(n.Name, n.Col, a, b)
+		}
+		return shape{rows: a.rows, cols: b.cols}, nil
+	}
+	// transpose
+	return shape{rows: shapes[0].cols, cols: shapes[0].rows}, nil
+}
+
+func mismatchedShapes(op string, column int, left, right shape) *ParseError {
+	return &ParseError{Token: op, Column: column, Message: "matrix sizes " + left.String() + " and " + right.String() + " do not match"}
+}
+
+// blockRanges делит n строк или столбцов на отрезки [from, to) длиной не больше matmulBlockSize
+func blockRanges(n int) [][2]int {
+	var ranges [][2]int
+	for from := 0; from < n; from += matmulBlockSize {
+		ranges = append(ranges, [2]int{from, min(from+matmulBlockSize, n)})
+	}
+	return ranges
+}
@@ -0,0 +1,131 @@
+package orchestratorutils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestExpressionTreeMatrices(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		bindings   map[string]float64
+		want       models.Matrix
+	}{
+		{
+			name:       "вектор",
+			expression: "[1, -2, x]",
+			bindings:   map[string]float64{"x": 3},
+			want:       models.Matrix{Rows: 1, Cols: 3, Data: []float64{1, -2, 3}},
+		},
+		{
+			name:       "матрица",
+			expression: "[[1, 2], [3, 4], [5, 6]]",
+			want:       models.Matrix{Rows: 3, Cols: 2, Data: []float64{1, 2, 3, 4, 5, 6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ExpressionTree(&models.Expression{Value: tt.expression, Bindings: tt.bindings}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, &MatrixNode{Value: tt.want, Col: 1}, root)
+		})
+	}
+}
+
+func TestInferShape(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       shape
+	}{
+		{expression: "[1, 2] * 2 + [3, 4]", want: shape{rows: 1, cols: 2}},
+		{expression: "dot([1, 2, 3], transpose([1, 2, 3]))", want: shape{}},
+		{expression: "matmul([[1, 2, 3], [4, 5, 6]], transpose([[1, 2, 3]]))", want: shape{rows: 2, cols: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			root, err := ExpressionTree(&models.Expression{Value: tt.expression}, nil)
+			assert.NoError(t, err)
+			got, err := inferShape(root)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpressionTreeMatricesError(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		mode       models.ExpressionMode
+		wantToken  string
+		wantColumn int
+	}{
+		{
+			name:       "строки разной длины",
+			expression: "[[1, 2], [3]]",
+			wantColumn: 10,
+		},
+		{
+			name:       "элемент - выражение",
+			expression: "[1, 1 + 1]",
+			wantColumn: 7,
+		},
+		{
+			name:       "единицы измерения",
+			expression: "[1km, 2]",
+			wantColumn: 2,
+		},
+		{
+			name:       "размеры не совпадают",
+			expression: "[1, 2] + [1, 2, 3]",
+			wantToken:  "+",
+			wantColumn: 8,
+		},
+		{
+			name:       "matmul 1x2 и 1x2",
+			expression: "matmul([1, 2], [3, 4])",
+			wantToken:  "matmul",
+			wantColumn: 1,
+		},
+		{
+			name:       "сравнение матриц",
+			expression: "[1, 2] < 3",
+			wantToken:  "<",
+			wantColumn: 8,
+		},
+		{
+			name:       "sqrt от матрицы",
+			expression: "sqrt([4, 9])",
+			wantToken:  "sqrt",
+			wantColumn: 1,
+		},
+		{
+			name:       "точный режим",
+			expression: "[1, 2] * 2",
+			mode:       models.ModeExact,
+			wantToken:  "[",
+			wantColumn: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExpressionTree(&models.Expression{Value: tt.expression, Mode: tt.mode}, nil)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ExpressionTree() error = %v, want *ParseError", err)
+			}
+			assert.Equal(t, tt.wantToken, parseErr.Token)
+			assert.Equal(t, tt.wantColumn, parseErr.Column)
+		})
+	}
+}
+
+func TestBlockRanges(t *testing.T) {
+	assert.Equal(t, [][2]int{{0, 10}}, blockRanges(10))
+	assert.Equal(t, [][2]int{{0, matmulBlockSize}, {matmulBlockSize, 2 * matmulBlockSize}, {2 * matmulBlockSize, 2*matmulBlockSize + 1}},
+		blockRanges(2*matmulBlockSize+1))
+}
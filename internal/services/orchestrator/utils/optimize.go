@@ -0,0 +1,189 @@
+package orchestratorutils
+
+import (
+	"math/big"
+	"strings"
+)
+
+// Optimize упрощает дерево перед разбиением на подзадачи, чтобы уменьшить количество обращений к агентам:
+//   - сложение, вычитание и умножение двух действительных чисел заменяются их результатом: значение
+//     считается так же, как у агента, а запись для точного режима - без потери точности;
+//   - x*1, 1*x, x/1, x+0, 0+x, x-0 и x^1 заменяются на x, а x*0 и 0*x - на 0, если x - число
+//     (не матрица) и при его подсчете не может возникнуть ошибка (см. isSafeScalar);
+//   - унарный плюс и двойное отрицание убираются, отрицание числа заменяется отрицательным числом,
+//     а if с условием, ставшим числом, - выбранной веткой;
+//   - цепочки сложений и умножений (a+b+c+d) перестраиваются в сбалансированное дерево ((a+b)+(c+d)),
+//     ветки которого агенты считают параллельно.
+//
+// Остальные операции над числами не сворачиваются: их по-прежнему считают агенты
+func Optimize(root Node) Node {
+	optimized, _ := rewrite(root, func(node Node) (Node, error) {
+		switch n := node.(type) {
+		case *UnaryNode:
+			return simplifyUnary(n), nil
+		case *BinaryNode:
+			if folded := foldNumbers(n); folded != nil {
+				return folded, nil
+			}
+			if simplified := simplifyBinary(n); simplified != nil {
+				return simplified, nil
+			}
+			if n.Op == "+" || n.Op == "*" {
+				return balance(n), nil
+			}
+		case *CallNode:
+			if n.Name == "if" {
+				return selectConstantBranch(n), nil
+			}
+		}
+		return node, nil
+	})
+	return optimized
+}
+
+func simplifyUnary(n *UnaryNode) Node {
+	switch n.Op {
+	case "pos":
+		return n.Operand
+	case "neg":
+		if inner, ok := n.Operand.(*UnaryNode); ok && inner.Op == "neg" {
+			return inner.Operand
+		}
+		if number, ok := n.Operand.(*NumberNode); ok {
+			return &NumberNode{Value: -number.Value, Imag: -number.Imag, Literal: negateLiteral(number.Literal), Col: n.Col, Dim: number.Dim}
+		}
+	}
+	return n
+}
+
+// foldNumbers считает сложение, вычитание или умножение двух действительных чисел, возвращает nil для
+// остальных узлов. Единицы измерения уже проверены, поэтому у слагаемых одна размерность
+func foldNumbers(n *BinaryNode) Node {
+	if n.Op != "+" && n.Op != "-" && n.Op != "*" {
+		return nil
+	}
+	left, ok := n.Left.(*NumberNode)
+	if !ok || left.Imag != 0 {
+		return nil
+	}
+	right, ok := n.Right.(*NumberNode)
+	if !ok || right.Imag != 0 {
+		return nil
+	}
+	exactLeft, ok := new(big.Rat).SetString(left.Literal)
+	if !ok {
+		return nil
+	}
+	exactRight, ok := new(big.Rat).SetString(right.Literal)
+	if !ok {
+		return nil
+	}
+	folded := &NumberNode{Col: n.Col, Dim: left.Dim}
+	exact := new(big.Rat)
+	switch n.Op {
+	case "+":
+		folded.Value = left.Value + right.Value
+		exact.Add(exactLeft, exactRight)
+	case "-":
+		folded.Value = left.Value - right.Value
+		exact.Sub(exactLeft, exactRight)
+	case "*":
+		folded.Value = left.Value * right.Value
+		exact.Mul(exactLeft, exactRight)
+		folded.Dim = left.Dim.add(right.Dim, 1)
+	}
+	folded.Literal = decimalString(exact)
+	return folded
+}
+
+// simplifyBinary применяет тождества с 0 и 1, возвращает nil, если ни одно не подходит
+func simplifyBinary(n *BinaryNode) Node {
+	switch {
+	case n.Op == "+" && isNumber(n.Left, 0):
+		return n.Right
+	case (n.Op == "+" || n.Op == "-") && isNumber(n.Right, 0):
+		return n.Left
+	case n.Op == "*" && isNumber(n.Left, 1):
+		return n.Right
+	case (n.Op == "*" || n.Op == "/" || n.Op == "^") && isNumber(n.Right, 1):
+		return n.Left
+	case n.Op == "*" && isNumber(n.Left, 0) && isSafeScalar(n.Right):
+		return n.Left
+	case n.Op == "*" && isNumber(n.Right, 0) && isSafeScalar(n.Left):
+		return n.Right
+	}
+	return nil
+}
+
+// isNumber проверяет, что узел - действительное число value
+func isNumber(node Node, value float64) bool {
+	number, ok := node.(*NumberNode)
+	return ok && number.Value == value && number.Imag == 0
+}
+
+// safeOperations операции, при подсчете которых агент не возвращает ошибку ни в одном режиме
+var safeOperations = map[string]struct{}{
+	"+": {}, "-": {}, "*": {}, "neg": {}, "pos": {}, "not": {}, "abs": {}, "min": {}, "max": {}, "if": {},
+	"<": {}, "<=": {}, ">": {}, ">=": {}, "==": {}, "!=": {},
+}
+
+// isSafeScalar проверяет, что значение узла - число, при подсчете которого не может возникнуть ошибка,
+// поэтому умножение на 0 можно заменить нулем, не скрыв от пользователя деление на ноль или неверный
+// аргумент round. Допускаются только операции из safeOperations
+func isSafeScalar(node Node) bool {
+	if valueShape, err := inferShape(node); err != nil || valueShape.isMatrix() {
+		return false
+	}
+	safe := true
+	_, _ = rewrite(node, func(node Node) (Node, error) {
+		var operation string
+		switch n := node.(type) {
+		case *UnaryNode:
+			operation = n.Op
+		case *BinaryNode:
+			operation = n.Op
+		case *CallNode:
+			operation = n.Name
+		default:
+			return node, nil
+		}
+		_, ok := safeOperations[operation]
+		safe = safe && ok
+		return node, nil
+	})
+	return safe
+}
+
+// negateLiteral записывает число с противоположным знаком: "2.5" -> "-2.5", "-2.5" -> "2.5"
+func negateLiteral(literal string) string {
+	if strings.HasPrefix(literal, "-") {
+		return literal[1:]
+	}
+	return "-" + literal
+}
+
+// balance перестраивает цепочку одинаковых операций с корнем n в сбалансированное дерево.
+// Операнды не переставляются, а левое поддерево не меньше правого, поэтому a+b+c остается (a+b)+c
+func balance(n *BinaryNode) Node {
+	operands := chainOperands(n, n.Op, nil)
+	if len(operands) < 4 {
+		return n
+	}
+	return balancedChain(n.Op, operands, n.Col)
+}
+
+func chainOperands(node Node, op string, operands []Node) []Node {
+	if n, ok := node.(*BinaryNode); ok && n.Op == op {
+		operands = chainOperands(n.Left, op, operands)
+		return chainOperands(n.Right, op, operands)
+	}
+	return append(operands, node)
+}
+
+func balancedChain(op string, operands []Node, column int) Node {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	middle := (len(operands) + 1) / 2
+	return &BinaryNode{Op: op, Left: balancedChain(op, operands[:middle], column), Right: balancedChain(op, operands[middle:], column), Col: column}
+}
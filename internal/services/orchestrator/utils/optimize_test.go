@@ -0,0 +1,111 @@
+package orchestratorutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		bindings   map[string]float64
+		want       string
+	}{
+		{
+			name:       "x*1 + 0",
+			expression: "sqrt(x) * 1 + 0",
+			bindings:   map[string]float64{"x": 4},
+			want:       "sqrt(4)",
+		},
+		{
+			name:       "x*0 без ошибок",
+			expression: "(a + b) * 0 + 2",
+			bindings:   map[string]float64{"a": 1, "b": 2},
+			want:       "2",
+		},
+		{
+			name:       "x*0 с делением остается",
+			expression: "1 / a * 0",
+			bindings:   map[string]float64{"a": 0},
+			want:       "*(/(1, 0), 0)",
+		},
+		{
+			name:       "x*0 с round остается",
+			expression: "round(a, 0.5) * 0",
+			bindings:   map[string]float64{"a": 1},
+			want:       "*(round(1, 0.5), 0)",
+		},
+		{
+			name:       "x*0 с min, max и сравнением",
+			expression: "0 * (min(a, 2) + max(a, 3) * (a > 1))",
+			bindings:   map[string]float64{"a": 1},
+			want:       "0",
+		},
+		{
+			name:       "унарные плюс и минус",
+			expression: "-(-(+sqrt(2) - 3)) * -5",
+			want:       "*(-(sqrt(2), 3), -5)",
+		},
+		{
+			name:       "сворачивание чисел",
+			expression: "-(-(+2 - 3)) * -5 + 0.5 * 3",
+			want:       "6.5",
+		},
+		{
+			name:       "сбалансированная цепочка",
+			expression: "sqrt(1) + sqrt(2) + sqrt(3) + sqrt(4) + sqrt(5)",
+			want:       "+(+(+(sqrt(1), sqrt(2)), sqrt(3)), +(sqrt(4), sqrt(5)))",
+		},
+		{
+			name:       "короткая цепочка не перестраивается",
+			expression: "sqrt(1) + sqrt(2) + sqrt(3)",
+			want:       "+(+(sqrt(1), sqrt(2)), sqrt(3))",
+		},
+		{
+			name:       "цепочка в скобках",
+			expression: "sqrt(2) * (sqrt(3) * sqrt(4)) * sqrt(5) * sqrt(6)",
+			want:       "*(*(*(sqrt(2), sqrt(3)), sqrt(4)), *(sqrt(5), sqrt(6)))",
+		},
+		{
+			name:       "матрица не заменяется нулем",
+			expression: "[1, 2] * 0",
+			want:       "*([1 2], 0)",
+		},
+		{
+			name:       "условие стало числом",
+			expression: "if(-1, sqrt(2) + 3, 4)",
+			want:       "+(sqrt(2), 3)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ExpressionTree(&models.Expression{Value: tt.expression, Bindings: tt.bindings}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, treeString(Optimize(root)))
+		})
+	}
+}
+
+func TestOptimizeFoldExact(t *testing.T) {
+	root, err := ExpressionTree(&models.Expression{Value: "0.1m + 0.2 * 3km"}, nil)
+	assert.NoError(t, err)
+	folded := Optimize(root).(*NumberNode)
+	// значение считается как у агента в режиме float, а запись - точно для режима exact
+	assert.Equal(t, 0.1+0.2*3000, folded.Value)
+	assert.Equal(t, "600.1", folded.Literal)
+}
+
+func TestExplainTree(t *testing.T) {
+	root, err := ExpressionTree(&models.Expression{Value: "+1 + 2 + 3 + 4 + 5 * 1"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PlanStats{Postfix: "1 pos 2 + 3 + 4 + 5 1 * +", Operations: 6, Depth: 5}, ExplainTree(root))
+	assert.Equal(t, models.PlanStats{Postfix: "15", Operations: 0, Depth: 0}, ExplainTree(Optimize(root)))
+
+	root, err = ExpressionTree(&models.Expression{Value: "a > 1 ? (1 + 2) * 3 : 0", Bindings: map[string]float64{"a": 2}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, ExplainTree(root).Operations)
+	assert.Equal(t, 4, ExplainTree(root).Depth)
+}
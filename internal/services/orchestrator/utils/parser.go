@@ -0,0 +1,227 @@
+package orchestratorutils
+
+import (
+	"strconv"
+)
+
+// Parse разбирает выражение и возвращает дерево разбора.
+// При синтаксической ошибке возвращает *ParseError с лексемой и позицией ошибки
+func Parse(expression string) (Node, error) {
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		return nil, unexpectedToken(tok)
+	}
+	return node, nil
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseTernary разбирает тернарный оператор cond ? a : b, у которого самый низкий приоритет.
+// Он правоассоциативен (a ? b : c ? d : e = a ? b : (c ? d : e)) и разбирается в вызов if(cond, a, b)
+func (p *parser) parseTernary() (Node, error) {
+	cond, err := p.parseExpression(1)
+	if err != nil {
+		return nil, err
+	}
+	question := p.peek()
+	if question.Kind != TokenQuestion {
+		return cond, nil
+	}
+	p.next()
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if colon := p.next(); colon.Kind != TokenColon {
+		if colon.Kind == TokenEOF {
+			return nil, &ParseError{Token: question.Text, Column: question.Column, Message: "missing \":\" for"}
+		}
+		return nil, unexpectedToken(colon)
+	}
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &CallNode{Name: "if", Args: []Node{cond, then, otherwise}, Col: question.Column}, nil
+}
+
+// parseExpression разбирает бинарные операции с приоритетом не ниже minPrecedence (метод precedence climbing)
+func (p *parser) parseExpression(minPrecedence int) (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.Kind != TokenOperator || precedence(tok.Text) < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		nextMinPrecedence := precedence(tok.Text) + 1
+		if isRightAssociative(tok.Text) {
+			nextMinPrecedence = precedence(tok.Text)
+		}
+		right, err := p.parseExpression(nextMinPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{Op: binaryOperator(tok.Text), Left: left, Right: right, Col: tok.Column}
+	}
+}
+
+// parseUnary разбирает унарные плюс, минус и логическое отрицание, а также первичные выражения
+func (p *parser) parseUnary() (Node, error) {
+	tok := p.peek()
+	if tok.Kind == TokenOperator && (tok.Text == "+" || tok.Text == "-" || tok.Text == "!") {
+		p.next()
+		op := unaryOperator(tok.Text)
+		operand, err := p.parseExpression(precedence(op))
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: op, Operand: operand, Col: tok.Column}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary разбирает число, переменную, вызов функции, список или выражение в скобках
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case TokenIdentifier:
+		if p.peek().Kind == TokenLeftParen {
+			return p.parseCall(tok)
+		}
+		if value, ok := constants[tok.Text]; ok {
+			return &NumberNode{Value: value, Literal: strconv.FormatFloat(value, 'f', -1, 64), Col: tok.Column}, nil
+		}
+		return &VariableNode{Name: tok.Text, Col: tok.Column}, nil
+	case TokenNumber:
+		value, err := strconv.ParseFloat(tok.Text, 64)
+		if err != nil {
+			return nil, &ParseError{Token: tok.Text, Column: tok.Column, Message: "invalid number"}
+		}
+		number := &NumberNode{Value: value, Literal: tok.Text, Col: tok.Column}
+		// суффикс мнимой единицы (4i) или единицы измерения после числа: 5km, 2 h (но не вызов функции min(...))
+		if next := p.peek(); next.Kind == TokenIdentifier && p.tokens[p.pos+1].Kind != TokenLeftParen {
+			if next.Text == imaginaryUnit {
+				p.next()
+				return &NumberNode{Imag: value, Literal: tok.Text + imaginaryUnit, Col: tok.Column}, nil
+			}
+			if u, ok := units[next.Text]; ok {
+				p.next()
+				return withUnit(number, u)
+			}
+		}
+		return number, nil
+	case TokenLeftParen:
+		node, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.Kind != TokenRightParen {
+			if closing.Kind == TokenEOF {
+				return nil, &ParseError{Column: closing.Column, Message: "missing closing parenthesis for \"(\" at column " + strconv.Itoa(tok.Column)}
+			}
+			return nil, unexpectedToken(closing)
+		}
+		return node, nil
+	case TokenLeftBracket:
+		return p.parseList(tok)
+	default:
+		return nil, unexpectedToken(tok)
+	}
+}
+
+// parseCall разбирает аргументы вызова функции name(arg1, arg2, ...).
+// Число аргументов проверяется только у встроенных функций, вызовы формул пользователя
+// проверяются при их раскрытии в ExpandFormulas
+func (p *parser) parseCall(name Token) (Node, error) {
+	opening := p.next()
+
+	var args []Node
+	if p.peek().Kind != TokenRightParen {
+		for {
+			arg, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().Kind != TokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if closing := p.next(); closing.Kind != TokenRightParen {
+		if closing.Kind == TokenEOF {
+			return nil, &ParseError{Column: closing.Column, Message: "missing closing parenthesis for \"(\" at column " + strconv.Itoa(opening.Column)}
+		}
+		return nil, unexpectedToken(closing)
+	}
+	if function, ok := functions[name.Text]; ok && !function.acceptsArgs(len(args)) {
+		return nil, wrongNumberOfArguments(name.Text, name.Column, len(args))
+	}
+	return &CallNode{Name: name.Text, Args: args, Col: name.Column}, nil
+}
+
+// parseList разбирает элементы списка [a, b, ...], вложенные списки задают строки матрицы
+func (p *parser) parseList(opening Token) (Node, error) {
+	if closing := p.peek(); closing.Kind == TokenRightBracket {
+		return nil, &ParseError{Token: closing.Text, Column: closing.Column, Message: "empty list"}
+	}
+	var items []Node
+	for {
+		item, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.peek().Kind != TokenComma {
+			break
+		}
+		p.next()
+	}
+	if closing := p.next(); closing.Kind != TokenRightBracket {
+		if closing.Kind == TokenEOF {
+			return nil, &ParseError{Column: closing.Column, Message: "missing closing bracket for \"[\" at column " + strconv.Itoa(opening.Column)}
+		}
+		return nil, unexpectedToken(closing)
+	}
+	return &ListNode{Items: items, Col: opening.Column}, nil
+}
+
+func wrongNumberOfArguments(name string, column, count int) *ParseError {
+	return &ParseError{Token: name, Column: column,
+		Message: "wrong number of arguments (" + strconv.Itoa(count) + ") for function"}
+}
+
+func unexpectedToken(tok Token) *ParseError {
+	if tok.Kind == TokenEOF {
+		return &ParseError{Column: tok.Column, Message: "unexpected end of expression"}
+	}
+	return &ParseError{Token: tok.Text, Column: tok.Column, Message: "unexpected token"}
+}
@@ -0,0 +1,119 @@
+package orchestratorutils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantToken  string
+		wantColumn int
+	}{
+		{
+			name:       "10/",
+			expression: "10/",
+			wantToken:  "",
+			wantColumn: 4,
+		},
+		{
+			name:       "2+(2*2))",
+			expression: "2+(2*2))",
+			wantToken:  ")",
+			wantColumn: 8,
+		},
+		{
+			name:       "(2+2",
+			expression: "(2+2",
+			wantToken:  "",
+			wantColumn: 5,
+		},
+		{
+			name:       "2 * / 3",
+			expression: "2 * / 3",
+			wantToken:  "/",
+			wantColumn: 5,
+		},
+		{
+			name:       "2 ^^ 3",
+			expression: "2 ^^ 3",
+			wantToken:  "^",
+			wantColumn: 4,
+		},
+		{
+			name:       "x = 1",
+			expression: "x = 1",
+			wantToken:  "=",
+			wantColumn: 3,
+		},
+		{
+			name:       "sqrt(1, 2)",
+			expression: "sqrt(1, 2)",
+			wantToken:  "sqrt",
+			wantColumn: 1,
+		},
+		{
+			name:       "max()",
+			expression: "max()",
+			wantToken:  "max",
+			wantColumn: 1,
+		},
+		{
+			name:       "max(1,)",
+			expression: "max(1,)",
+			wantToken:  ")",
+			wantColumn: 7,
+		},
+		{
+			name:       "sqrt(4",
+			expression: "sqrt(4",
+			wantToken:  "",
+			wantColumn: 7,
+		},
+		{
+			name:       "1 + $",
+			expression: "1 + $",
+			wantToken:  "$",
+			wantColumn: 5,
+		},
+		{
+			name:       "1e+",
+			expression: "1e+",
+			wantToken:  "1e+",
+			wantColumn: 1,
+		},
+		{
+			name:       "x > 1 ? 2",
+			expression: "x > 1 ? 2",
+			wantToken:  "?",
+			wantColumn: 7,
+		},
+		{
+			name:       "2 3",
+			expression: "2 3",
+			wantToken:  "3",
+			wantColumn: 3,
+		},
+		{
+			name:       "кириллица считается по символам",
+			expression: "(1+2)*ы",
+			wantToken:  "ы",
+			wantColumn: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expression)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse() error = %v, want *ParseError", err)
+			}
+			if parseErr.Token != tt.wantToken || parseErr.Column != tt.wantColumn {
+				t.Errorf("Parse() error token = %q column = %d, want token %q column %d",
+					parseErr.Token, parseErr.Column, tt.wantToken, tt.wantColumn)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package orchestratorutils
+
+import (
+	"fmt"
+	"myproject/internal/models"
+	"strings"
+)
+
+// ExplainTree оценивает, сколько подвыражений создаст SplitToSubtasks для дерева root
+// (одинаковые поддеревья считаются один раз)
+// и сколько последовательных обращений к агентам потребуется для его подсчета
+func ExplainTree(root Node) models.PlanStats {
+	var postfix []string
+	writePostfix(root, &postfix)
+	p := &planner{scopes: []map[string]bool{{}}}
+	operations, depth, _ := p.planCost(root)
+	return models.PlanStats{Postfix: strings.Join(postfix, " "), Operations: operations, Depth: depth}
+}
+
+// planner считает подвыражения так же, как их создает SplitToSubtasks: одинаковые поддеревья
+// в одной ветке if и в ветках, в которые она вложена, считаются одним подвыражением
+type planner struct {
+	// scopes ключи уже посчитанных поддеревьев по веткам if, последняя - текущая ветка
+	scopes []map[string]bool
+}
+
+// planCost возвращает количество новых подвыражений, глубину и ключ поддерева node
+func (p *planner) planCost(node Node) (operations, depth int, key string) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return 0, 0, n.Literal
+	case *MatrixNode:
+		return 0, 0, fmt.Sprint(n.Value)
+	case *UnaryNode:
+		operations, depth, key = p.planCost(n.Operand)
+		return p.share(n.Op+"("+key+")", operations, depth+1)
+	case *BinaryNode:
+		leftOperations, leftDepth, leftKey := p.planCost(n.Left)
+		rightOperations, rightDepth, rightKey := p.planCost(n.Right)
+		return p.share(n.Op+"("+leftKey+","+rightKey+")", leftOperations+rightOperations, max(leftDepth, rightDepth)+1)
+	case *CallNode:
+		if n.Name == "if" {
+			if _, ok := n.Args[0].(*NumberNode); ok {
+				// условие известно, считается только выбранная ветка
+				return p.planCost(selectConstantBranch(n))
+			}
+			return p.planIf(n)
+		}
+		keys := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			argOperations, argDepth, argKey := p.planCost(arg)
+			operations += argOperations
+			depth = max(depth, argDepth)
+			keys[i] = argKey
+		}
+		key = n.Name + "(" + strings.Join(keys, ",") + ")"
+		if n.Name == "matmul" {
+			a, _ := inferShape(n.Args[0])
+			b, _ := inferShape(n.Args[1])
+			if a.rows > matmulBlockSize || b.cols > matmulBlockSize {
+				// блоки и подвыражение blocks, которое их собирает
+				rows, cols := len(blockRanges(a.rows)), len(blockRanges(b.cols))
+				operations += rows * cols
+				// части еще не посчитанных матриц, нужные блокам, вырезаются отдельными подвыражениями slice
+				slices := 0
+				if _, ok := n.Args[0].(*MatrixNode); !ok && rows > 1 {
+					slices += rows
+				}
+				if _, ok := n.Args[1].(*MatrixNode); !ok && cols > 1 {
+					slices += cols
+				}
+				if slices > 0 {
+					return p.share(key, operations+slices, depth+3)
+				}
+				return p.share(key, operations, depth+2)
+			}
+		}
+		return p.share(key, operations, depth+1)
+	}
+	return 0, 0, ""
+}
+
+// planIf считает ветки if отдельно от остального выражения: ветки ждут подсчета условия
+func (p *planner) planIf(n *CallNode) (operations, depth int, key string) {
+	keys := make([]string, len(n.Args))
+	operations, depth, keys[0] = p.planCost(n.Args[0])
+	condDepth := depth
+	for i, branch := range n.Args[1:] {
+		p.scopes = append(p.scopes, make(map[string]bool))
+		branchOperations, branchDepth, branchKey := p.planCost(branch)
+		p.scopes = p.scopes[:len(p.scopes)-1]
+		operations += branchOperations
+		depth = max(depth, condDepth+branchDepth)
+		keys[i+1] = branchKey
+	}
+	return p.share("if("+strings.Join(keys, ",")+")", operations, depth+1)
+}
+
+// share добавляет подвыражение key, если такого еще нет в текущей ветке и ветках, в которые она вложена
+func (p *planner) share(key string, operations, depth int) (int, int, string) {
+	for _, scope := range p.scopes {
+		if scope[key] {
+			return operations, depth, key
+		}
+	}
+	p.scopes[len(p.scopes)-1][key] = true
+	return operations + 1, depth, key
+}
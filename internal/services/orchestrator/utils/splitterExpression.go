@@ -3,22 +3,38 @@ package orchestratorutils
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
-	"log"
 	"myproject/internal/models"
-	"myproject/internal/repositories/subExpression"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
 //go:generate go run github.com/vektra/mockery/v2@v2.42.2 --name=SplitToSubtasks
 type SplitterToSubtasks interface {
-	SplitToSubtasks(ctx context.Context, expr *models.Expression, subExpressionRepo subExpression.Repository) (tasks []*models.SubExpression, err error)
+	SplitToSubtasks(ctx context.Context, expr *models.Expression, root Node, subExpressionRepo SubExpressionCreator) (tasks []*models.SubExpression, err error)
 }
 
-// SplitToSubtasks делает полное арифметическое выражение на подзадачи
-func SplitToSubtasks(ctx context.Context, expr *models.Expression, subExpressionRepo subExpression.Repository) (tasks []*models.SubExpression, err error) {
+// SubExpressionCreator создает subexpressions при разбиении: subExpression.Repository сохраняет их в бд сразу,
+// а при пакетном создании выражений им только назначаются id, и они сохраняются вместе с выражениями
+type SubExpressionCreator interface {
+	CreateSubExpression(ctx context.Context, subExpression *models.SubExpression) (*models.SubExpression, error)
+}
+
+// operand операнд subexpression: либо число, либо id subexpression, результат которого подставится позже
+type operand struct {
+	val float64
+	id  uuid.NullUUID
+	// exact точное значение операнда в режиме ModeExact
+	exact string
+	// im мнимая часть операнда в режиме ModeComplex
+	im float64
+	// matrix значение операнда-матрицы, известное при разбиении
+	matrix *models.Matrix
+}
+
+// SplitToSubtasks делает полное арифметическое выражение на подзадачи.
+// root - дерево выражения expr, построенное ExpressionTree
+func SplitToSubtasks(ctx context.Context, expr *models.Expression, root Node, subExpressionRepo SubExpressionCreator) (tasks []*models.SubExpression, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			switch x := r.(type) {
@@ -33,89 +49,283 @@ func SplitToSubtasks(ctx context.Context, expr *models.Expression, subExpression
 		}
 	}()
 
-	var stack []string
-	uuidRegex := regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[1-5][0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	expressionId, err := uuid.Parse(expr.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// guards условия веток if, которые сейчас разбиваются: последний - условие самой вложенной ветки,
+	// первый - пустой guard выражения вне веток
+	guards := []guard{{}}
+
+	// shared уже созданные subexpressions по ключу guard и операции с операндами (см. sharedKey)
+	shared := make(map[string]uuid.UUID)
+
+	// функция создания subexpression. Одинаковая операция над одинаковыми операндами создается один раз:
+	// если такой subexpression уже есть в текущей ветке или в ветках, в которые она вложена, возвращается его id,
+	// и его результат подставится во все зависящие от него subexpressions
+	create := func(subExpr *models.SubExpression, isLast bool, operands []operand) (operand, error) {
+		key := sharedKey(subExpr.Action, operands)
+		if !isLast {
+			for i := len(guards) - 1; i >= 0; i-- {
+				if id, ok := shared[guards[i].String()+key]; ok {
+					return operand{id: uuid.NullUUID{UUID: id, Valid: true}}, nil
+				}
+			}
+		}
+		created, err := subExpressionRepo.CreateSubExpression(ctx, subExpr)
+		if err != nil {
+			return operand{}, err
+		}
+		shared[guards[len(guards)-1].String()+key] = created.Id
+		tasks = append(tasks, created)
+		return operand{id: uuid.NullUUID{UUID: created.Id, Valid: true}}, nil
+	}
+
+	// функция создания subexpression, у унарных операций второй операнд не используется
+	getTempVar := func(element string, isLast bool, operand1 operand, operand2 operand) (operand, error) {
+		current := guards[len(guards)-1]
+		subExpr := &models.SubExpression{
+			ExpressionId:     expressionId,
+			IsLast:           isLast,
+			Action:           element,
+			Error:            false,
+			Val1:             operand1.val,
+			SubExpressionId1: operand1.id,
+			Val2:             operand2.val,
+			SubExpressionId2: operand2.id,
+			Mode:             expr.Mode,
+			Priority:         expr.Priority,
+			GuardId:          current.id,
+			GuardBranch:      current.branch,
+			Val1Matrix:       operand1.matrix,
+			Val2Matrix:       operand2.matrix,
+		}
+		if expr.Mode == models.ModeExact {
+			subExpr.Val1Exact = exactValue(operand1)
+			subExpr.Val2Exact = exactValue(operand2)
+		}
+		if expr.Mode == models.ModeComplex {
+			subExpr.Val1Im = operand1.im
+			subExpr.Val2Im = operand2.im
+		}
+		return create(subExpr, isLast, []operand{operand1, operand2})
+	}
 
-	// функция создания subexpression
-	getTempVar := func(operand1, operand2, element string, isLast bool) (*models.SubExpression, error) {
-		uid, _ := uuid.Parse(expr.Id)
+	// функция создания subexpression для вызова функции с произвольным числом аргументов
+	getTempCall := func(name string, isLast bool, args []operand) (operand, error) {
+		current := guards[len(guards)-1]
 		subExpr := &models.SubExpression{
-			ExpressionId: uid,
+			ExpressionId: expressionId,
 			IsLast:       isLast,
-			Action:       element,
+			Action:       name,
 			Error:        false,
+			Args:         make([]float64, len(args)),
+			ArgIds:       make([]uuid.NullUUID, len(args)),
+			Mode:         expr.Mode,
+			Priority:     expr.Priority,
+			GuardId:      current.id,
+			GuardBranch:  current.branch,
 		}
-
-		if uuidRegex.MatchString(operand1) {
-			operand1Uid, err := uuid.Parse(operand1)
-			if err != nil {
-				log.Printf("error parse operand1")
-				return nil, err
+		if functions[name].Matrix || name == "blocks" || name == "slice" {
+			// матрицы еще не посчитанных аргументов подставятся на место nil
+			subExpr.ArgsMatrix = make([]*models.Matrix, len(args))
+		}
+		for i, arg := range args {
+			subExpr.Args[i] = arg.val
+			subExpr.ArgIds[i] = arg.id
+			if expr.Mode == models.ModeExact {
+				subExpr.ArgsExact = append(subExpr.ArgsExact, exactValue(arg))
 			}
-			subExpr.SubExpressionId1 = uuid.NullUUID{
-				UUID:  operand1Uid,
-				Valid: true,
+			if expr.Mode == models.ModeComplex {
+				subExpr.ArgsIm = append(subExpr.ArgsIm, arg.im)
 			}
-		} else {
-			el, err := strconv.ParseFloat(operand1, 64)
-			if err != nil {
-				return nil, err
+			if subExpr.ArgsMatrix != nil {
+				subExpr.ArgsMatrix[i] = arg.matrix
 			}
-			subExpr.Val1 = el
 		}
-		if uuidRegex.MatchString(operand2) {
-			operand2Uid, err := uuid.Parse(operand2)
+		return create(subExpr, isLast, args)
+	}
+
+	// обход дерева в обратном порядке: сначала создаются subexpressions операндов, затем самой операции
+	var walk func(node Node, isLast bool) (operand, error)
+	var walkIf func(n *CallNode, isLast bool) (operand, error)
+	var walkMatmul func(n *CallNode, isLast bool) (operand, error)
+	var walkSlice func(op operand, opShape shape, rowFrom, rowTo, colFrom, colTo int) (operand, error)
+	walk = func(node Node, isLast bool) (operand, error) {
+		switch n := node.(type) {
+		case *NumberNode:
+			return operand{val: n.Value, exact: n.Literal, im: n.Imag}, nil
+		case *MatrixNode:
+			return operand{matrix: &n.Value}, nil
+		case *UnaryNode:
+			operand1, err := walk(n.Operand, false)
 			if err != nil {
-				log.Printf("error parse operand2")
-				return nil, err
+				return operand{}, err
 			}
-			subExpr.SubExpressionId2 = uuid.NullUUID{
-				UUID:  operand2Uid,
-				Valid: true,
+			return getTempVar(n.Op, isLast, operand1, operand{})
+		case *BinaryNode:
+			operand1, err := walk(n.Left, false)
+			if err != nil {
+				return operand{}, err
 			}
-		} else {
-			el, err := strconv.ParseFloat(operand2, 64)
+			operand2, err := walk(n.Right, false)
 			if err != nil {
-				return nil, err
+				return operand{}, err
 			}
-			subExpr.Val2 = el
+			return getTempVar(n.Op, isLast, operand1, operand2)
+		case *CallNode:
+			switch n.Name {
+			case "if":
+				return walkIf(n, isLast)
+			case "matmul":
+				return walkMatmul(n, isLast)
+			}
+			args := make([]operand, 0, len(n.Args))
+			for _, argNode := range n.Args {
+				arg, err := walk(argNode, false)
+				if err != nil {
+					return operand{}, err
+				}
+				args = append(args, arg)
+			}
+			return getTempCall(n.Name, isLast, args)
+		default:
+			return operand{}, fmt.Errorf("unsupported node %T", node)
 		}
-		subExpr, err = subExpressionRepo.CreateSubExpression(ctx, subExpr)
+	}
+
+	// ветки if создаются с guard на subexpression условия: агенту отправляется только выбранная ветка,
+	// а невыбранная удаляется, когда условие посчитано (см. subExpression.Repository.UpdateSubExpressions)
+	walkIf = func(n *CallNode, isLast bool) (operand, error) {
+		cond, err := walk(n.Args[0], false)
 		if err != nil {
-			return nil, err
+			return operand{}, err
 		}
-		return subExpr, nil
-
+		if !cond.id.Valid {
+			// условие известно заранее, считается только выбранная ветка
+			if cond.val != 0 || cond.im != 0 {
+				return walk(n.Args[1], isLast)
+			}
+			return walk(n.Args[2], isLast)
+		}
+		guards = append(guards, guard{id: cond.id, branch: true})
+		then, err := walk(n.Args[1], false)
+		if err != nil {
+			return operand{}, err
+		}
+		guards[len(guards)-1].branch = false
+		otherwise, err := walk(n.Args[2], false)
+		if err != nil {
+			return operand{}, err
+		}
+		guards = guards[:len(guards)-1]
+		return getTempCall(n.Name, isLast, []operand{cond, then, otherwise})
 	}
 
-	// Разделяем входное выражение на элементы.
-	elements := strings.Fields(InfixToPostfix(expr.Value))
-
-	for i, element := range elements {
-		switch element {
-		case "+", "-", "*", "/":
-			// Всегда должно быть как минимум два элемента в стеке.
-			operand2 := stack[len(stack)-1]
-			operand1 := stack[len(stack)-2]
-			stack = stack[:len(stack)-2] // Удаляем два элемента из стека.
-
-			isLast := false
-			if i == len(elements)-1 {
-				isLast = true
+	// произведение больших матриц разбивается на блоки строк и столбцов результата: каждый блок - отдельный
+	// subexpression matmul(строки a, столбцы b), который агенты считают параллельно,
+	// а subexpression blocks(число блоков по строкам, блоки...) собирает из них результат
+	walkMatmul = func(n *CallNode, isLast bool) (operand, error) {
+		a, err := walk(n.Args[0], false)
+		if err != nil {
+			return operand{}, err
+		}
+		b, err := walk(n.Args[1], false)
+		if err != nil {
+			return operand{}, err
+		}
+		aShape, err := inferShape(n.Args[0])
+		if err != nil {
+			return operand{}, err
+		}
+		bShape, err := inferShape(n.Args[1])
+		if err != nil {
+			return operand{}, err
+		}
+		if aShape.rows <= matmulBlockSize && bShape.cols <= matmulBlockSize {
+			return getTempCall(n.Name, isLast, []operand{a, b})
+		}
+		// блоку нужны только его строки a и его столбцы b, а не матрицы целиком
+		rowRanges, colRanges := blockRanges(aShape.rows), blockRanges(bShape.cols)
+		aRows := make([]operand, len(rowRanges))
+		for i, rows := range rowRanges {
+			if aRows[i], err = walkSlice(a, aShape, rows[0], rows[1], 0, aShape.cols); err != nil {
+				return operand{}, err
 			}
-			tempVar, err := getTempVar(operand1, operand2, element, isLast)
-			if err != nil {
-				return nil, err
+		}
+		bCols := make([]operand, len(colRanges))
+		for j, cols := range colRanges {
+			if bCols[j], err = walkSlice(b, bShape, 0, bShape.rows, cols[0], cols[1]); err != nil {
+				return operand{}, err
+			}
+		}
+		blocks := []operand{{val: float64(len(rowRanges))}}
+		for i := range rowRanges {
+			for j := range colRanges {
+				block, err := getTempCall(n.Name, false, []operand{aRows[i], bCols[j]})
+				if err != nil {
+					return operand{}, err
+				}
+				blocks = append(blocks, block)
 			}
-			tasks = append(tasks, tempVar)
+		}
+		return getTempCall("blocks", isLast, blocks)
+	}
 
-			// Заносим результат как временную переменную обратно в стек.
-			stack = append(stack, tempVar.Id.String())
-		default:
-			// Добавляем в стек, если это число.
-			stack = append(stack, element)
+	// часть известной при разбиении матрицы вырезается сразу, а часть еще не посчитанной - subexpression
+	// slice(матрица, строка от, строка до, столбец от, столбец до), один на все блоки, которым она нужна
+	walkSlice = func(op operand, opShape shape, rowFrom, rowTo, colFrom, colTo int) (operand, error) {
+		if rowFrom == 0 && rowTo == opShape.rows && colFrom == 0 && colTo == opShape.cols {
+			return op, nil
+		}
+		if op.matrix != nil {
+			return operand{matrix: op.matrix.Slice(rowFrom, rowTo, colFrom, colTo)}, nil
 		}
+		bounds := []operand{{val: float64(rowFrom)}, {val: float64(rowTo)}, {val: float64(colFrom)}, {val: float64(colTo)}}
+		return getTempCall("slice", false, append([]operand{op}, bounds...))
+	}
+
+	if _, err = walk(root, true); err != nil {
+		return nil, err
 	}
 
 	return tasks, nil
 }
+
+// guard условие, от которого зависит ветка if: пока условие не посчитано, subexpressions ветки не отправляются агентам,
+// а после подсчета невыбранная ветка удаляется
+type guard struct {
+	id     uuid.NullUUID
+	branch bool
+}
+
+func (g guard) String() string {
+	if !g.id.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%s:%t", g.id.UUID, g.branch)
+}
+
+// sharedKey ключ операции над операндами: совпадает у subexpressions, которые всегда дают одинаковый результат.
+// Числа сравниваются по записи, поэтому в точном режиме 2 и 2.0 - разные операнды
+func sharedKey(action string, operands []operand) string {
+	var key strings.Builder
+	key.WriteString("|" + action)
+	for _, op := range operands {
+		if op.id.Valid {
+			fmt.Fprintf(&key, "|%s", op.id.UUID)
+		} else {
+			fmt.Fprintf(&key, "|%v %s %v %v", op.val, op.exact, op.im, op.matrix)
+		}
+	}
+	return key.String()
+}
+
+// exactValue точное значение операнда, для еще не посчитанного операнда - "0", как и у float значения
+func exactValue(op operand) string {
+	if op.exact == "" {
+		return "0"
+	}
+	return op.exact
+}
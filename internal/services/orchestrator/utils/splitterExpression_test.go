@@ -0,0 +1,139 @@
+package orchestratorutils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+// subExpressionRepository назначает subexpressions id, не сохраняя их
+type subExpressionRepository struct{}
+
+func (r *subExpressionRepository) CreateSubExpression(_ context.Context, subExpr *models.SubExpression) (*models.SubExpression, error) {
+	subExpr.Id = uuid.New()
+	return subExpr, nil
+}
+
+func TestSplitToSubtasksShared(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		bindings   map[string]float64
+		want       int
+	}{
+		{
+			name:       "(2+3)*(2+3)",
+			expression: "(2 + 3) * (2 + 3)",
+			want:       2,
+		},
+		{
+			name:       "общее поддерево внутри одинаковых",
+			expression: "sqrt(2 + 3) + sqrt(2 + 3) * (2 + 3)",
+			want:       4,
+		},
+		{
+			name:       "разные литералы не объединяются",
+			expression: "(2 + 3) * (3 + 2)",
+			want:       3,
+		},
+		{
+			name:       "ветка if использует уже посчитанное вне веток",
+			expression: "(2 + 3) + if(a > 1, 2 + 3, 1)",
+			bindings:   map[string]float64{"a": 2},
+			want:       4,
+		},
+		{
+			name:       "вне веток не используется посчитанное в ветке",
+			expression: "if(a > 1, 2 + 3, 1) + (2 + 3)",
+			bindings:   map[string]float64{"a": 2},
+			want:       5,
+		},
+		{
+			name:       "ветки if не объединяются",
+			expression: "if(a > 1, 2 + 3, 2 + 3)",
+			bindings:   map[string]float64{"a": 2},
+			want:       4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr := &models.Expression{Id: uuid.NewString(), Value: tt.expression, Bindings: tt.bindings}
+			root, err := ExpressionTree(expr, nil)
+			assert.NoError(t, err)
+			tasks, err := SplitToSubtasks(context.Background(), expr, root, &subExpressionRepository{})
+			assert.NoError(t, err)
+			assert.Len(t, tasks, tt.want)
+			assert.True(t, tasks[len(tasks)-1].IsLast)
+			assert.Equal(t, tt.want, ExplainTree(root).Operations)
+		})
+	}
+}
+
+func TestSplitToSubtasksSharedOperands(t *testing.T) {
+	expr := &models.Expression{Id: uuid.NewString(), Value: "(2 + 3) * (2 + 3)"}
+	root, err := ExpressionTree(expr, nil)
+	assert.NoError(t, err)
+	tasks, err := SplitToSubtasks(context.Background(), expr, root, &subExpressionRepository{})
+	assert.NoError(t, err)
+	sum := uuid.NullUUID{UUID: tasks[0].Id, Valid: true}
+	assert.Equal(t, sum, tasks[1].SubExpressionId1)
+	assert.Equal(t, sum, tasks[1].SubExpressionId2)
+}
+
+// matrixLiteral запись матрицы rows x cols из единиц
+func matrixLiteral(rows, cols int) string {
+	row := "[" + strings.TrimSuffix(strings.Repeat("1, ", cols), ", ") + "]"
+	return "[" + strings.TrimSuffix(strings.Repeat(row+", ", rows), ", ") + "]"
+}
+
+func TestSplitToSubtasksMatmulBlocks(t *testing.T) {
+	a, b := matrixLiteral(matmulBlockSize+1, 2), matrixLiteral(2, 1)
+	tests := []struct {
+		name       string
+		expression string
+		wantTasks  int
+		wantSlices int
+	}{
+		{
+			name:       "известные матрицы режутся при разбиении",
+			expression: "matmul(" + a + ", " + b + ")",
+			wantTasks:  3,
+		},
+		{
+			name:       "еще не посчитанная матрица режется subexpressions slice",
+			expression: "matmul(" + a + " * 2, " + b + ")",
+			wantTasks:  6,
+			wantSlices: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr := &models.Expression{Id: uuid.NewString(), Value: tt.expression, Mode: models.ModeFloat}
+			root, err := ExpressionTree(expr, nil)
+			assert.NoError(t, err)
+			tasks, err := SplitToSubtasks(context.Background(), expr, root, &subExpressionRepository{})
+			assert.NoError(t, err)
+			assert.Len(t, tasks, tt.wantTasks)
+			assert.Equal(t, tt.wantTasks, ExplainTree(root).Operations)
+
+			slices := 0
+			for _, task := range tasks {
+				switch task.Action {
+				case "slice":
+					slices++
+				case "matmul":
+					// блоку передаются только его строки первой матрицы
+					if a := task.ArgsMatrix[0]; a != nil {
+						assert.LessOrEqual(t, a.Rows, matmulBlockSize)
+					}
+					assert.Len(t, task.Args, 2)
+				}
+			}
+			assert.Equal(t, tt.wantSlices, slices)
+		})
+	}
+}
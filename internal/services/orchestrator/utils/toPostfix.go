@@ -1,85 +1,99 @@
 package orchestratorutils
 
 import (
-	"bytes"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
-type Stack []string
-
-func (s *Stack) IsEmpty() bool {
-	return len(*s) == 0
+func precedence(op string) int {
+	switch op {
+	case "||":
+		return 1
+	case "&&":
+		return 2
+	case "==", "!=":
+		return 3
+	case "<", "<=", ">", ">=":
+		return 4
+	case "+", "-":
+		return 5
+	case "*", "/", "%", "//":
+		return 6
+	case "neg", "pos", "not":
+		return 7
+	case "^", "**":
+		return 8
+	}
+	return 0
 }
 
-func (s *Stack) Push(str string) {
-	*s = append(*s, str)
+// isRightAssociative возвращает true для правоассоциативных операций: 2^3^2 = 2^(3^2)
+func isRightAssociative(op string) bool {
+	return op == "^" || op == "**"
 }
 
-func (s *Stack) Pop() string {
-	if s.IsEmpty() {
-		return ""
-	} else {
-		index := len(*s) - 1
-		element := (*s)[index]
-		*s = (*s)[:index]
-		return element
+// binaryOperator приводит синонимы бинарных операций к единому виду ("**" -> "^")
+func binaryOperator(op string) string {
+	if op == "**" {
+		return "^"
 	}
+	return op
 }
 
-func (s *Stack) Peek() string {
-	if s.IsEmpty() {
-		return ""
+// unaryOperator возвращает унарный вариант оператора ("neg" для минуса, "pos" для плюса, "not" для "!")
+func unaryOperator(op string) string {
+	switch op {
+	case "-":
+		return "neg"
+	case "!":
+		return "not"
 	}
-	return (*s)[len(*s)-1]
+	return "pos"
 }
 
-func precedence(op string) int {
-	if op == "+" || op == "-" {
-		return 1
-	}
-	if op == "*" || op == "/" {
-		return 2
+// InfixToPostfix преобразование инфиксной записи в постфиксную.
+// Для некорректного выражения возвращает пустую строку
+func InfixToPostfix(expression string) string {
+	root, err := Parse(expression)
+	if err != nil {
+		return ""
 	}
-	return 0
+	var result []string
+	writePostfix(root, &result)
+	return strings.Join(result, " ")
 }
 
-// InfixToPostfix преобразование инфиксной записи в постфиксную
-func InfixToPostfix(expression string) string {
-	var result bytes.Buffer
-	var stack Stack
-
-	// Создаем регулярное выражение, которое будет использоваться для разделения чисел и операторов
-	re := regexp.MustCompile(`\d+|[+/*()-]`)
-	tokens := re.FindAllString(expression, -1)
-
-	for _, token := range tokens {
-		// Если это число, добавляем его в результат
-		if _, err := strconv.Atoi(token); err == nil {
-			result.WriteString(token + " ")
+// writePostfix обходит дерево в обратном порядке и записывает элементы постфиксной записи
+func writePostfix(node Node, result *[]string) {
+	switch n := node.(type) {
+	case *NumberNode:
+		*result = append(*result, n.Literal)
+	case *VariableNode:
+		*result = append(*result, n.Name)
+	case *UnaryNode:
+		writePostfix(n.Operand, result)
+		*result = append(*result, n.Op)
+	case *BinaryNode:
+		writePostfix(n.Left, result)
+		writePostfix(n.Right, result)
+		*result = append(*result, n.Op)
+	case *CallNode:
+		for _, arg := range n.Args {
+			writePostfix(arg, result)
+		}
+		// у функций с переменным числом аргументов указываем их количество: "3 7 2 max/3"
+		if function := functions[n.Name]; function.MinArgs != function.MaxArgs {
+			*result = append(*result, n.Name+"/"+strconv.Itoa(len(n.Args)))
 		} else {
-			// Обработка скобок и операторов
-			switch token {
-			case "(":
-				stack.Push(token)
-			case ")":
-				for !stack.IsEmpty() && stack.Peek() != "(" {
-					result.WriteString(stack.Pop() + " ")
-				}
-				stack.Pop() // Удаляем открывающую скобку
-			default:
-				for !stack.IsEmpty() && precedence(token) <= precedence(stack.Peek()) {
-					result.WriteString(stack.Pop() + " ")
-				}
-				stack.Push(token)
-			}
+			*result = append(*result, n.Name)
 		}
+	case *ListNode:
+		// список записывается как функция от своих элементов: "[1, 2, 3]" - "1 2 3 list/3"
+		for _, item := range n.Items {
+			writePostfix(item, result)
+		}
+		*result = append(*result, "list/"+strconv.Itoa(len(n.Items)))
+	case *MatrixNode:
+		*result = append(*result, "matrix/"+strconv.Itoa(n.Value.Rows)+"x"+strconv.Itoa(n.Value.Cols))
 	}
-
-	for !stack.IsEmpty() {
-		result.WriteString(stack.Pop() + " ")
-	}
-
-	return strings.TrimSpace(result.String())
 }
@@ -41,6 +41,121 @@ func TestInfixToPostfix(t *testing.T) {
 			args: args{expression: "(1 + 5) * 3"},
 			want: "1 5 + 3 *",
 		},
+		{
+			name: "1.5*2",
+			args: args{expression: "1.5*2"},
+			want: "1.5 2 *",
+		},
+		{
+			name: ".5 + 1.",
+			args: args{expression: ".5 + 1."},
+			want: ".5 1. +",
+		},
+		{
+			name: "2.5e-3*4",
+			args: args{expression: "2.5e-3*4"},
+			want: "2.5e-3 4 *",
+		},
+		{
+			name: "1e3-1E+2",
+			args: args{expression: "1e3-1E+2"},
+			want: "1e3 1E+2 -",
+		},
+		{
+			name: "-3+5",
+			args: args{expression: "-3+5"},
+			want: "3 neg 5 +",
+		},
+		{
+			name: "2*-4",
+			args: args{expression: "2*-4"},
+			want: "2 4 neg *",
+		},
+		{
+			name: "-(1+2)",
+			args: args{expression: "-(1+2)"},
+			want: "1 2 + neg",
+		},
+		{
+			name: "2--3",
+			args: args{expression: "2--3"},
+			want: "2 3 neg -",
+		},
+		{
+			name: "+2*(-+3)",
+			args: args{expression: "+2*(-+3)"},
+			want: "2 pos 3 pos neg *",
+		},
+		{
+			name: "2^3^2",
+			args: args{expression: "2^3^2"},
+			want: "2 3 2 ^ ^",
+		},
+		{
+			name: "(2^3)^2",
+			args: args{expression: "(2^3)^2"},
+			want: "2 3 ^ 2 ^",
+		},
+		{
+			name: "2**3*4",
+			args: args{expression: "2**3*4"},
+			want: "2 3 ^ 4 *",
+		},
+		{
+			name: "-2^2",
+			args: args{expression: "-2^2"},
+			want: "2 2 ^ neg",
+		},
+		{
+			name: "2^-1",
+			args: args{expression: "2^-1"},
+			want: "2 1 neg ^",
+		},
+		{
+			name: "7 % 3 + 7 // 2",
+			args: args{expression: "7 % 3 + 7 // 2"},
+			want: "7 3 % 7 2 // +",
+		},
+		{
+			name: "2*7//2%3",
+			args: args{expression: "2*7//2%3"},
+			want: "2 7 * 2 // 3 %",
+		},
+		{
+			name: "sqrt(16)+max(3,7,2)",
+			args: args{expression: "sqrt(16)+max(3,7,2)"},
+			want: "16 sqrt 3 7 2 max/3 +",
+		},
+		{
+			name: "-abs(-2)*round(2.345, 2)",
+			args: args{expression: "-abs(-2)*round(2.345, 2)"},
+			want: "2 neg abs neg 2.345 2 round/2 *",
+		},
+		{
+			name: "max(min(1, 2), cos(0)^2)",
+			args: args{expression: "max(min(1, 2), cos(0)^2)"},
+			want: "1 2 min/2 0 cos 2 ^ max/2",
+		},
+		{
+			name: "1+2 < 3*4 == !0",
+			args: args{expression: "1+2 < 3*4 == !0"},
+			want: "1 2 + 3 4 * < 0 not ==",
+		},
+		{
+			name: "1 || 0 && 0",
+			args: args{expression: "1 || 0 && 0"},
+			want: "1 0 0 && ||",
+		},
+		{
+			name: "1 > 2 ? 3 : 4 ? 5 : 6",
+			args: args{expression: "1 > 2 ? 3 : 4 ? 5 : 6"},
+			want: "1 2 > 3 4 5 6 if if",
+		},
+		{
+			name: "dot([1, 2], [[3, 4]]) * 2",
+			args: args{expression: "dot([1, 2], [[3, 4]]) * 2"},
+			want: "1 2 list/2 3 4 list/2 list/1 dot 2 *",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,256 @@
+package orchestratorutils
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// constants встроенные математические константы
+var constants = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"tau": 2 * math.Pi,
+}
+
+// imaginaryUnit суффикс мнимой части комплексного числа: 3+4i
+const imaginaryUnit = "i"
+
+// Dimension размерность величины: степени килограмма, метра и секунды. Нулевое значение - безразмерная величина
+type Dimension [3]int
+
+var baseUnits = [3]string{"kg", "m", "s"}
+
+// unit единица измерения: размерность и множитель перевода в единицы СИ (записан десятичной строкой,
+// чтобы перевод был точным и в режиме ModeExact)
+type unit struct {
+	dim    Dimension
+	factor string
+}
+
+// units суффиксы единиц измерения, допустимые после числа: 5km, 300m, 2h
+var units = map[string]unit{
+	"mm":  {dim: Dimension{0, 1, 0}, factor: "0.001"},
+	"cm":  {dim: Dimension{0, 1, 0}, factor: "0.01"},
+	"m":   {dim: Dimension{0, 1, 0}, factor: "1"},
+	"km":  {dim: Dimension{0, 1, 0}, factor: "1000"},
+	"ms":  {dim: Dimension{0, 0, 1}, factor: "0.001"},
+	"s":   {dim: Dimension{0, 0, 1}, factor: "1"},
+	"min": {dim: Dimension{0, 0, 1}, factor: "60"},
+	"h":   {dim: Dimension{0, 0, 1}, factor: "3600"},
+	"g":   {dim: Dimension{1, 0, 0}, factor: "0.001"},
+	"kg":  {dim: Dimension{1, 0, 0}, factor: "1"},
+}
+
+// String записывает размерность в единицах СИ: "m", "m/s", "kg*m/s^2", "1/s". Для безразмерной величины - ""
+func (d Dimension) String() string {
+	var numerator, denominator []string
+	for i, power := range d {
+		switch {
+		case power == 1 || power == -1:
+			if power > 0 {
+				numerator = append(numerator, baseUnits[i])
+			} else {
+				denominator = append(denominator, baseUnits[i])
+			}
+		case power > 1:
+			numerator = append(numerator, baseUnits[i]+"^"+strconv.Itoa(power))
+		case power < -1:
+			denominator = append(denominator, baseUnits[i]+"^"+strconv.Itoa(-power))
+		}
+	}
+	if len(numerator) == 0 && len(denominator) == 0 {
+		return ""
+	}
+	result := strings.Join(numerator, "*")
+	if result == "" {
+		result = "1"
+	}
+	if len(denominator) > 0 {
+		result += "/" + strings.Join(denominator, "*")
+	}
+	return result
+}
+
+func (d Dimension) add(other Dimension, sign int) Dimension {
+	for i := range d {
+		d[i] += sign * other[i]
+	}
+	return d
+}
+
+func (d Dimension) scale(k int) Dimension {
+	for i := range d {
+		d[i] *= k
+	}
+	return d
+}
+
+func (d Dimension) halve() Dimension {
+	for i := range d {
+		d[i] /= 2
+	}
+	return d
+}
+
+// withUnit переводит число с суффиксом единицы измерения в единицы СИ: 5km -> 5000 m
+func withUnit(number *NumberNode, u unit) (*NumberNode, error) {
+	value, ok := new(big.Rat).SetString(number.Literal)
+	if !ok {
+		return nil, &ParseError{Token: number.Literal, Column: number.Col, Message: "invalid number"}
+	}
+	factor, _ := new(big.Rat).SetString(u.factor)
+	value.Mul(value, factor)
+	converted, _ := value.Float64()
+	return &NumberNode{Value: converted, Literal: decimalString(value), Col: number.Col, Dim: u.dim}, nil
+}
+
+// decimalString записывает конечную десятичную дробь без потери точности и незначащих нулей
+func decimalString(value *big.Rat) string {
+	digits := 0
+	for scaled := new(big.Rat).Set(value); !scaled.IsInt(); digits++ {
+		scaled.Mul(scaled, big.NewRat(10, 1))
+	}
+	return value.FloatString(digits)
+}
+
+// inferDimension проверяет согласованность единиц измерения в выражении и возвращает размерность результата.
+// Складывать, вычитать и сравнивать можно только величины одной размерности, аргументы log, sin, cos и
+// показатель степени должны быть безразмерными. При ошибке возвращает *ParseError с позицией операции
+func inferDimension(node Node) (Dimension, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return n.Dim, nil
+	case *UnaryNode:
+		dim, err := inferDimension(n.Operand)
+		if n.Op == "not" {
+			return Dimension{}, err
+		}
+		return dim, err
+	case *BinaryNode:
+		left, err := inferDimension(n.Left)
+		if err != nil {
+			return Dimension{}, err
+		}
+		right, err := inferDimension(n.Right)
+		if err != nil {
+			return Dimension{}, err
+		}
+		switch n.Op {
+		case "*":
+			return left.add(right, 1), nil
+		case "/":
+			return left.add(right, -1), nil
+		case "^":
+			return powerDimension(n, left, right)
+		case "//", "%":
+			// делитель без единиц сохраняет единицы делимого, как и умножение: 10m // 3 = 3m, 10m % 3 = 1m
+			if right == (Dimension{}) {
+				return left, nil
+			}
+		}
+		if left != right {
+			return Dimension{}, incompatibleUnits(n.token(), n.Col, left, right)
+		}
+		switch n.Op {
+		case "+", "-", "%":
+			return left, nil
+		}
+		// сравнения, логические операции и // дают безразмерный результат
+		return Dimension{}, nil
+	case *CallNode:
+		return callDimension(n)
+	}
+	return Dimension{}, nil
+}
+
+func powerDimension(n *BinaryNode, base, exponent Dimension) (Dimension, error) {
+	if exponent != (Dimension{}) {
+		return Dimension{}, &ParseError{Token: n.Op, Column: n.Col, Message: "exponent must be dimensionless"}
+	}
+	if base == (Dimension{}) {
+		return base, nil
+	}
+	power, ok := integerConstant(n.Right)
+	if !ok {
+		return Dimension{}, &ParseError{Token: n.Op, Column: n.Col, Message: "exponent of a quantity with units must be an integer number"}
+	}
+	return base.scale(power), nil
+}
+
+// integerConstant возвращает значение целого числа, записанного в выражении (2, -2, +2)
+func integerConstant(node Node) (int, bool) {
+	switch n := node.(type) {
+	case *NumberNode:
+		if n.Value != math.Trunc(n.Value) || math.Abs(n.Value) > math.MaxInt32 {
+			return 0, false
+		}
+		return int(n.Value), true
+	case *UnaryNode:
+		value, ok := integerConstant(n.Operand)
+		switch n.Op {
+		case "neg":
+			return -value, ok
+		case "pos":
+			return value, ok
+		}
+	}
+	return 0, false
+}
+
+func callDimension(n *CallNode) (Dimension, error) {
+	dims := make([]Dimension, len(n.Args))
+	for i, arg := range n.Args {
+		dim, err := inferDimension(arg)
+		if err != nil {
+			return Dimension{}, err
+		}
+		dims[i] = dim
+	}
+	switch n.Name {
+	case "sqrt":
+		for _, power := range dims[0] {
+			if power%2 != 0 {
+				return Dimension{}, &ParseError{Token: n.Name, Column: n.Col, Message: "cannot take square root of units " + dims[0].String()}
+			}
+		}
+		return dims[0].halve(), nil
+	case "abs", "round":
+		if len(dims) > 1 && dims[1] != (Dimension{}) {
+			return Dimension{}, &ParseError{Token: n.Name, Column: n.Col, Message: "number of digits must be dimensionless"}
+		}
+		return dims[0], nil
+	case "min", "max":
+		for _, dim := range dims[1:] {
+			if dim != dims[0] {
+				return Dimension{}, incompatibleUnits(n.Name, n.Col, dims[0], dim)
+			}
+		}
+		return dims[0], nil
+	case "if":
+		if dims[1] != dims[2] {
+			return Dimension{}, incompatibleUnits(n.token(), n.Col, dims[1], dims[2])
+		}
+		return dims[1], nil
+	}
+	// log, sin, cos
+	for _, dim := range dims {
+		if dim != (Dimension{}) {
+			return Dimension{}, &ParseError{Token: n.Name, Column: n.Col, Message: "argument must be dimensionless"}
+		}
+	}
+	return Dimension{}, nil
+}
+
+func incompatibleUnits(op string, column int, left, right Dimension) *ParseError {
+	return &ParseError{Token: op, Column: column, Message: "incompatible units " + unitName(left) + " and " + unitName(right)}
+}
+
+// unitName название размерности для сообщения об ошибке
+func unitName(d Dimension) string {
+	if d == (Dimension{}) {
+		return "dimensionless"
+	}
+	return d.String()
+}
@@ -0,0 +1,172 @@
+package orchestratorutils
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestExpressionTreeUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       []float64
+		wantUnit   string
+	}{
+		{
+			name:       "5km + 300m",
+			expression: "5km + 300m",
+			want:       []float64{5000, 300},
+			wantUnit:   "m",
+		},
+		{
+			name:       "2h * 3",
+			expression: "2h * 3",
+			want:       []float64{7200, 3},
+			wantUnit:   "s",
+		},
+		{
+			name:       "10 m / 2 s",
+			expression: "10 m / 2 s",
+			want:       []float64{10, 2},
+			wantUnit:   "m/s",
+		},
+		{
+			name:       "2kg * 3m / (1s)^2",
+			expression: "2kg * 3m / (1s)^2",
+			want:       []float64{2, 3, 1, 2},
+			wantUnit:   "kg*m/s^2",
+		},
+		{
+			name:       "sqrt(16m^2) + 1cm",
+			expression: "sqrt(16m^2) + 1cm",
+			want:       []float64{16, 2, 0.01},
+			wantUnit:   "m",
+		},
+		{
+			name:       "1 / 2min",
+			expression: "1 / 2min",
+			want:       []float64{1, 120},
+			wantUnit:   "1/s",
+		},
+		{
+			name:       "5km > 300m",
+			expression: "5km > 300m",
+			want:       []float64{5000, 300},
+			wantUnit:   "",
+		},
+		{
+			name:       "10m // 3",
+			expression: "10m // 3",
+			want:       []float64{10, 3},
+			wantUnit:   "m",
+		},
+		{
+			name:       "10m % 3",
+			expression: "10m % 3",
+			want:       []float64{10, 3},
+			wantUnit:   "m",
+		},
+		{
+			name:       "10m // 3m",
+			expression: "10m // 3m",
+			want:       []float64{10, 3},
+			wantUnit:   "",
+		},
+		{
+			name:       "константы",
+			expression: "2 * pi + e - tau",
+			want:       []float64{2, math.Pi, math.E, 2 * math.Pi},
+			wantUnit:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr := &models.Expression{Value: tt.expression}
+			root, err := ExpressionTree(expr, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, numbers(root))
+			assert.Equal(t, tt.wantUnit, expr.ResultUnit)
+		})
+	}
+}
+
+func TestExpressionTreeUnitsError(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantToken  string
+		wantColumn int
+	}{
+		{
+			name:       "5km + 3s",
+			expression: "5km + 3s",
+			wantToken:  "+",
+			wantColumn: 5,
+		},
+		{
+			name:       "5km + 3",
+			expression: "5km + 3",
+			wantToken:  "+",
+			wantColumn: 5,
+		},
+		{
+			name:       "sin(2m)",
+			expression: "sin(2m)",
+			wantToken:  "sin",
+			wantColumn: 1,
+		},
+		{
+			name:       "2m ^ x",
+			expression: "2m ^ (1/2)",
+			wantToken:  "^",
+			wantColumn: 4,
+		},
+		{
+			name:       "max(1m, 1s)",
+			expression: "max(1m, 1s)",
+			wantToken:  "max",
+			wantColumn: 1,
+		},
+		{
+			name:       "10m % 3s",
+			expression: "10m % 3s",
+			wantToken:  "%",
+			wantColumn: 5,
+		},
+		{
+			name:       "1 && 2m",
+			expression: "1 && 2m",
+			wantToken:  "&&",
+			wantColumn: 3,
+		},
+		{
+			name:       "0 || 2m",
+			expression: "0 || 2m",
+			wantToken:  "||",
+			wantColumn: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExpressionTree(&models.Expression{Value: tt.expression}, nil)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ExpressionTree() error = %v, want *ParseError", err)
+			}
+			assert.Equal(t, tt.wantToken, parseErr.Token)
+			assert.Equal(t, tt.wantColumn, parseErr.Column)
+		})
+	}
+}
+
+func TestUnitsExactLiteral(t *testing.T) {
+	root, err := Parse("1.5km + 25cm")
+	assert.NoError(t, err)
+	sum := root.(*BinaryNode)
+	assert.Equal(t, "1500", sum.Left.(*NumberNode).Literal)
+	assert.Equal(t, "0.25", sum.Right.(*NumberNode).Literal)
+}
@@ -1,16 +1,13 @@
 package orchestratorutils
 
-import (
-	"go/parser"
-	"log"
-)
-
-// ValidateExpression валидация выражения с помощью сторонней библиотеки
-func ValidateExpression(expression string) bool {
-	_, err := parser.ParseExpr(expression)
+// ValidateExpression проверяет, что выражение соответствует грамматике калькулятора
+// и для всех его переменных переданы значения в bindings.
+// При ошибке возвращает *ParseError с лексемой и позицией ошибки
+func ValidateExpression(expression string, bindings map[string]float64) error {
+	root, err := ParseCached(expression)
 	if err != nil {
-		log.Printf("parse error")
-		return false
+		return err
 	}
-	return true
+	_, err = Bind(root, bindings)
+	return err
 }
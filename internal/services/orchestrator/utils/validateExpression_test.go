@@ -5,6 +5,7 @@ import "testing"
 func Test_validateExpression(t *testing.T) {
 	type args struct {
 		expression string
+		bindings   map[string]float64
 	}
 	tests := []struct {
 		name string
@@ -46,6 +47,77 @@ func Test_validateExpression(t *testing.T) {
 			args: args{expression: "10/0"},
 			want: true,
 		},
+		{
+			name: "19.99*3",
+			args: args{expression: "19.99*3"},
+			want: true,
+		},
+		{
+			name: ".5+1.",
+			args: args{expression: ".5+1."},
+			want: true,
+		},
+		{
+			name: "2.5e-3/1e3",
+			args: args{expression: "2.5e-3/1e3"},
+			want: true,
+		},
+		{
+			name: "1.2.3",
+			args: args{expression: "1.2.3"},
+			want: false,
+		},
+		{
+			name: "1.05^10",
+			args: args{expression: "1.05^10"},
+			want: true,
+		},
+		{
+			name: "2**3**2",
+			args: args{expression: "2**3**2"},
+			want: true,
+		},
+		{
+			name: "10%3-10//3",
+			args: args{expression: "10%3-10//3"},
+			want: true,
+		},
+		{
+			name: "10///3",
+			args: args{expression: "10///3"},
+			want: false,
+		},
+		{
+			name: "sqrt(16)+max(3,7,2)",
+			args: args{expression: "sqrt(16)+max(3,7,2)"},
+			want: true,
+		},
+		{
+			name: "log(100, 10)*sin(0)-cos(0)",
+			args: args{expression: "log(100, 10)*sin(0)-cos(0)"},
+			want: true,
+		},
+		{
+			name: "sqrt",
+			args: args{expression: "sqrt"},
+			want: false,
+		},
+		{
+			name: "price * qty * (1 + tax)",
+			args: args{
+				expression: "price * qty * (1 + tax)",
+				bindings:   map[string]float64{"price": 19.99, "qty": 3, "tax": 0.2},
+			},
+			want: true,
+		},
+		{
+			name: "price * qty без значения qty",
+			args: args{
+				expression: "price * qty",
+				bindings:   map[string]float64{"price": 19.99},
+			},
+			want: false,
+		},
 		{
 			name: "10/",
 			args: args{expression: "10/"},
@@ -56,11 +128,80 @@ func Test_validateExpression(t *testing.T) {
 			args: args{expression: "(2+2("},
 			want: false,
 		},
+		{
+			name: "a.b",
+			args: args{expression: "a.b"},
+			want: false,
+		},
+		{
+			name: "x[1]",
+			args: args{expression: "x[1]"},
+			want: false,
+		},
+		{
+			// вызов формулы пользователя, ее наличие проверяется при раскрытии формул
+			name: "f()",
+			args: args{expression: "f()"},
+			want: true,
+		},
+		{
+			name: "0x10",
+			args: args{expression: "0x10"},
+			want: false,
+		},
+		{
+			name: "1<<3",
+			args: args{expression: "1<<3"},
+			want: false,
+		},
+		{
+			name: "1&2",
+			args: args{expression: "1&2"},
+			want: false,
+		},
+		{
+			name: "1&&2",
+			args: args{expression: "1&&2"},
+			want: true,
+		},
+		{
+			name: "x >= 18 && !(y == 0 || z != 1) ? 1 : 0",
+			args: args{
+				expression: "x >= 18 && !(y == 0 || z != 1) ? 1 : 0",
+				bindings:   map[string]float64{"x": 20, "y": 1, "z": 1},
+			},
+			want: true,
+		},
+		{
+			name: "if(1 < 2, 3, 4)",
+			args: args{expression: "if(1 < 2, 3, 4)"},
+			want: true,
+		},
+		{
+			name: "1 ? 2",
+			args: args{expression: "1 ? 2"},
+			want: false,
+		},
+		{
+			name: "1 < 2 < 3 допустимо, сравнения левоассоциативны",
+			args: args{expression: "1 < 2 < 3"},
+			want: true,
+		},
+		{
+			name: "if(1, 2)",
+			args: args{expression: "if(1, 2)"},
+			want: false,
+		},
+		{
+			name: "()",
+			args: args{expression: "()"},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ValidateExpression(tt.args.expression); got != tt.want {
-				t.Errorf("validateExpression() = %v, want %v", got, tt.want)
+			if got := ValidateExpression(tt.args.expression, tt.args.bindings); (got == nil) != tt.want {
+				t.Errorf("validateExpression() error = %v, want valid %v", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,214 @@
+package orchestrator
+
+import (
+	"context"
+	"log"
+	"myproject/internal/models"
+	"sync"
+	"time"
+)
+
+// watchBuffer размер буфера канала подписчика. Если подписчик не успевает читать изменения,
+// самое старое изменение отбрасывается: последнее состояние выражения подписчик получит всегда
+const watchBuffer = 16
+
+// watchInterval за это время уведомления об изменениях одного выражения объединяются в одно:
+// подписчик получает последнее состояние выражения, а не каждый подсчитанный subexpression
+const watchInterval = 100 * time.Millisecond
+
+// watcher подписчик на изменения одного выражения (expressionId) или всех выражений пользователя (userId)
+type watcher struct {
+	expressionId string
+	userId       string
+	updates      chan *models.Expression
+}
+
+// watchers подписчики на изменения выражений по id выражения и по пользователю
+type watchers struct {
+	mu           sync.Mutex
+	byExpression map[string]map[*watcher]struct{}
+	byUser       map[string]map[*watcher]struct{}
+}
+
+func newWatchers() *watchers {
+	return &watchers{
+		byExpression: make(map[string]map[*watcher]struct{}),
+		byUser:       make(map[string]map[*watcher]struct{}),
+	}
+}
+
+func (w *watchers) subscribers(wt *watcher) map[string]map[*watcher]struct{} {
+	if wt.expressionId != "" {
+		return w.byExpression
+	}
+	return w.byUser
+}
+
+func (w *watchers) key(wt *watcher) string {
+	if wt.expressionId != "" {
+		return wt.expressionId
+	}
+	return wt.userId
+}
+
+func (w *watchers) add(wt *watcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subscribers, key := w.subscribers(wt), w.key(wt)
+	if subscribers[key] == nil {
+		subscribers[key] = make(map[*watcher]struct{})
+	}
+	subscribers[key][wt] = struct{}{}
+}
+
+// remove отписывает подписчика и закрывает его канал, повторный вызов ничего не делает
+func (w *watchers) remove(wt *watcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.removeLocked(wt)
+}
+
+func (w *watchers) removeLocked(wt *watcher) {
+	subscribers, key := w.subscribers(wt), w.key(wt)
+	if _, ok := subscribers[key][wt]; !ok {
+		return
+	}
+	delete(subscribers[key], wt)
+	if len(subscribers[key]) == 0 {
+		delete(subscribers, key)
+	}
+	close(wt.updates)
+}
+
+// watched сообщает, есть ли подписчики на выражение change или на выражения его пользователя
+func (w *watchers) watched(change *models.ExpressionChange) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.byExpression[change.Id]) > 0 || len(w.byUser[change.UserId]) > 0
+}
+
+// publish отправляет изменение выражения его подписчикам и подписчикам его пользователя
+func (w *watchers) publish(expr *models.Expression) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for wt := range w.byExpression[expr.Id] {
+		w.sendLocked(wt, expr)
+	}
+	for wt := range w.byUser[expr.UserId] {
+		w.sendLocked(wt, expr)
+	}
+}
+
+// send отправляет подписчику текущее состояние выражения
+func (w *watchers) send(wt *watcher, expr *models.Expression) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.sendLocked(wt, expr)
+}
+
+// sendLocked отправляет изменение подписчику, не блокируясь. Подписчик на одно выражение
+// отписывается, когда выражение посчитано или завершилось с ошибкой
+func (w *watchers) sendLocked(wt *watcher, expr *models.Expression) {
+	if _, ok := w.subscribers(wt)[w.key(wt)][wt]; !ok {
+		return
+	}
+	select {
+	case wt.updates <- expr:
+	default:
+		select {
+		case <-wt.updates:
+		default:
+		}
+		wt.updates <- expr
+	}
+	if wt.expressionId != "" && expr.State != models.ExpressionInProgress {
+		w.removeLocked(wt)
+	}
+}
+
+// watch подписывает wt до отмены ctx
+func (w *watchers) watch(ctx context.Context, wt *watcher) {
+	w.add(wt)
+	go func() {
+		<-ctx.Done()
+		w.remove(wt)
+	}()
+}
+
+func (o *Orchestrator) WatchExpression(ctx context.Context, id, userId string) (<-chan *models.Expression, error) {
+	wt := &watcher{expressionId: id, updates: make(chan *models.Expression, watchBuffer)}
+	// подписываемся до чтения текущего состояния, чтобы не пропустить изменения между ними
+	o.watchers.watch(ctx, wt)
+	expr, err := o.expressionRepository.GetExpressionById(ctx, id, userId)
+	if err != nil {
+		o.watchers.remove(wt)
+		return nil, err
+	}
+	o.watchers.send(wt, expr)
+	return wt.updates, nil
+}
+
+func (o *Orchestrator) WatchMyExpressions(ctx context.Context, userId string) <-chan *models.Expression {
+	wt := &watcher{userId: userId, updates: make(chan *models.Expression, watchBuffer)}
+	o.watchers.watch(ctx, wt)
+	return wt.updates
+}
+
+// broadcastExpressions рассылает подписчикам изменения выражений, о которых сообщает триггер бд.
+// Выражение читается из бд раз в watchInterval и только если на него есть подписчики
+func (o *Orchestrator) broadcastExpressions() {
+	for batch := range coalesceChanges(o.expressionRepository.GetExpressionChanges(), watchInterval, o.watchers.watched) {
+		for _, change := range batch {
+			expr, err := o.expressionRepository.GetExpressionById(context.Background(), change.Id, change.UserId)
+			if err != nil {
+				log.Printf("error get expression by id %s: %e", change.Id, err)
+				continue
+			}
+			o.watchers.publish(expr)
+		}
+	}
+}
+
+// coalesceChanges собирает уведомления из changes и раз в interval отправляет их пачкой, в которой каждое
+// выражение встречается один раз. Уведомления, для которых watched возвращает false, отбрасываются.
+// Канал пачек закрывается после закрытия changes
+func coalesceChanges(changes <-chan *models.ExpressionChange, interval time.Duration, watched func(*models.ExpressionChange) bool) <-chan []*models.ExpressionChange {
+	batches := make(chan []*models.ExpressionChange)
+	go func() {
+		defer close(batches)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		pending := make(map[string]struct{})
+		var batch []*models.ExpressionChange
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			batches <- batch
+			pending, batch = make(map[string]struct{}), nil
+		}
+		for {
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					flush()
+					return
+				}
+				if _, ok := pending[change.Id]; ok || !watched(change) {
+					continue
+				}
+				pending[change.Id] = struct{}{}
+				batch = append(batch, change)
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return batches
+}
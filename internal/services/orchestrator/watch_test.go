@@ -0,0 +1,86 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"myproject/internal/models"
+)
+
+func TestWatchers(t *testing.T) {
+	w := newWatchers()
+	ctx, cancel := context.WithCancel(context.Background())
+	expression := &watcher{expressionId: "1", updates: make(chan *models.Expression, watchBuffer)}
+	user := &watcher{userId: "u", updates: make(chan *models.Expression, watchBuffer)}
+	w.watch(ctx, expression)
+	w.watch(ctx, user)
+
+	w.publish(&models.Expression{Id: "1", UserId: "u", State: models.ExpressionInProgress, SubExpressionsDone: 1, SubExpressionsTotal: 2})
+	w.publish(&models.Expression{Id: "2", UserId: "u", State: models.ExpressionInProgress})
+	w.publish(&models.Expression{Id: "1", UserId: "u", State: models.ExpressionOk, Result: 5})
+
+	// подписчик на выражение получает только его изменения, после результата канал закрывается
+	var got []*models.Expression
+	for expr := range expression.updates {
+		got = append(got, expr)
+	}
+	assert.Len(t, got, 2)
+	assert.Equal(t, 1, got[0].SubExpressionsDone)
+	assert.Equal(t, 5.0, got[1].Result)
+
+	// подписчик на пользователя получает изменения всех его выражений до отмены ctx
+	assert.Len(t, user.updates, 3)
+	cancel()
+	for range user.updates {
+	}
+}
+
+func TestWatchersSlowSubscriber(t *testing.T) {
+	w := newWatchers()
+	wt := &watcher{expressionId: "1", updates: make(chan *models.Expression, watchBuffer)}
+	w.watch(context.Background(), wt)
+	for i := 0; i < watchBuffer*2; i++ {
+		w.publish(&models.Expression{Id: "1", State: models.ExpressionInProgress, SubExpressionsDone: i})
+	}
+	w.publish(&models.Expression{Id: "1", State: models.ExpressionError})
+
+	// старые изменения отброшены, последнее состояние сохранено
+	var last *models.Expression
+	for expr := range wt.updates {
+		last = expr
+	}
+	assert.Equal(t, models.ExpressionState(models.ExpressionError), last.State)
+}
+
+func TestCoalesceChanges(t *testing.T) {
+	changes := make(chan *models.ExpressionChange)
+	watched := func(change *models.ExpressionChange) bool { return change.UserId == "u" }
+	batches := coalesceChanges(changes, time.Hour, watched)
+
+	changes <- &models.ExpressionChange{Id: "1", UserId: "u"}
+	changes <- &models.ExpressionChange{Id: "2", UserId: "other"}
+	changes <- &models.ExpressionChange{Id: "1", UserId: "u"}
+	changes <- &models.ExpressionChange{Id: "3", UserId: "u"}
+	close(changes)
+
+	// повторное уведомление о выражении 1 объединено с первым, выражение 2 без подписчиков отброшено
+	var got [][]*models.ExpressionChange
+	for batch := range batches {
+		got = append(got, batch)
+	}
+	assert.Equal(t, [][]*models.ExpressionChange{{{Id: "1", UserId: "u"}, {Id: "3", UserId: "u"}}}, got)
+}
+
+func TestWatchersWatched(t *testing.T) {
+	w := newWatchers()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.watch(ctx, &watcher{expressionId: "1", updates: make(chan *models.Expression, watchBuffer)})
+	w.watch(ctx, &watcher{userId: "u", updates: make(chan *models.Expression, watchBuffer)})
+
+	assert.True(t, w.watched(&models.ExpressionChange{Id: "1", UserId: "other"}))
+	assert.True(t, w.watched(&models.ExpressionChange{Id: "2", UserId: "u"}))
+	assert.False(t, w.watched(&models.ExpressionChange{Id: "2", UserId: "other"}))
+}